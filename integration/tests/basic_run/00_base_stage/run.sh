@@ -8,4 +8,5 @@ dud stage gen -o base.txt 'seq 1 20 > base.txt' > base.yaml
 
 dud stage add base.yaml
 
-dud run base.yaml
+# Run logs are named by timestamp, so don't keep them in the checked filesystem.
+dud run --no-log base.yaml
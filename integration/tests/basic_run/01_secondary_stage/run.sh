@@ -15,4 +15,5 @@ dud stage gen -i base.txt -o second.txt >> second.yaml
 
 dud stage add second.yaml
 
-dud run second.yaml
+# Run logs are named by timestamp, so don't keep them in the checked filesystem.
+dud run --no-log second.yaml
@@ -0,0 +1,36 @@
+#!/bin/bash
+set -euo pipefail
+
+dud init
+
+echo 'foo' > foo.txt
+
+dud stage gen -o foo.txt > foo.yaml
+
+dud stage add foo.yaml
+
+dud commit
+
+# Only match objects, not the cache's lock files.
+old_object="$(find .dud/cache -path '.dud/cache/[0-9a-f][0-9a-f]/*' -type f)"
+
+rm foo.txt
+echo 'bar' > foo.txt
+
+dud commit
+
+dud gc --dry-run
+
+if ! test -f "$old_object"; then
+    echo 1>&2 'TEST FAIL: expected dry run to leave the cache untouched'
+    exit 1
+fi
+
+dud gc
+
+if test -f "$old_object"; then
+    echo 1>&2 'TEST FAIL: expected unreferenced object to be removed'
+    exit 1
+fi
+
+dud status | grep -q 'up-to-date (link)'
@@ -10,7 +10,7 @@ dud stage add sleep.yaml
 dud run &
 dud_run_pid=$!
 
-# Give 'dud run' enough time to create the lock file.
+# Give 'dud run' enough time to take the lock.
 sleep 0.05
 
 if dud status; then
@@ -23,15 +23,29 @@ fi
 kill -SIGTERM "$dud_run_pid"
 wait
 
-# Having both of these tests is a bit redundant, but better safe than sorry.
 err=0
-if ! test -f .dud/lock; then
-    echo 1>&2 'TEST FAIL: expected interrupted dud command to leave its lock file'
+if ! dud status; then
+    echo 1>&2 'TEST FAIL: expected interrupted dud command to release its lock'
     err=$((err + 1))
 fi
 
-if dud status; then
-    echo 1>&2 'TEST FAIL: expected orphaned lock file to cause new dud command to fail'
+# Hold a shared lock, as a read-only dud command would.
+flock --shared .dud/lock sleep 1 &
+
+sleep 0.05
+
+if ! dud status; then
+    echo 1>&2 'TEST FAIL: expected read-only commands to run concurrently'
+    err=$((err + 1))
+fi
+
+if dud stage rm sleep.yaml; then
+    echo 1>&2 'TEST FAIL: expected stage rm to fail while the project is in use'
+    err=$((err + 1))
+fi
+
+if ! dud --lock-timeout 3s stage rm sleep.yaml; then
+    echo 1>&2 'TEST FAIL: expected stage rm to wait for the project lock'
     err=$((err + 1))
 fi
 
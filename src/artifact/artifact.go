@@ -9,6 +9,7 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"github.com/kevin-hanselman/dud/src/fsutil"
+	"github.com/kevin-hanselman/dud/src/glob"
 )
 
 // An Artifact is a file or directory that is tracked by Dud.
@@ -24,10 +25,25 @@ type Artifact struct {
 	// If DisableRecursion is true then the Artifact does not recurse
 	// sub-directories.
 	DisableRecursion bool `yaml:"disable-recursion,omitempty" json:"disable-recursion,omitempty"`
+	// Exclude lists gitignore-style patterns of files and sub-directories to
+	// leave out of a directory Artifact. Patterns are relative to the
+	// Artifact's directory, and patterns in a .dudignore file at the root of
+	// the directory are also excluded.
+	Exclude []string `yaml:",omitempty" json:"exclude,omitempty"`
 	// If SkipCache is true then the Artifact is not stored in the Cache. When
 	// the Artifact is committed, its checksum is updated, but the Artifact is
 	// not moved to the Cache. The checkout operation is a no-op.
 	SkipCache bool `yaml:"skip-cache,omitempty" json:"skip-cache,omitempty"`
+	// If IsChunked is true then the Artifact is a file stored in the Cache as
+	// a series of content-defined chunks, and Checksum locates the list of
+	// chunks rather than the file itself.
+	IsChunked bool `yaml:"is-chunked,omitempty" json:"is-chunked,omitempty"`
+	// If Executable is true then the Artifact is a file with its executable
+	// bit set. Like Checksum, it is set when the Artifact is committed.
+	Executable bool `yaml:",omitempty" json:"executable,omitempty"`
+	// If IsLink is true then the Artifact is a symbolic link inside a
+	// directory Artifact, and Checksum locates the link's target.
+	IsLink bool `yaml:"is-link,omitempty" json:"is-link,omitempty"`
 }
 
 type oldArtifact struct {
@@ -61,10 +77,23 @@ func (a *Artifact) UnmarshalJSON(b []byte) error {
 	if err := json.Unmarshal(b, &old); err != nil {
 		return err
 	}
-	*a = Artifact(old)
+	*a = Artifact{
+		Checksum:         old.Checksum,
+		Path:             old.Path,
+		IsDir:            old.IsDir,
+		DisableRecursion: old.DisableRecursion,
+		SkipCache:        old.SkipCache,
+	}
 	return nil
 }
 
+// IsGlob returns true if the Artifact's Path is a glob pattern. A glob
+// Artifact is a directory Artifact holding the files that match the pattern
+// (see glob.Split).
+func (a Artifact) IsGlob() bool {
+	return glob.HasMeta(a.Path)
+}
+
 // Status captures an Artifact's status as it pertains to a Cache and a workspace.
 type Status struct {
 	Artifact
@@ -80,6 +109,14 @@ type Status struct {
 	// are identical. For links, true means that the workspace link points to
 	// the correct cache file.
 	ContentsMatch bool
+	// Sparse is true if the Artifact is missing from the workspace because
+	// it's excluded from a sparse checkout. Sparse Artifacts are considered
+	// up-to-date, so ContentsMatch is also true.
+	Sparse bool
+	// ModeChanged is true if the workspace file's contents are up-to-date,
+	// but its executable bit differs from the Artifact's. ContentsMatch is
+	// false in this case.
+	ModeChanged bool
 	// ChildrenStatus holds the status of any child artifacts, mapped to their
 	// respective file paths.
 	ChildrenStatus map[string]*Status
@@ -92,8 +129,12 @@ func (stat Status) dirStatusCounts(counts map[string]int) {
 	} else {
 		counts["directory"]++
 	}
+	stat.childStatusCounts(counts)
+}
+
+func (stat Status) childStatusCounts(counts map[string]int) {
 	for _, childStatus := range stat.ChildrenStatus {
-		if childStatus.IsDir {
+		if childStatus.IsDir && !childStatus.Sparse {
 			childStatus.dirStatusCounts(counts)
 		} else {
 			counts[childStatus.String()]++
@@ -125,6 +166,9 @@ func sortCounts(counts map[string]int) []string {
 }
 
 func (stat Status) String() string {
+	if stat.Sparse {
+		return "not checked out (sparse)"
+	}
 	isDir := stat.WorkspaceFileStatus == fsutil.StatusDirectory
 	isAbsent := stat.WorkspaceFileStatus == fsutil.StatusAbsent
 	if (stat.IsDir != isDir) && !isAbsent {
@@ -150,6 +194,8 @@ func (stat Status) String() string {
 			if stat.ChecksumInCache || stat.SkipCache {
 				if stat.ContentsMatch {
 					out.WriteString("up-to-date")
+				} else if stat.ModeChanged {
+					out.WriteString("mode changed")
 				} else {
 					out.WriteString("modified")
 				}
@@ -165,11 +211,23 @@ func (stat Status) String() string {
 		return out.String()
 
 	case fsutil.StatusLink:
+		if stat.IsLink && stat.HasChecksum {
+			if !stat.ChecksumInCache {
+				return "symlink missing from cache"
+			}
+			if stat.ContentsMatch {
+				return "up-to-date (symlink)"
+			}
+			return "modified (symlink)"
+		}
 		if stat.HasChecksum {
 			if stat.ChecksumInCache {
 				if stat.ContentsMatch {
 					return "up-to-date (link)"
 				}
+				if stat.ModeChanged {
+					return "mode changed (link)"
+				}
 				return "incorrect link"
 			}
 			return "broken link"
@@ -182,7 +240,16 @@ func (stat Status) String() string {
 
 	if stat.IsDir {
 		counts := make(map[string]int)
-		stat.dirStatusCounts(counts)
+		if stat.IsGlob() {
+			// A glob's base directory isn't part of the glob, so only the
+			// files matching the glob are counted.
+			if len(stat.ChildrenStatus) == 0 {
+				return "no matching files"
+			}
+			stat.childStatusCounts(counts)
+		} else {
+			stat.dirStatusCounts(counts)
+		}
 		countStrings := make([]string, len(counts))
 		for i, status := range sortCounts(counts) {
 			countStrings[i] = fmt.Sprintf("%dx %s", counts[status], status)
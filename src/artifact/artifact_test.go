@@ -76,6 +76,41 @@ func TestArtifactStatusString(t *testing.T) {
 		}
 	})
 
+	t.Run("regular file cached mode changed", func(t *testing.T) {
+		status := Status{
+			Artifact:            Artifact{Executable: true},
+			WorkspaceFileStatus: fsutil.StatusRegularFile,
+			HasChecksum:         true,
+			ChecksumInCache:     true,
+			ContentsMatch:       false,
+			ModeChanged:         true,
+		}
+
+		want := "mode changed"
+
+		got := status.String()
+		if got != want {
+			t.Fatalf("Status.String() got %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("symlink modified", func(t *testing.T) {
+		status := Status{
+			Artifact:            Artifact{IsLink: true},
+			WorkspaceFileStatus: fsutil.StatusLink,
+			HasChecksum:         true,
+			ChecksumInCache:     true,
+			ContentsMatch:       false,
+		}
+
+		want := "modified (symlink)"
+
+		got := status.String()
+		if got != want {
+			t.Fatalf("Status.String() got %#v, want %#v", got, want)
+		}
+	})
+
 	t.Run("regular file but IsDir true", func(t *testing.T) {
 		status := Status{
 			Artifact:            Artifact{SkipCache: false, IsDir: true},
@@ -163,6 +198,39 @@ func TestArtifactStatusString(t *testing.T) {
 		}
 	})
 
+	t.Run("sparse children", func(t *testing.T) {
+		status := Status{
+			Artifact:            Artifact{IsDir: true},
+			WorkspaceFileStatus: fsutil.StatusDirectory,
+			HasChecksum:         true,
+			ChecksumInCache:     true,
+			ContentsMatch:       true,
+			ChildrenStatus: map[string]*Status{
+				"a": &fileUpToDate,
+				"b": {
+					WorkspaceFileStatus: fsutil.StatusAbsent,
+					HasChecksum:         true,
+					ContentsMatch:       true,
+					Sparse:              true,
+				},
+				"c": {
+					Artifact:            Artifact{IsDir: true},
+					WorkspaceFileStatus: fsutil.StatusAbsent,
+					HasChecksum:         true,
+					ContentsMatch:       true,
+					Sparse:              true,
+				},
+			},
+		}
+
+		want := "2x not checked out (sparse), 1x directory, 1x up-to-date"
+
+		got := status.String()
+		if got != want {
+			t.Fatalf("Status.String() got %#v, want %#v", got, want)
+		}
+	})
+
 	t.Run("empty directory", func(t *testing.T) {
 		status := Status{
 			Artifact:            Artifact{IsDir: true},
@@ -206,6 +274,35 @@ func TestArtifactStatusString(t *testing.T) {
 		}
 	})
 
+	t.Run("glob", func(t *testing.T) {
+		status := Status{
+			Artifact:            Artifact{Path: "reports/*.html", IsDir: true},
+			WorkspaceFileStatus: fsutil.StatusDirectory,
+			HasChecksum:         true,
+			ChecksumInCache:     true,
+			ContentsMatch:       true,
+			ChildrenStatus: map[string]*Status{
+				"a.html": &fileUpToDate,
+				"b.html": &fileUpToDate,
+			},
+		}
+
+		want := "2x up-to-date"
+
+		got := status.String()
+		if got != want {
+			t.Fatalf("Status.String() got %#v, want %#v", got, want)
+		}
+
+		status.ChildrenStatus = nil
+		want = "no matching files"
+
+		got = status.String()
+		if got != want {
+			t.Fatalf("Status.String() got %#v, want %#v", got, want)
+		}
+	})
+
 	t.Run("directory but IsDir false", func(t *testing.T) {
 		status := Status{
 			Artifact:            Artifact{IsDir: false},
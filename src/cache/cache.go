@@ -5,13 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/cheggaaa/pb/v3"
 	"github.com/kevin-hanselman/dud/src/agglog"
 	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/checksum"
+	"github.com/kevin-hanselman/dud/src/glob"
 	"github.com/kevin-hanselman/dud/src/strategy"
 	"github.com/mattn/go-isatty"
 )
@@ -37,8 +41,9 @@ const (
 
 // These are somewhat arbitrary numbers. We need to profile more.
 var (
-	// The number of concurrent workers available to a top-level directory
-	// artifact and all its child artifacts.
+	// The number of concurrent workers available to all directory artifacts
+	// being committed, checked out, or checked at once with a LocalCache, and
+	// all their child artifacts (see LocalCache.sharedWorkers).
 	maxSharedWorkers = 64
 	// The number of concurrent workers available to each individual directory
 	// artifact (and not its children). Dedicated workers are necessary because
@@ -62,20 +67,144 @@ type Cache interface {
 		p *pb.ProgressBar,
 	) error
 	Status(workDir string, art artifact.Artifact, shortCircuit bool) (artifact.Status, error)
-	Fetch(remoteSrc string, arts map[string]*artifact.Artifact) error
-	Push(remoteDst string, arts map[string]*artifact.Artifact) error
+	Fetch(remote Remote, arts map[string]*artifact.Artifact) error
+	Push(remote Remote, arts map[string]*artifact.Artifact) error
 }
 
 // A LocalCache is a Cache that uses a directory on a local filesystem.
 type LocalCache struct {
 	dir string
+	// If true, new objects are stored compressed.
+	compress bool
+	// Files of at least this many bytes are stored in chunks. Zero disables
+	// chunking.
+	chunkThreshold int64
+	// If shared is true, new directories in the cache belong to group and
+	// are group-writable.
+	shared bool
+	group  int
+	// hash computes the checksums of new objects. Existing objects are always
+	// verified with the algorithm that computed their checksums.
+	hash *checksum.Algorithm
+	// If stats is not nil, it holds the checksums of workspace files that
+	// needn't be read again (see WithStatCache).
+	stats *StatCache
+	// If sparse is not nil, only the parts of Artifacts that match these
+	// patterns are fetched, pushed, and checked out (see WithSparse).
+	sparse []glob.Pattern
+	// sparseRoot is the workspace directory the sparse patterns are relative
+	// to. It's set by the operations that walk the workspace.
+	sparseRoot string
+	// artifactDir is the workspace path of the directory Artifact being
+	// committed or checked out. Symlinks inside it must not point outside of
+	// it.
+	artifactDir string
+	// ignore holds the exclude patterns of the directory Artifact at
+	// artifactDir.
+	ignore glob.IgnoreList
+	// include is the pattern of the glob Artifact being committed or checked
+	// out, relative to artifactDir. Files that don't match it are excluded.
+	include *glob.Pattern
+	// sharedWorkers holds a token for each worker shared by directory
+	// Artifacts (see maxSharedWorkers). It's shared by all copies of the
+	// LocalCache, so that Artifacts processed concurrently (e.g. by
+	// index.CommitConcurrently) share the same workers.
+	sharedWorkers chan struct{}
+}
+
+// A LocalCacheOption configures a LocalCache. See NewLocalCache.
+type LocalCacheOption func(*LocalCache)
+
+// WithCompression configures a LocalCache to store new objects
+// zstd-compressed. Objects are always addressed by the checksum of their
+// uncompressed contents, and existing uncompressed objects remain readable,
+// so compression can be enabled for an existing cache at any time. Objects
+// are decompressed when checked out, copied, or pushed to a Remote.
+//
+// Compressed objects can't be linked into the workspace, so link checkouts
+// of compressed objects fall back to copying.
+func WithCompression() LocalCacheOption {
+	return func(ch *LocalCache) {
+		ch.compress = true
+	}
+}
+
+// WithChunking configures a LocalCache to store files of at least threshold
+// bytes as a series of content-defined chunks (see the chunker package). When
+// a large file changes slightly, only the chunks that changed are stored, and
+// only those chunks need to be pushed or fetched. Chunked files are
+// reassembled when checked out. Link checkouts link to a copy of the file
+// reassembled in the cache, which is created at most once per version of the
+// file.
+func WithChunking(threshold int64) LocalCacheOption {
+	return func(ch *LocalCache) {
+		ch.chunkThreshold = threshold
+	}
+}
+
+// WithSharedGroup configures a LocalCache to be shared by the members of the
+// group with the given ID, e.g. by several projects on a shared filesystem.
+// New directories in the cache are owned by the group and are group-writable
+// and setgid, so that everything created in them belongs to the group as well.
+// Objects are always read-only, regardless of this option.
+//
+// Access to objects is coordinated between the processes sharing the cache
+// (see lockObject).
+func WithSharedGroup(gid int) LocalCacheOption {
+	return func(ch *LocalCache) {
+		ch.shared = true
+		ch.group = gid
+	}
+}
+
+// WithHash configures a LocalCache to checksum new objects with the given
+// hash algorithm, instead of checksum.DefaultAlgorithm. Objects of different
+// algorithms can be mixed freely in a cache, so the algorithm can be changed
+// at any time. Files committed before the change keep their old checksums
+// until they are modified.
+func WithHash(algo *checksum.Algorithm) LocalCacheOption {
+	return func(ch *LocalCache) {
+		ch.hash = algo
+	}
+}
+
+// WithStatCache configures a LocalCache to look up the checksums of
+// workspace files in stats, and to record them there. Status and Commit only
+// read files whose metadata changed since they were last checksummed. Files
+// that are linked to the cache are quick to check regardless, and chunked
+// files are always read.
+func WithStatCache(stats *StatCache) LocalCacheOption {
+	return func(ch *LocalCache) {
+		ch.stats = stats
+	}
+}
+
+// WithSparse configures a LocalCache for a sparse checkout, in which only the
+// files matching the given patterns are present in the workspace. Patterns
+// are matched against paths relative to the workspace, and any file in a
+// matching directory matches (see the glob package). Fetch, Push, and
+// Checkout skip everything else, including the children of directory
+// Artifacts. Status reports the excluded files missing from the workspace as
+// sparse, and Commit keeps them in directory manifests as they were.
+func WithSparse(patterns []glob.Pattern) LocalCacheOption {
+	return func(ch *LocalCache) {
+		ch.sparse = patterns
+	}
 }
 
 // NewLocalCache initializes a LocalCache with a valid cache directory.
-func NewLocalCache(dir string) (ch LocalCache, err error) {
+func NewLocalCache(dir string, opts ...LocalCacheOption) (ch LocalCache, err error) {
 	if dir == "" {
 		return ch, errors.New("cache directory path must be set")
 	}
+	for _, opt := range opts {
+		opt(&ch)
+	}
+	ch.sharedWorkers = make(chan struct{}, maxSharedWorkers)
+	if ch.hash == nil {
+		// The default algorithm is always registered.
+		ch.hash, _ = checksum.Lookup(checksum.DefaultAlgorithm)
+	}
 	ch.dir, err = filepath.Abs(dir)
 	return
 }
@@ -84,10 +213,145 @@ func NewLocalCache(dir string) (ch LocalCache, err error) {
 // given checksum in the cache. If the checksum has an invalid (e.g. empty)
 // checksum value, this function returns an error.
 func (ch LocalCache) PathForChecksum(checksum string) (string, error) {
-	if len(checksum) < 3 {
-		return "", InvalidChecksumError{checksum: checksum}
+	return pathForChecksum(checksum)
+}
+
+// pathForChecksum defines the layout of objects in both the local cache and
+// any Remote that stores objects in a directory hierarchy. Objects are
+// sharded by the first two characters of their digests. Objects with checksums
+// computed by algorithms other than checksum.DefaultAlgorithm are stored in
+// a directory named after the algorithm (e.g. "sha256/ab/cdef...").
+func pathForChecksum(cksum string) (string, error) {
+	algo, digest, err := checksum.Parse(cksum)
+	if err != nil || len(digest) < 3 {
+		return "", InvalidChecksumError{checksum: cksum}
+	}
+	if algo.IsDefault() {
+		return filepath.Join(digest[:2], digest[2:]), nil
+	}
+	return filepath.Join(algo.Name(), digest[:2], digest[2:]), nil
+}
+
+// checksumFromPath is the inverse of pathForChecksum for slash-separated
+// paths (e.g. object keys on a Remote). The second return value is false if
+// the path doesn't address an object.
+func checksumFromPath(path string) (string, bool) {
+	prefix := ""
+	if algoDir, rest, ok := strings.Cut(path, "/"); ok && isAlgorithmDir(algoDir) {
+		prefix = algoDir + ":"
+		path = rest
+	}
+	shard, rest, ok := strings.Cut(path, "/")
+	if !ok || !isShardDir(shard) || rest == "" {
+		return "", false
+	}
+	for _, char := range rest {
+		if !strings.ContainsRune("0123456789abcdef", char) {
+			return "", false
+		}
+	}
+	return prefix + shard + rest, true
+}
+
+// isAlgorithmDir returns true if name is the name of the directory holding
+// the objects of a non-default hash algorithm (see pathForChecksum).
+func isAlgorithmDir(name string) bool {
+	algo, err := checksum.Lookup(name)
+	return err == nil && !algo.IsDefault()
+}
+
+// checksum computes the checksum of the bytes from reader with the cache's
+// hash algorithm.
+func (ch LocalCache) checksum(reader io.Reader) (string, error) {
+	return ch.hash.Checksum(reader)
+}
+
+// walkObjects calls walkFn for every object in the cache. See walkObjectDir.
+func (ch LocalCache) walkObjects(
+	walkFn func(checksum, cachePath string, info fs.FileInfo) error,
+) error {
+	return walkObjectDir(ch.dir, walkFn)
+}
+
+// walkObjectDir calls walkFn for every object in dir. Objects are found in the
+// two-character "shard" directories created by pathForChecksum, including
+// those in hash algorithm directories; all other files and directories (e.g.
+// temporary files) are ignored. cachePath is relative to dir, as returned by
+// pathForChecksum, plus compressedSuffix for compressed objects.
+func walkObjectDir(
+	dir string,
+	walkFn func(checksum, cachePath string, info fs.FileInfo) error,
+) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !isAlgorithmDir(entry.Name()) {
+			continue
+		}
+		algoDir := entry.Name()
+		err := walkShards(
+			filepath.Join(dir, algoDir),
+			func(cksum, cachePath string, info fs.FileInfo) error {
+				return walkFn(algoDir+":"+cksum, filepath.Join(algoDir, cachePath), info)
+			},
+		)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return walkShards(dir, walkFn)
+}
+
+// walkShards calls walkFn for every object in the shard directories in dir.
+// See walkObjectDir.
+func walkShards(
+	dir string,
+	walkFn func(checksum, cachePath string, info fs.FileInfo) error,
+) error {
+	shards, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() || !isShardDir(shard.Name()) {
+			continue
+		}
+		objects, err := os.ReadDir(filepath.Join(dir, shard.Name()))
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			if !object.Type().IsRegular() {
+				continue
+			}
+			info, err := object.Info()
+			if err != nil {
+				return err
+			}
+			if err := walkFn(
+				shard.Name()+strings.TrimSuffix(object.Name(), compressedSuffix),
+				filepath.Join(shard.Name(), object.Name()),
+				info,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isShardDir(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	for _, c := range name {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
 	}
-	return filepath.Join(checksum[:2], checksum[2:]), nil
+	return true
 }
 
 type directoryManifest struct {
@@ -95,9 +359,11 @@ type directoryManifest struct {
 	Contents map[string]*artifact.Artifact `json:"contents,"`
 }
 
+// readDirManifest reads the directory manifest object at path, which may be
+// compressed.
 func readDirManifest(path string) (man directoryManifest, err error) {
-	var f *os.File
-	f, err = os.Open(path)
+	var f io.ReadCloser
+	f, err = openObject(path)
 	if err != nil {
 		return
 	}
@@ -31,6 +31,30 @@ func TestPathForChecksum(t *testing.T) {
 		}
 	})
 
+	t.Run("other hash algorithms", func(t *testing.T) {
+		ch, err := NewLocalCache("/foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checksum := "sha256:123456789"
+		cachePath, err := ch.PathForChecksum(checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := filepath.Join("sha256", "12", "3456789")
+		if cachePath != want {
+			t.Fatalf("cache.PathForChecksum(%#v) = %#v, want %#v", checksum, cachePath, want)
+		}
+
+		for _, checksum := range []string{"blake3:123456789", "md5:123456789", "sha256:12"} {
+			if _, err := ch.PathForChecksum(checksum); err == nil {
+				t.Fatalf("expected error for cache.PathForChecksum(%#v)", checksum)
+			}
+		}
+	})
+
 	t.Run("reject empty paths", func(t *testing.T) {
 		_, err := NewLocalCache("")
 		if err == nil {
@@ -17,14 +17,28 @@ import (
 )
 
 // Checkout finds the artifact in the cache and adds a copy of/link to said
-// artifact in the working directory.
+// artifact in the working directory. In a sparse checkout, files excluded
+// from the checkout are skipped (see WithSparse).
 func (cache LocalCache) Checkout(
 	workspaceDir string,
 	art artifact.Artifact,
 	strat strategy.CheckoutStrategy,
 	progress *pb.ProgressBar,
 ) (err error) {
-	if art.SkipCache {
+	if art.IsGlob() {
+		baseArt, pattern, err := globBase(art)
+		if err != nil {
+			return errors.Wrapf(err, "checkout %s", art.Path)
+		}
+		cache.include = pattern
+		return cache.Checkout(workspaceDir, baseArt, strat, progress)
+	}
+	cache.sparseRoot = workspaceDir
+	cache.artifactDir = filepath.Join(workspaceDir, art.Path)
+	if err := cache.loadIgnore(art); err != nil {
+		return errors.Wrapf(err, "checkout %s", art.Path)
+	}
+	if art.SkipCache || cache.isExcluded(art.Path, art.IsDir) {
 		return
 	}
 	if progress == nil {
@@ -33,24 +47,27 @@ func (cache LocalCache) Checkout(
 	progress.Start()
 	defer progress.Finish()
 	if art.IsDir {
-		activeSharedWorkers := make(chan struct{}, maxSharedWorkers)
 		err = checkoutDir(
 			context.Background(),
 			cache,
 			workspaceDir,
 			art,
 			strat,
-			activeSharedWorkers,
+			cache.sharedWorkers,
 			progress,
 		)
 	} else {
 		// Setting the total here avoids locking the progress bar in the hot path
 		// (checkoutFile, which is called from checkoutDir).
-		if strat == strategy.LinkStrategy {
+		if strat.IsLink() {
 			progress.SetTotal(1)
 		}
 		err = checkoutFile(cache, workspaceDir, art, strat, progress)
 	}
+	if err == nil {
+		// See Status.
+		_ = cache.recordAccess(art)
+	}
 	return errors.Wrapf(err, "checkout %s", art.Path)
 }
 
@@ -75,58 +92,92 @@ func checkoutFile(
 		return err
 	}
 	cachePath = filepath.Join(ch.dir, cachePath)
-	switch strat {
-	case strategy.CopyStrategy:
-		srcInfo, err := os.Lstat(cachePath)
-		if err != nil {
+
+	// Link strategies increment the count of files linked. We avoid adjusting
+	// the bar's total here to reduce the overhead in the hot path. For files
+	// that are part of a directory, checkoutDir() sets the total to account
+	// for this file. If this is a standalone file, cache.Checkout sets the
+	// total.
+	if strat.IsLink() && progress != nil {
+		defer progress.Increment()
+	}
+
+	// ContentsMatch is set true in quickStatus only when the workspace file
+	// is a symlink or hard link to the correct file in the cache. If it's
+	// already the kind of link we want, there's nothing to do. Otherwise, we
+	// can safely remove the link to allow the checkout to proceed. In any
+	// other case, it's best to let the checkout fail below to make the user
+	// fix the issue.
+	if status.ContentsMatch {
+		isSymlink := status.WorkspaceFileStatus == fsutil.StatusLink
+		if (strat == strategy.LinkStrategy && isSymlink) ||
+			(strat == strategy.HardlinkStrategy && !isSymlink) {
+			return nil
+		}
+		if err := os.Remove(workPath); err != nil {
 			return err
 		}
-		progress.AddTotal(srcInfo.Size())
-
-		srcFile, err := os.Open(cachePath)
-		if err != nil {
+	}
+	// The workspace file is a link to the correct object, but the object's
+	// mode doesn't match the Artifact. It's replaced by a copy below.
+	if status.ModeChanged {
+		if err := os.Remove(workPath); err != nil {
 			return err
 		}
-		defer srcFile.Close()
+	}
 
-		// ContentsMatch is set true in quickStatus only when the workspace
-		// file is a link to the correct file in the cache. In this case, we
-		// can safely remove the link to allow the copy checkout to proceed.
-		// Otherwise, it's best to let os.OpenFile fail below to make the user
-		// fix the issue.
-		if status.ContentsMatch {
-			if err := os.Remove(workPath); err != nil {
-				return err
-			}
+	perms := workspaceFilePerms(art)
+	if art.IsChunked {
+		// Chunked files are reassembled, either directly into the workspace
+		// or into the cache's reconstruction area to be linked.
+		if !strat.IsLink() {
+			return ch.copyChunkedFile(cachePath, workPath, perms, progress)
+		}
+		if cachePath, err = ch.reconstruct(art.Checksum, cachePath); err != nil {
+			return err
 		}
+	} else if isCompressedObject(cachePath) {
+		// Compressed objects can only be copied. Link strategies report
+		// progress in files, not bytes, so hide the bytes copied.
+		if strat.IsLink() || progress == nil {
+			progress = newHiddenProgress()
+		}
+		return copyFile(cachePath, workPath, art.Checksum, perms, progress)
+	}
 
-		dstFile, err := os.OpenFile(workPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	// Links share the mode of their object, and objects are shared by every
+	// file with the same contents, so they're never made executable. Files
+	// whose mode differs from their object's are copied instead, reporting
+	// progress in files as before.
+	if strat.IsLink() && trackExecutable {
+		info, err := os.Stat(cachePath)
 		if err != nil {
 			return err
 		}
-		defer dstFile.Close()
+		if isExecutable(info) != art.Executable {
+			strat = strategy.ReflinkStrategy
+			progress = newHiddenProgress()
+		}
+	}
 
-		// Might as well checksum the file while we copy to check data integrity.
-		srcReader := io.TeeReader(progress.NewProxyReader(srcFile), dstFile)
-		checksum, err := checksum.Checksum(srcReader)
+	switch strat {
+	case strategy.CopyStrategy:
+		return copyFile(cachePath, workPath, art.Checksum, perms, progress)
+	case strategy.ReflinkStrategy:
+		srcInfo, err := os.Stat(cachePath)
 		if err != nil {
 			return err
 		}
-		if checksum != art.Checksum {
-			return fmt.Errorf("found checksum %#v, expected %#v", checksum, art.Checksum)
+		if err := cloneFile(cachePath, workPath); err == nil {
+			progress.AddTotal(srcInfo.Size())
+			progress.Add64(srcInfo.Size())
+			return os.Chmod(workPath, perms)
 		}
+		// The filesystem can't clone files, so fall back to copying.
+		return copyFile(cachePath, workPath, art.Checksum, perms, progress)
+	case strategy.HardlinkStrategy:
+		return os.Link(cachePath, workPath)
 	case strategy.LinkStrategy:
-		// Increment the count of files linked. We avoid adjusting the bar's
-		// total here to reduce the overhead in the hot path. For files that are
-		// part of a directory, checkoutDir() sets the total to account for
-		// this file. If this is a standalone file, cache.Checkout sets the
-		// total.
-		if progress != nil {
-			defer progress.Increment()
-		}
-		if status.ContentsMatch {
-			return nil
-		}
 		// Make the symlink target relative to the parent directory of the
 		// workspace file. For cache locations defined relative to the project
 		// root (including the default location), this allows the project root
@@ -143,9 +194,54 @@ func checkoutFile(
 		if err != nil {
 			return err
 		}
-		if err := os.Symlink(linkPath, workPath); err != nil {
+		return os.Symlink(linkPath, workPath)
+	}
+	return fmt.Errorf("unknown checkout strategy: %s", strat)
+}
+
+// copyFile copies the object at cachePath to workPath, which must not exist,
+// and verifies the copy has the expected checksum. The copy is created with
+// the given permissions (before the umask). Compressed objects are
+// decompressed, but progress is reported in terms of the bytes read from the
+// cache.
+func copyFile(
+	cachePath, workPath, expectedChecksum string,
+	perms os.FileMode,
+	progress *pb.ProgressBar,
+) error {
+	srcFile, err := os.Open(cachePath)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+	progress.AddTotal(srcInfo.Size())
+	var srcReader io.Reader = progress.NewProxyReader(srcFile)
+	if isCompressedObject(cachePath) {
+		decompressor, err := newDecompressor(srcReader)
+		if err != nil {
 			return err
 		}
+		defer decompressor.Close()
+		srcReader = decompressor
+	}
+
+	dstFile, err := os.OpenFile(workPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perms)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	// Might as well checksum the file while we copy to check data integrity.
+	ok, actual, err := checksum.Verify(io.TeeReader(srcReader, dstFile), expectedChecksum)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("found checksum %#v, expected %#v", actual, expectedChecksum)
 	}
 	return nil
 }
@@ -186,13 +282,22 @@ func checkoutDir(
 		return err
 	}
 
+	children := make([]*artifact.Artifact, 0, len(man.Contents))
+	for _, childArt := range man.Contents {
+		childPath := filepath.Join(workPath, childArt.Path)
+		if !ch.isExcludedWorkPath(childPath, childArt.IsDir) &&
+			!ch.isIgnored(childPath, childArt.IsDir) {
+			children = append(children, childArt)
+		}
+	}
+
 	// When linking, the progress report counts files linked. Add all of the
 	// files we know about here to the total, and let checkoutFile handle
 	// updating the report. (When copying, checkoutFile handles updating the
 	// bytes transferred completely.)
-	if strat == strategy.LinkStrategy {
+	if strat.IsLink() {
 		var fileCount int64 = 0
-		for _, art := range man.Contents {
+		for _, art := range children {
 			if !art.IsDir {
 				fileCount++
 			}
@@ -204,7 +309,7 @@ func checkoutDir(
 	errGroup, groupCtx := errgroup.WithContext(ctx)
 	childArtifacts := make(chan *artifact.Artifact)
 	errGroup.Go(func() error {
-		for _, childArt := range man.Contents {
+		for _, childArt := range children {
 			select {
 			case childArtifacts <- childArt:
 			case <-groupCtx.Done():
@@ -220,7 +325,7 @@ func checkoutDir(
 		errGroup,
 		ch,
 		workPath,
-		len(man.Contents),
+		len(children),
 		childArtifacts,
 		strat,
 		activeSharedWorkers,
@@ -303,6 +408,8 @@ func checkoutWorker(
 					activeSharedWorkers,
 					progress,
 				)
+			} else if childArt.IsLink {
+				err = checkoutSymlink(ch, workPath, *childArt, strat, progress)
 			} else {
 				err = checkoutFile(ch, workPath, *childArt, strat, progress)
 			}
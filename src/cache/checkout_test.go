@@ -3,6 +3,7 @@ package cache
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/kevin-hanselman/dud/src/artifact"
@@ -30,7 +31,12 @@ func TestFileCheckoutIntegration(t *testing.T) {
 		t.Skip()
 	}
 
-	allStrategies := []strategy.CheckoutStrategy{strategy.LinkStrategy, strategy.CopyStrategy}
+	allStrategies := []strategy.CheckoutStrategy{
+		strategy.LinkStrategy,
+		strategy.CopyStrategy,
+		strategy.HardlinkStrategy,
+		strategy.ReflinkStrategy,
+	}
 
 	allFileStatuses := []fsutil.FileStatus{
 		fsutil.StatusAbsent,
@@ -71,13 +77,7 @@ func TestFileCheckoutIntegration(t *testing.T) {
 				},
 				Error: nil,
 			}
-			if strat == strategy.CopyStrategy {
-				out.Status.WorkspaceFileStatus = fsutil.StatusRegularFile
-			} else if strat == strategy.LinkStrategy {
-				out.Status.WorkspaceFileStatus = fsutil.StatusLink
-			} else {
-				panic("unknown strategy")
-			}
+			out.Status.WorkspaceFileStatus = expectedWorkspaceFileStatus(strat)
 
 			t.Run(strat.String(), func(t *testing.T) {
 				testFileCheckoutIntegration(in, out, t)
@@ -182,13 +182,7 @@ func TestFileCheckoutIntegration(t *testing.T) {
 				Status: status,
 				Error:  nil,
 			}
-			if strat == strategy.CopyStrategy {
-				out.Status.WorkspaceFileStatus = fsutil.StatusRegularFile
-			} else if strat == strategy.LinkStrategy {
-				out.Status.WorkspaceFileStatus = fsutil.StatusLink
-			} else {
-				panic("unknown strategy")
-			}
+			out.Status.WorkspaceFileStatus = expectedWorkspaceFileStatus(strat)
 
 			testName := fmt.Sprintf("%s %s", status, strat)
 			t.Run(testName, func(t *testing.T) {
@@ -196,6 +190,59 @@ func TestFileCheckoutIntegration(t *testing.T) {
 			})
 		}
 	})
+
+	t.Run("hardlink shares the cache inode", func(t *testing.T) {
+		dirs, art, err := testutil.CreateArtifactTestCase(artifact.Status{
+			WorkspaceFileStatus: fsutil.StatusAbsent,
+			HasChecksum:         true,
+			ChecksumInCache:     true,
+		})
+		defer os.RemoveAll(dirs.CacheDir)
+		defer os.RemoveAll(dirs.WorkDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ch, err := NewLocalCache(dirs.CacheDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Checkout(dirs.WorkDir, art, strategy.HardlinkStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		cacheInfo, err := os.Stat(objectPath(t, ch, art.Checksum))
+		if err != nil {
+			t.Fatal(err)
+		}
+		workPath := filepath.Join(dirs.WorkDir, art.Path)
+		workInfo, err := os.Lstat(workPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !os.SameFile(cacheInfo, workInfo) {
+			t.Fatal("expected workspace file to be a hard link to the cache")
+		}
+
+		// Checking out a symlink over the hard link replaces it.
+		if err := ch.Checkout(dirs.WorkDir, art, strategy.LinkStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		status, err := fsutil.FileStatusFromPath(workPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != fsutil.StatusLink {
+			t.Fatalf("expected workspace file to be a link, got %s", status)
+		}
+	})
+}
+
+// expectedWorkspaceFileStatus returns the status of a workspace file after it
+// is checked out with the given strategy.
+func expectedWorkspaceFileStatus(strat strategy.CheckoutStrategy) fsutil.FileStatus {
+	if strat == strategy.LinkStrategy {
+		return fsutil.StatusLink
+	}
+	return fsutil.StatusRegularFile
 }
 
 func testFileCheckoutIntegration(in testInput, expectedOut testExpectedOutput, t *testing.T) {
@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/kevin-hanselman/dud/src/checksum"
+	"github.com/kevin-hanselman/dud/src/chunker"
+)
+
+// reconstructionDir is the directory, relative to the cache root, holding
+// reassembled copies of chunked files for link checkouts. Files in this
+// directory are laid out like objects, but are addressed by the checksum of
+// their chunk manifest.
+const reconstructionDir = "reconstructed"
+
+// A chunkManifest lists the chunks of a chunked file Artifact, in order.
+type chunkManifest struct {
+	// Size is the size of the reassembled file.
+	Size   int64       `json:"size"`
+	Chunks []fileChunk `json:"chunks"`
+}
+
+type fileChunk struct {
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+}
+
+// readChunkManifest reads the chunk manifest object at path, which may be
+// compressed.
+func readChunkManifest(path string) (man chunkManifest, err error) {
+	var f io.ReadCloser
+	f, err = openObject(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&man)
+	return
+}
+
+// commitChunks splits the bytes from reader into content-defined chunks,
+// stores any chunks not already in the cache, and commits a chunk manifest
+// listing them. It returns the checksum of the chunk manifest.
+func (ch LocalCache) commitChunks(reader io.Reader) (string, error) {
+	var man chunkManifest
+	chunks := chunker.New(reader)
+	for {
+		data, err := chunks.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		cksum, err := ch.checksum(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		// Only store new chunks. This is the point of chunking.
+		_, _, err = ch.findObject(cksum)
+		if os.IsNotExist(err) {
+			err = ch.storeObject(cksum, bytes.NewReader(data))
+		}
+		if err != nil {
+			return "", err
+		}
+		man.Chunks = append(man.Chunks, fileChunk{Checksum: cksum, Size: int64(len(data))})
+		man.Size += int64(len(data))
+	}
+	buf := new(bytes.Buffer)
+	if err := json.NewEncoder(buf).Encode(man); err != nil {
+		return "", err
+	}
+	return ch.commitBytes(buf, "")
+}
+
+// chunkReader reassembles a chunked file from the chunks in the cache. Each
+// chunk is verified against its checksum as it's read.
+type chunkReader struct {
+	ch      LocalCache
+	chunks  []fileChunk
+	current *bytes.Reader
+}
+
+func (ch LocalCache) newChunkReader(man chunkManifest) *chunkReader {
+	return &chunkReader{ch: ch, chunks: man.Chunks, current: bytes.NewReader(nil)}
+}
+
+func (reader *chunkReader) Read(p []byte) (int, error) {
+	for reader.current.Len() == 0 {
+		if len(reader.chunks) == 0 {
+			return 0, io.EOF
+		}
+		if err := reader.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	return reader.current.Read(p)
+}
+
+func (reader *chunkReader) nextChunk() error {
+	chunk := reader.chunks[0]
+	reader.chunks = reader.chunks[1:]
+	cachePath, _, err := reader.ch.findObject(chunk.Checksum)
+	if os.IsNotExist(err) {
+		return MissingFromCacheError{chunk.Checksum}
+	}
+	if err != nil {
+		return err
+	}
+	object, err := openObject(filepath.Join(reader.ch.dir, cachePath))
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	// Chunks are small enough to hold in memory.
+	data := make([]byte, chunk.Size)
+	if _, err := io.ReadFull(object, data); err != nil {
+		return err
+	}
+	ok, cksum, err := checksum.Verify(bytes.NewReader(data), chunk.Checksum)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("chunk %s: found checksum %#v", chunk.Checksum, cksum)
+	}
+	reader.current.Reset(data)
+	return nil
+}
+
+// reconstructedPath returns the location of the reassembled file for the
+// chunk manifest with the given checksum.
+func (ch LocalCache) reconstructedPath(checksum string) (string, error) {
+	cachePath, err := pathForChecksum(checksum)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(ch.dir, reconstructionDir, cachePath), nil
+}
+
+// reconstruct reassembles the chunked file described by the chunk manifest
+// at cachePath into the reconstruction directory, unless it's already there,
+// and returns the path to the reassembled file.
+func (ch LocalCache) reconstruct(checksum, cachePath string) (string, error) {
+	path, err := ch.reconstructedPath(checksum)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	man, err := readChunkManifest(cachePath)
+	if err != nil {
+		return "", err
+	}
+	tempFile, err := ch.createStagingFile()
+	if err != nil {
+		return "", err
+	}
+	// If anything goes wrong, don't leave the temporary file behind. If the
+	// file was successfully moved, this fails harmlessly.
+	defer os.Remove(tempFile.Name())
+	_, err = io.Copy(tempFile, ch.newChunkReader(man))
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return path, ch.placeObject(checksum, tempFile.Name(), path)
+}
+
+// copyChunkedFile reassembles the chunked file described by the chunk
+// manifest at cachePath into workPath, which must not exist, with the given
+// permissions.
+func (ch LocalCache) copyChunkedFile(
+	cachePath, workPath string,
+	perms os.FileMode,
+	progress *pb.ProgressBar,
+) error {
+	man, err := readChunkManifest(cachePath)
+	if err != nil {
+		return err
+	}
+	progress.AddTotal(man.Size)
+	dstFile, err := os.OpenFile(workPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perms)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+	_, err = io.Copy(dstFile, progress.NewProxyReader(ch.newChunkReader(man)))
+	return err
+}
+
+// sameContentsAsChunkedFile returns true if the file at workPath has the same
+// contents as the chunked file described by the chunk manifest at cachePath.
+func (ch LocalCache) sameContentsAsChunkedFile(workPath, cachePath string) (bool, error) {
+	man, err := readChunkManifest(cachePath)
+	if err != nil {
+		return false, err
+	}
+	file, err := os.Open(workPath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != man.Size {
+		return false, nil
+	}
+	return sameContents(file, ch.newChunkReader(man))
+}
@@ -0,0 +1,340 @@
+package cache
+
+import (
+	"bytes"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/chunker"
+	"github.com/kevin-hanselman/dud/src/fsutil"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestChunkingIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+	threshold := int64(chunker.MaxSize)
+	contents := make([]byte, 4*threshold)
+	rand.New(rand.NewSource(1)).Read(contents)
+
+	// setupChunkTest writes contents to a file in a new workspace and returns
+	// the workspace directory, the file's Artifact, and a cache that chunks
+	// large files.
+	setupChunkTest := func(t *testing.T, opts ...LocalCacheOption) (string, artifact.Artifact, LocalCache) {
+		workDir := t.TempDir()
+		opts = append(opts, WithChunking(threshold))
+		ch, err := NewLocalCache(t.TempDir(), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		art := artifact.Artifact{Path: "model.bin"}
+		if err := os.WriteFile(filepath.Join(workDir, art.Path), contents, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return workDir, art, ch
+	}
+
+	countObjects := func(t *testing.T, ch LocalCache) (count int) {
+		if err := ch.walkObjects(func(string, string, fs.FileInfo) error {
+			count++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	assertStatus := func(t *testing.T, ch LocalCache, workDir string, art artifact.Artifact, want string) {
+		status, err := ch.Status(workDir, art, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := status.String(); got != want {
+			t.Fatalf("status = %#v, want %#v", got, want)
+		}
+	}
+
+	assertContents := func(t *testing.T, path string, want []byte) {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s has the wrong contents", path)
+		}
+	}
+
+	t.Run("small files are not chunked", func(t *testing.T) {
+		workDir, art, ch := setupChunkTest(t)
+		workPath := filepath.Join(workDir, art.Path)
+		if err := os.WriteFile(workPath, []byte("small"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// A file that shrinks below the threshold is no longer chunked.
+		art.IsChunked = true
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		if art.IsChunked {
+			t.Fatal("expected artifact not to be chunked")
+		}
+		if countObjects(t, ch) != 1 {
+			t.Fatalf("found %d objects, want 1", countObjects(t, ch))
+		}
+	})
+
+	t.Run("copy strategy", func(t *testing.T) {
+		workDir, art, ch := setupChunkTest(t)
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		if !art.IsChunked {
+			t.Fatal("expected artifact to be chunked")
+		}
+		// One manifest and at least one chunk per MaxSize bytes.
+		if count := countObjects(t, ch); count < 5 {
+			t.Fatalf("found %d objects, want at least 5", count)
+		}
+		assertStatus(t, ch, workDir, art, "up-to-date")
+
+		workPath := filepath.Join(workDir, art.Path)
+		if err := os.Remove(workPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Checkout(workDir, art, strategy.CopyStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		assertContents(t, workPath, contents)
+		assertStatus(t, ch, workDir, art, "up-to-date")
+
+		// Modify a byte in the middle of the file, but keep its size.
+		modified := append([]byte(nil), contents...)
+		modified[len(modified)/2]++
+		if err := os.WriteFile(workPath, modified, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		assertStatus(t, ch, workDir, art, "modified")
+
+		// Only the chunk containing the edit (and the manifest) are new.
+		oldCount := countObjects(t, ch)
+		oldChecksum := art.Checksum
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		if art.Checksum == oldChecksum {
+			t.Fatal("checksum didn't change")
+		}
+		if newCount := countObjects(t, ch) - oldCount; newCount != 2 {
+			t.Fatalf("found %d new objects, want 2", newCount)
+		}
+		assertStatus(t, ch, workDir, art, "up-to-date")
+	})
+
+	for _, strat := range []strategy.CheckoutStrategy{
+		strategy.LinkStrategy,
+		strategy.HardlinkStrategy,
+	} {
+		t.Run(strat.String(), func(t *testing.T) {
+			workDir, art, ch := setupChunkTest(t)
+			if err := ch.Commit(workDir, &art, strat, logger); err != nil {
+				t.Fatal(err)
+			}
+			wantStatus := "up-to-date"
+			if strat == strategy.LinkStrategy {
+				wantStatus = "up-to-date (link)"
+			}
+			assertStatus(t, ch, workDir, art, wantStatus)
+
+			workPath := filepath.Join(workDir, art.Path)
+			reconstructedPath, err := ch.reconstructedPath(art.Checksum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSameFile := func(t *testing.T) {
+				workInfo, err := os.Stat(workPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				reconstructedInfo, err := os.Stat(reconstructedPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !os.SameFile(workInfo, reconstructedInfo) {
+					t.Fatal("workspace file is not linked to the reassembled file")
+				}
+			}
+			assertSameFile(t)
+			assertContents(t, workPath, contents)
+
+			// Without the reassembled file, checkout reassembles it again.
+			if err := os.Remove(workPath); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Remove(reconstructedPath); err != nil {
+				t.Fatal(err)
+			}
+			if err := ch.Checkout(workDir, art, strat, nil); err != nil {
+				t.Fatal(err)
+			}
+			assertSameFile(t)
+			assertContents(t, workPath, contents)
+			assertStatus(t, ch, workDir, art, wantStatus)
+		})
+	}
+
+	t.Run("compressed chunks", func(t *testing.T) {
+		workDir, art, ch := setupChunkTest(t, WithCompression())
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		err := ch.walkObjects(func(checksum, cachePath string, _ fs.FileInfo) error {
+			if !isCompressedObject(cachePath) {
+				t.Fatalf("object %s is not compressed", checksum)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		workPath := filepath.Join(workDir, art.Path)
+		if err := os.Remove(workPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Checkout(workDir, art, strategy.CopyStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		assertContents(t, workPath, contents)
+	})
+
+	t.Run("push, fetch, verify, and gc", func(t *testing.T) {
+		workDir, art, ch := setupChunkTest(t)
+		if err := ch.Commit(workDir, &art, strategy.LinkStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		remoteDir := t.TempDir()
+		remote, err := NewRemote("file://" + remoteDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		arts := map[string]*artifact.Artifact{"art": &art}
+		if err := ch.Push(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+		remoteCache, err := NewLocalCache(remoteDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Reassembled files are local to a cache; only objects are pushed.
+		if diff := cmp.Diff(objectChecksums(t, ch), objectChecksums(t, remoteCache)); diff != "" {
+			t.Fatalf("pushed objects -want +got:\n%s", diff)
+		}
+
+		freshWorkDir := t.TempDir()
+		freshCache, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := freshCache.Fetch(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(objectChecksums(t, ch), objectChecksums(t, freshCache)); diff != "" {
+			t.Fatalf("fetched objects -want +got:\n%s", diff)
+		}
+		if err := freshCache.Checkout(freshWorkDir, art, strategy.CopyStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		assertContents(t, filepath.Join(freshWorkDir, art.Path), contents)
+
+		results, err := freshCache.Verify([]*artifact.Artifact{&art}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 0 {
+			t.Fatalf("fetched objects failed verification: %+v", results)
+		}
+		man, err := readChunkManifest(objectPath(t, freshCache, art.Checksum))
+		if err != nil {
+			t.Fatal(err)
+		}
+		missingChunk := man.Chunks[1].Checksum
+		if err := os.Remove(objectPath(t, freshCache, missingChunk)); err != nil {
+			t.Fatal(err)
+		}
+		results, err = freshCache.Verify([]*artifact.Artifact{&art}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []VerifyResult{{
+			Checksum: missingChunk,
+			Problem:  ObjectMissing,
+			Detail:   "chunk of " + art.Path,
+		}}
+		if diff := cmp.Diff(want, results); diff != "" {
+			t.Fatalf("Verify() -want +got:\n%s", diff)
+		}
+
+		// All chunks are reachable, as is the reassembled file.
+		result, err := ch.GarbageCollect([]*artifact.Artifact{&art}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Checksums) != 0 || result.Bytes != 0 {
+			t.Fatalf("expected nothing to collect, got %+v", result)
+		}
+		reconstructedPath, err := ch.reconstructedPath(art.Checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err = ch.GarbageCollect(nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Checksums) != len(man.Chunks)+1 {
+			t.Fatalf("collected %d objects, want %d", len(result.Checksums), len(man.Chunks)+1)
+		}
+		if exists, err := fsutil.Exists(reconstructedPath, false); err != nil || exists {
+			t.Fatalf("expected reassembled file to be collected, got %v, %v", exists, err)
+		}
+	})
+
+	t.Run("chunking is deterministic across caches", func(t *testing.T) {
+		workDir, art, ch := setupChunkTest(t)
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		otherCache, err := NewLocalCache(t.TempDir(), WithChunking(int64(datasize.MB)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherArt := artifact.Artifact{Path: art.Path}
+		if err := otherCache.Commit(workDir, &otherArt, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(art, otherArt); diff != "" {
+			t.Fatalf("Artifact -want +got:\n%s", diff)
+		}
+	})
+}
+
+// objectChecksums returns the set of checksums of the objects in the cache.
+func objectChecksums(t *testing.T, ch LocalCache) map[string]bool {
+	checksums := make(map[string]bool)
+	err := ch.walkObjects(func(checksum, _ string, _ fs.FileInfo) error {
+		checksums[checksum] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return checksums
+}
@@ -5,13 +5,13 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 
 	"github.com/cheggaaa/pb/v3"
 	"github.com/kevin-hanselman/dud/src/agglog"
 	"github.com/kevin-hanselman/dud/src/artifact"
-	"github.com/kevin-hanselman/dud/src/checksum"
 	"github.com/kevin-hanselman/dud/src/fsutil"
 	"github.com/kevin-hanselman/dud/src/strategy"
 	"github.com/pkg/errors"
@@ -26,13 +26,39 @@ func (ch LocalCache) Commit(
 	strat strategy.CheckoutStrategy,
 	logger *agglog.AggLogger,
 ) (err error) {
-	if err := os.MkdirAll(ch.dir, 0o755); err != nil {
+	if art.IsGlob() {
+		baseArt, pattern, err := globBase(*art)
+		if err != nil {
+			return errors.Wrapf(err, "commit %s", art.Path)
+		}
+		ch.include = pattern
+		if err := ch.Commit(workspaceDir, &baseArt, strat, logger); err != nil {
+			return err
+		}
+		art.Checksum = baseArt.Checksum
+		return nil
+	}
+	ch.sparseRoot = workspaceDir
+	ch.artifactDir = filepath.Join(workspaceDir, art.Path)
+	if err := ch.loadIgnore(*art); err != nil {
+		return errors.Wrapf(err, "commit %s", art.Path)
+	}
+	// Committed Artifacts excluded from a sparse checkout are kept as they
+	// are, unless they're in the workspace anyway.
+	if art.Checksum != "" && ch.isExcluded(art.Path, art.IsDir) {
+		exists, err := fsutil.Exists(filepath.Join(workspaceDir, art.Path), false)
+		if err != nil || !exists {
+			return errors.Wrapf(err, "commit %s", art.Path)
+		}
+	}
+	stagingPath := filepath.Join(ch.dir, stagingDir)
+	if err := ch.mkdirAll(stagingPath); err != nil {
 		return errors.Wrapf(err, "commit %s", art.Path)
 	}
 	// Try to move a dummy file between the workspace and the cache. If we can
 	// move files (via rename syscall), we can avoid writing to disk
 	// for file commits, dramatically improving performance.
-	canRenameFile, err := canRenameFileBetweenDirs(workspaceDir, ch.dir)
+	canRenameFile, err := canRenameFileBetweenDirs(workspaceDir, stagingPath)
 	if err != nil {
 		return errors.Wrapf(err, "commit %s", art.Path)
 	}
@@ -40,14 +66,13 @@ func (ch LocalCache) Commit(
 	progress.Start()
 	defer progress.Finish()
 	if art.IsDir {
-		activeSharedWorkers := make(chan struct{}, maxSharedWorkers)
 		err = commitDirArtifact(
 			context.Background(),
 			ch,
 			workspaceDir,
 			art,
 			strat,
-			activeSharedWorkers,
+			ch.sharedWorkers,
 			progress,
 			canRenameFile,
 		)
@@ -116,6 +141,24 @@ func commitFileArtifact(
 	if err != nil {
 		return err
 	}
+	if trackExecutable {
+		art.Executable = isExecutable(fileInfo)
+	}
+	// Chunked files are stored under the checksums of their chunk manifests,
+	// and linked files are moved to the cache, so only files kept as they
+	// are in the workspace have their checksums recorded (see WithStatCache).
+	isChunked := ch.chunkThreshold > 0 && fileInfo.Size() >= ch.chunkThreshold
+	linkFile := strat.IsLink() && !ch.compress
+	useStats := art.SkipCache || !(isChunked || linkFile)
+	if useStats {
+		if cksum, ok := ch.statChecksum(workPath, fileInfo, art.SkipCache); ok {
+			art.Checksum = cksum
+			if !art.SkipCache {
+				art.IsChunked = false
+			}
+			return nil
+		}
+	}
 	progress.AddTotal(fileInfo.Size())
 	srcFile, err := os.Open(workPath)
 	if err != nil {
@@ -125,16 +168,25 @@ func commitFileArtifact(
 	srcReader := progress.NewProxyReader(srcFile)
 
 	if art.SkipCache {
-		cksum, err := checksum.Checksum(srcReader)
+		cksum, err := ch.checksum(srcReader)
 		if err != nil {
 			return err
 		}
 		art.Checksum = cksum
+		ch.stats.Record(workPath, fileInfo, cksum)
 		return nil
 	}
 
+	if isChunked {
+		return commitChunkedFile(ch, workspaceDir, workPath, art, strat, srcReader, canRenameFile)
+	}
+	art.IsChunked = false
+
+	// Compressed objects can't be linked, so when compressing, the workspace
+	// file is always copied and left in place.
+
 	moveFile := ""
-	if canRenameFile && strat == strategy.LinkStrategy {
+	if canRenameFile && linkFile {
 		moveFile = workPath
 	}
 
@@ -144,9 +196,12 @@ func commitFileArtifact(
 	}
 
 	art.Checksum = cksum
-	// There's no need to call Checkout if using CopyStrategy; the original
-	// file still exists.
-	if strat == strategy.LinkStrategy {
+	if useStats {
+		ch.stats.Record(workPath, fileInfo, cksum)
+	}
+	// There's no need to call Checkout if using CopyStrategy or
+	// ReflinkStrategy; the original file still exists.
+	if linkFile {
 		// If we can't rename the file then we copied it, and we need to remove
 		// it before linking.
 		if !canRenameFile {
@@ -161,25 +216,74 @@ func commitFileArtifact(
 	return nil
 }
 
+// commitChunkedFile commits the bytes from reader as a chunked file (see
+// commitChunks). For link strategies, the workspace file is moved into the
+// cache's reconstruction area (if possible) before being linked, which saves
+// reassembling the file.
+func commitChunkedFile(
+	ch LocalCache,
+	workspaceDir string,
+	workPath string,
+	art *artifact.Artifact,
+	strat strategy.CheckoutStrategy,
+	reader io.Reader,
+	canRenameFile bool,
+) error {
+	cksum, err := ch.commitChunks(reader)
+	if err != nil {
+		return err
+	}
+	art.Checksum = cksum
+	art.IsChunked = true
+	if !strat.IsLink() {
+		return nil
+	}
+	reconstructedPath, err := ch.reconstructedPath(cksum)
+	if err != nil {
+		return err
+	}
+	if canRenameFile {
+		err = ch.placeObject(cksum, workPath, reconstructedPath)
+	} else {
+		err = os.Remove(workPath)
+	}
+	if err != nil {
+		return err
+	}
+	return checkoutFile(ch, workspaceDir, *art, strat, nil)
+}
+
 // commitBytes checksums the bytes from reader and results in said bytes being
 // present in the cache. If moveFile is empty, commitBytes will copy from
 // reader to the cache while checksumming. If moveFile is not empty, the file
 // path it references is moved (i.e. renamed) to the cache after checksumming,
-// thus eliminating unnecessary file IO.
+// thus eliminating unnecessary file IO. moveFile must be empty if the cache
+// compresses objects.
 func (ch LocalCache) commitBytes(reader io.Reader, moveFile string) (string, error) {
 	// If there's no file we can move, we need to copy the bytes from reader to
 	// the cache.
+	var (
+		writer io.WriteCloser
+		suffix string
+	)
 	if moveFile == "" {
-		tempFile, err := os.CreateTemp(ch.dir, "")
+		tempFile, err := ch.createStagingFile()
 		if err != nil {
 			return "", err
 		}
 		defer tempFile.Close()
-		reader = io.TeeReader(reader, tempFile)
+		writer, suffix = ch.newObjectWriter(tempFile)
+		reader = io.TeeReader(reader, writer)
 		moveFile = tempFile.Name()
 	}
 
-	cksum, err := checksum.Checksum(reader)
+	cksum, err := ch.checksum(reader)
+	// Flush any compressed bytes before moving the file.
+	if writer != nil {
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+	}
 	if err != nil {
 		return "", err
 	}
@@ -188,24 +292,15 @@ func (ch LocalCache) commitBytes(reader io.Reader, moveFile string) (string, err
 		return "", err
 	}
 	cachePath = filepath.Join(ch.dir, cachePath)
-	dstDir := filepath.Dir(cachePath)
-	if err = os.MkdirAll(dstDir, 0o755); err != nil {
-		return "", err
-	}
-	// This rename may race others, but luckily we don't care who wins the
-	// race. Everyone in the race is trying to put the same exact file in the
-	// cache (because of content-addressed storage), so the outcome is the same
-	// no matter who wins the race. At the OS level rename is atomic, so
-	// there's no risk of corrupting the destination file with multiple
-	// concurrent syscalls. (This is at least true for UNIX, but that's all we
-	// support. See also: https://github.com/golang/go/issues/8914)
-	if err = os.Rename(moveFile, cachePath); err != nil {
-		return "", err
-	}
-	if err := os.Chmod(cachePath, cacheFilePerms); err != nil {
-		return "", err
+	// In a mixed cache, there's no need to store a compressed copy of an
+	// object that's already in the cache uncompressed.
+	if suffix != "" {
+		if _, err := os.Stat(cachePath); err == nil {
+			return cksum, os.Remove(moveFile)
+		}
+		cachePath += suffix
 	}
-	return cksum, nil
+	return cksum, ch.placeObject(cksum, moveFile, cachePath)
 }
 
 func commitDirManifest(ch LocalCache, manifest *directoryManifest) (string, error) {
@@ -244,6 +339,7 @@ func commitDirArtifact(
 	if err != nil {
 		return err
 	}
+	entries = ch.filterIgnored(workPath, entries)
 
 	// Start a goroutine to feed files/sub-directories to workers.
 	errGroup, groupCtx := errgroup.WithContext(ctx)
@@ -310,6 +406,17 @@ func commitDirArtifact(
 
 	close(childArtifacts)
 
+	// Keep the children that are missing because they're excluded from a
+	// sparse checkout.
+	for path, childArt := range oldManifest.Contents {
+		if _, ok := newManifest.Contents[path]; ok {
+			continue
+		}
+		if ch.isExcludedWorkPath(filepath.Join(workPath, path), childArt.IsDir) {
+			newManifest.Contents[path] = childArt
+		}
+	}
+
 	cksum, err := commitDirManifest(ch, newManifest)
 	if err != nil {
 		return err
@@ -407,7 +514,17 @@ func commitWorker(
 				IsDir: entry.IsDir(),
 			}
 		}
-		if childArt.IsDir {
+		isSymlink := false
+		if entry.Type()&fs.ModeSymlink != 0 {
+			isSymlink, err = ch.isTrackedSymlink(filepath.Join(workPath, path))
+			if err != nil {
+				return err
+			}
+		}
+		if isSymlink {
+			childArt = &artifact.Artifact{Path: path}
+			err = commitSymlink(ch, workPath, childArt)
+		} else if childArt.IsDir {
 			err = commitDirArtifact(
 				ctx,
 				ch,
@@ -419,6 +536,8 @@ func commitWorker(
 				canRenameFile,
 			)
 		} else {
+			// The file may have been a tracked symlink before.
+			childArt.IsLink = false
 			err = commitFileArtifact(
 				ch,
 				workPath,
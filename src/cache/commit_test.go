@@ -20,7 +20,12 @@ func TestFileCommitIntegration(t *testing.T) {
 		t.Skip()
 	}
 
-	allStrategies := []strategy.CheckoutStrategy{strategy.LinkStrategy, strategy.CopyStrategy}
+	allStrategies := []strategy.CheckoutStrategy{
+		strategy.LinkStrategy,
+		strategy.CopyStrategy,
+		strategy.HardlinkStrategy,
+		strategy.ReflinkStrategy,
+	}
 
 	happyPath := func(t *testing.T) {
 		for _, strat := range allStrategies {
@@ -38,13 +43,7 @@ func TestFileCommitIntegration(t *testing.T) {
 				},
 				Error: nil,
 			}
-			if strat == strategy.CopyStrategy {
-				out.Status.WorkspaceFileStatus = fsutil.StatusRegularFile
-			} else if strat == strategy.LinkStrategy {
-				out.Status.WorkspaceFileStatus = fsutil.StatusLink
-			} else {
-				panic("unknown strategy")
-			}
+			out.Status.WorkspaceFileStatus = expectedWorkspaceFileStatus(strat)
 
 			t.Run(strat.String(), func(t *testing.T) {
 				testCommitIntegration(in, out, t)
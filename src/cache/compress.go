@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/kevin-hanselman/dud/src/fsutil"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressedSuffix is appended to the path of objects stored
+// zstd-compressed. Compressed objects are still addressed by the checksum of
+// their uncompressed contents, so a cache may hold a mix of compressed and
+// uncompressed objects.
+const compressedSuffix = ".zst"
+
+// Encoders and decoders are relatively expensive to create, so we reuse them.
+// Both are configured to run synchronously (i.e. without spawning their own
+// goroutines), as concurrency is managed by the callers.
+var (
+	encoderPool = sync.Pool{
+		New: func() interface{} {
+			// NewWriter only fails on invalid options.
+			enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			return enc
+		},
+	}
+	decoderPool = sync.Pool{
+		New: func() interface{} {
+			// NewReader only fails on invalid options.
+			dec, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+			return dec
+		},
+	}
+)
+
+// isCompressedObject returns true if the object at path is stored
+// compressed.
+func isCompressedObject(path string) bool {
+	return strings.HasSuffix(path, compressedSuffix)
+}
+
+// findObject returns the location of the object with the given checksum
+// relative to the cache directory, whether it's stored compressed or not. If
+// the object isn't in the cache, findObject returns an error satisfying
+// os.IsNotExist.
+func (ch LocalCache) findObject(checksum string) (string, fs.FileInfo, error) {
+	cachePath, err := ch.PathForChecksum(checksum)
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := os.Stat(filepath.Join(ch.dir, cachePath))
+	if !os.IsNotExist(err) {
+		return cachePath, info, err
+	}
+	compressedInfo, compressedErr := os.Stat(filepath.Join(ch.dir, cachePath+compressedSuffix))
+	if os.IsNotExist(compressedErr) {
+		// Report the uncompressed path, as it's the canonical location.
+		return cachePath, nil, err
+	}
+	return cachePath + compressedSuffix, compressedInfo, compressedErr
+}
+
+// decompressor decompresses the bytes read from an underlying reader. Close
+// releases the decompressor's resources, but doesn't close the underlying
+// reader.
+type decompressor struct {
+	*zstd.Decoder
+}
+
+func newDecompressor(reader io.Reader) (decompressor, error) {
+	dec := decoderPool.Get().(*zstd.Decoder)
+	if err := dec.Reset(reader); err != nil {
+		decoderPool.Put(dec)
+		return decompressor{}, err
+	}
+	return decompressor{dec}, nil
+}
+
+func (dec decompressor) Close() error {
+	decoderPool.Put(dec.Decoder)
+	return nil
+}
+
+// objectReader reads an object, closing both the object's file and its
+// decompressor (if any) on Close.
+type objectReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (reader objectReader) Close() (err error) {
+	for _, closer := range reader.closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+// openObject opens the object at path for reading, transparently
+// decompressing it if necessary. The caller must close the reader.
+func openObject(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !isCompressedObject(path) {
+		return file, nil
+	}
+	dec, err := newDecompressor(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return objectReader{Reader: dec, closers: []io.Closer{dec, file}}, nil
+}
+
+// compressingWriter compresses the bytes written to it. Close flushes the
+// compressed stream, but doesn't close the underlying writer.
+type compressingWriter struct {
+	*zstd.Encoder
+}
+
+func (writer compressingWriter) Close() error {
+	err := writer.Encoder.Close()
+	encoderPool.Put(writer.Encoder)
+	return err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// newObjectWriter wraps w such that bytes written to it are stored in the
+// format the cache uses for new objects. It also returns the suffix to
+// append to the object's path. The caller must close the returned writer
+// before closing w.
+func (ch LocalCache) newObjectWriter(w io.Writer) (io.WriteCloser, string) {
+	if !ch.compress {
+		return nopWriteCloser{w}, ""
+	}
+	enc := encoderPool.Get().(*zstd.Encoder)
+	enc.Reset(w)
+	return compressingWriter{enc}, compressedSuffix
+}
+
+// storeObject writes the bytes from reader to the cache as the object with
+// the given checksum, compressing them if the cache is so configured. The
+// caller is responsible for verifying the checksum, if necessary.
+func (ch LocalCache) storeObject(checksum string, reader io.Reader) error {
+	cachePath, err := ch.PathForChecksum(checksum)
+	if err != nil {
+		return err
+	}
+	tempFile, err := ch.createStagingFile()
+	if err != nil {
+		return err
+	}
+	// If anything goes wrong, don't leave the temporary file behind. If the
+	// file was successfully renamed, this fails harmlessly.
+	defer os.Remove(tempFile.Name())
+	writer, suffix := ch.newObjectWriter(tempFile)
+	_, err = io.Copy(writer, reader)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return ch.placeObject(checksum, tempFile.Name(), filepath.Join(ch.dir, cachePath+suffix))
+}
+
+// sameContentsAsObject returns true if the file at workPath has the same
+// contents as the (possibly compressed) object at objectPath.
+func sameContentsAsObject(workPath, objectPath string) (bool, error) {
+	if !isCompressedObject(objectPath) {
+		return fsutil.SameContents(workPath, objectPath)
+	}
+	object, err := openObject(objectPath)
+	if err != nil {
+		return false, err
+	}
+	defer object.Close()
+	file, err := os.Open(workPath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	return sameContents(file, object)
+}
+
+// sameContents returns true if readerA and readerB produce the same bytes.
+func sameContents(readerA, readerB io.Reader) (bool, error) {
+	bytesA := make([]byte, 1*datasize.MB)
+	bytesB := make([]byte, 1*datasize.MB)
+	for {
+		nBytesReadA, errA := io.ReadFull(readerA, bytesA)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return false, errA
+		}
+		nBytesReadB, errB := io.ReadFull(readerB, bytesB)
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, errB
+		}
+		if !bytes.Equal(bytesA[:nBytesReadA], bytesB[:nBytesReadB]) {
+			return false, nil
+		}
+		// A short read means the reader is exhausted.
+		if nBytesReadA < len(bytesA) {
+			return true, nil
+		}
+	}
+}
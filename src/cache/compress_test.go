@@ -0,0 +1,343 @@
+package cache
+
+import (
+	"io"
+	"io/fs"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/fsutil"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestCompressionIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	// setupCompressionTest returns the test directory (see setupDirTest) and
+	// a cache that compresses objects.
+	setupCompressionTest := func(t *testing.T) (string, artifact.Artifact, LocalCache) {
+		dirs, art, _ := setupDirTest(t)
+		t.Cleanup(func() {
+			os.RemoveAll(dirs.CacheDir)
+			os.RemoveAll(dirs.WorkDir)
+		})
+		ch, err := NewLocalCache(dirs.CacheDir, WithCompression())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dirs.WorkDir, art, ch
+	}
+
+	// objectSuffixes maps each object's checksum to the suffix of its file in
+	// the cache.
+	objectSuffixes := func(t *testing.T, ch LocalCache) map[string]string {
+		suffixes := make(map[string]string)
+		err := ch.walkObjects(func(checksum, cachePath string, _ fs.FileInfo) error {
+			if _, ok := suffixes[checksum]; ok {
+				t.Fatalf("object %s stored more than once", checksum)
+			}
+			suffixes[checksum] = filepath.Ext(cachePath)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return suffixes
+	}
+
+	assertUpToDate := func(t *testing.T, ch LocalCache, workDir string, art artifact.Artifact) {
+		status, err := ch.Status(workDir, art, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !status.ContentsMatch {
+			t.Fatalf("expected artifact to be up-to-date, got %s", status)
+		}
+	}
+
+	t.Run("commit compresses objects", func(t *testing.T) {
+		workDir, art, ch := setupCompressionTest(t)
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+
+		// Checksums are independent of compression.
+		plainArt := artifact.Artifact{Path: art.Path, IsDir: true}
+		plainCache, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := plainCache.Commit(workDir, &plainArt, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		if art.Checksum != plainArt.Checksum {
+			t.Fatalf("checksum = %s, want %s", art.Checksum, plainArt.Checksum)
+		}
+
+		for checksum, suffix := range objectSuffixes(t, ch) {
+			if suffix != compressedSuffix {
+				t.Fatalf("object %s is not compressed", checksum)
+			}
+		}
+		assertUpToDate(t, ch, workDir, art)
+
+		results, err := ch.Verify([]*artifact.Artifact{&art}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 0 {
+			t.Fatalf("compressed objects failed verification: %+v", results)
+		}
+	})
+
+	for _, strat := range []strategy.CheckoutStrategy{
+		strategy.LinkStrategy,
+		strategy.HardlinkStrategy,
+		strategy.ReflinkStrategy,
+	} {
+		t.Run(strat.String()+" falls back to copy", func(t *testing.T) {
+			workDir, art, ch := setupCompressionTest(t)
+			if err := ch.Commit(workDir, &art, strat, logger); err != nil {
+				t.Fatal(err)
+			}
+			// Commit leaves the original files in place.
+			workFile := filepath.Join(workDir, "foo", "bar", "8.txt")
+			fileStatus, err := fsutil.FileStatusFromPath(workFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fileStatus != fsutil.StatusRegularFile {
+				t.Fatalf("after commit, workspace file is a %s", fileStatus)
+			}
+			assertUpToDate(t, ch, workDir, art)
+
+			if err := os.RemoveAll(filepath.Join(workDir, art.Path)); err != nil {
+				t.Fatal(err)
+			}
+			if err := ch.Checkout(workDir, art, strat, nil); err != nil {
+				t.Fatal(err)
+			}
+			fileStatus, err = fsutil.FileStatusFromPath(workFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fileStatus != fsutil.StatusRegularFile {
+				t.Fatalf("after checkout, workspace file is a %s", fileStatus)
+			}
+			contents, err := os.ReadFile(workFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff("8", string(contents)); diff != "" {
+				t.Fatalf("file contents -want +got:\n%s", diff)
+			}
+			assertUpToDate(t, ch, workDir, art)
+		})
+	}
+
+	t.Run("mixed cache", func(t *testing.T) {
+		workDir, art, ch := setupCompressionTest(t)
+		plainCache := ch
+		plainCache.compress = false
+		if err := plainCache.Commit(workDir, &art, strategy.LinkStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		oldObjects := objectSuffixes(t, ch)
+
+		// Add a file and replace a link with a modified copy.
+		newFile := filepath.Join(workDir, "foo", "new.txt")
+		if err := os.WriteFile(newFile, []byte("new"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		modifiedFile := filepath.Join(workDir, "foo", "1.txt")
+		if err := os.Remove(modifiedFile); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(modifiedFile, []byte("one"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Commit(workDir, &art, strategy.LinkStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+
+		newObjects := 0
+		for checksum, suffix := range objectSuffixes(t, ch) {
+			oldSuffix, ok := oldObjects[checksum]
+			if !ok {
+				newObjects++
+				oldSuffix = compressedSuffix
+			}
+			if suffix != oldSuffix {
+				t.Fatalf("object %s has suffix %#v, want %#v", checksum, suffix, oldSuffix)
+			}
+		}
+		// The new file, the modified file, and the new directory manifest.
+		if newObjects != 3 {
+			t.Fatalf("found %d new objects, want 3", newObjects)
+		}
+		assertUpToDate(t, ch, workDir, art)
+
+		// Unmodified files are still linked to uncompressed objects; the rest
+		// are copies.
+		for path, want := range map[string]fsutil.FileStatus{
+			"2.txt":   fsutil.StatusLink,
+			"1.txt":   fsutil.StatusRegularFile,
+			"new.txt": fsutil.StatusRegularFile,
+		} {
+			got, err := fsutil.FileStatusFromPath(filepath.Join(workDir, "foo", path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Fatalf("%s is a %s, want %s", path, got, want)
+			}
+		}
+	})
+
+	t.Run("push and fetch store uncompressed objects remotely", func(t *testing.T) {
+		workDir, art, ch := setupCompressionTest(t)
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		remoteDir := filepath.Join(workDir, "remote")
+		remote, err := NewRemote("file://" + remoteDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		arts := map[string]*artifact.Artifact{"art": &art}
+		if err := ch.Push(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+
+		// The remote can be read as an ordinary uncompressed cache.
+		remoteCache, err := NewLocalCache(remoteDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for checksum, suffix := range objectSuffixes(t, remoteCache) {
+			if suffix != "" {
+				t.Fatalf("remote object %s has suffix %#v", checksum, suffix)
+			}
+		}
+		results, err := remoteCache.Verify([]*artifact.Artifact{&art}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 0 {
+			t.Fatalf("pushed objects failed verification: %+v", results)
+		}
+
+		freshCache, err := NewLocalCache(filepath.Join(workDir, "fresh_cache"), WithCompression())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := freshCache.Fetch(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(objectSuffixes(t, ch), objectSuffixes(t, freshCache)); diff != "" {
+			t.Fatalf("fetched objects -want +got:\n%s", diff)
+		}
+		assertUpToDate(t, freshCache, workDir, art)
+	})
+
+	t.Run("server decompresses objects", func(t *testing.T) {
+		workDir, art, ch := setupCompressionTest(t)
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		server := httptest.NewServer(NewServer(ch, nil, "", logger))
+		defer server.Close()
+		remote, err := NewRemote(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exists, err := remote.Stat(art.Checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatal("expected object to exist")
+		}
+		reader, err := remote.Get(art.Checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		contents, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(contents), `{"path":"foo"`) {
+			t.Fatalf("expected a directory manifest, got %q", contents)
+		}
+	})
+
+	t.Run("verify detects corrupt compressed objects", func(t *testing.T) {
+		workDir, art, ch := setupCompressionTest(t)
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		man, err := readDirManifest(filepath.Join(ch.dir, foundObjectPath(t, ch, art.Checksum)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cases := map[string]struct {
+			contents string
+			want     ObjectProblem
+		}{
+			"1.txt": {"not zstd", ObjectCorrupt},
+			"2.txt": {"", ObjectTruncated},
+		}
+		for path, testCase := range cases {
+			objPath := filepath.Join(ch.dir, foundObjectPath(t, ch, man.Contents[path].Checksum))
+			if err := os.Chmod(objPath, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(objPath, []byte(testCase.contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(objPath, cacheFilePerms); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		results, err := ch.Verify([]*artifact.Artifact{&art}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]ObjectProblem)
+		for _, result := range results {
+			if !result.Quarantined {
+				t.Fatalf("expected object to be quarantined: %+v", result)
+			}
+			got[result.Checksum] = result.Problem
+		}
+		want := make(map[string]ObjectProblem)
+		for path, testCase := range cases {
+			want[man.Contents[path].Checksum] = testCase.want
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("Verify() -want +got:\n%s", diff)
+		}
+	})
+}
+
+// foundObjectPath returns the location of an object in the cache, whether
+// it's compressed or not.
+func foundObjectPath(t *testing.T, ch LocalCache, checksum string) string {
+	cachePath, _, err := ch.findObject(checksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cachePath
+}
@@ -0,0 +1,452 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/kevin-hanselman/dud/src/checksum"
+	"github.com/pkg/errors"
+)
+
+const (
+	// EncryptionKeySize is the size in bytes of the keys used by encrypted
+	// Remotes.
+	EncryptionKeySize = 32
+
+	// encryptedMagic identifies the format of encrypted objects.
+	encryptedMagic = "dudenc1\x00"
+	// encryptedSaltSize is the size of the random salt from which the key of
+	// each encrypted object is derived.
+	encryptedSaltSize = 32
+	// encryptedSegmentSize is the size of the plaintext segments that are
+	// encrypted and authenticated individually, so that objects can be
+	// streamed.
+	encryptedSegmentSize = 64 * 1024
+)
+
+// An encryptedRemote is a Remote that encrypts objects before storing them in
+// another Remote, and decrypts and verifies them when they're retrieved.
+//
+// Objects are stored under a keyed hash of their checksums, so the
+// underlying Remote learns nothing about the objects' contents, not even
+// their checksums. Each object is encrypted with AES-256-GCM, using a key
+// derived from the project key and a random salt stored with the object. The
+// plaintext is encrypted in segments, each authenticated with its position
+// in the object and whether it's the last segment, so truncated or reordered
+// objects are detected.
+type encryptedRemote struct {
+	remote Remote
+	// nameKey is used to derive object names from checksums.
+	nameKey []byte
+	// encryptionKey is used to derive the key of each object.
+	encryptionKey []byte
+}
+
+// NewEncryptedRemote returns a Remote that encrypts objects with the given
+// key before storing them in remote. The key must be EncryptionKeySize bytes,
+// and should be random and kept secret. Objects in an encrypted Remote can't
+// be listed, as their checksums are unknown to the Remote.
+//
+// HTTP remotes can't be encrypted, as 'dud serve' verifies the objects pushed
+// to it against their checksums.
+func NewEncryptedRemote(remote Remote, key []byte) (Remote, error) {
+	if _, ok := remote.(httpRemote); ok {
+		return nil, errors.New("encryption isn't supported with http remotes")
+	}
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf(
+			"encryption key must be %d bytes, got %d",
+			EncryptionKeySize,
+			len(key),
+		)
+	}
+	encrypted := encryptedRemote{
+		remote:        remote,
+		nameKey:       deriveKey(key, "dud object name"),
+		encryptionKey: deriveKey(key, "dud object encryption"),
+	}
+	if batch, ok := remote.(batchRemote); ok {
+		return encryptedBatchRemote{encryptedRemote: encrypted, batch: batch}, nil
+	}
+	return encrypted, nil
+}
+
+// deriveKey derives a key for the given purpose from key.
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// objectName returns the name under which the object with the given checksum
+// is stored in the underlying Remote. Names look like checksums of the
+// default hash algorithm, so the Remote can store them as usual.
+func (remote encryptedRemote) objectName(cksum string) (string, error) {
+	if _, err := pathForChecksum(cksum); err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, remote.nameKey)
+	mac.Write([]byte(cksum))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// newObjectCipher returns the cipher for the encrypted object with the given
+// salt.
+func (remote encryptedRemote) newObjectCipher(salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(remote.encryptionKey, string(salt)))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (remote encryptedRemote) Stat(cksum string) (bool, error) {
+	name, err := remote.objectName(cksum)
+	if err != nil {
+		return false, err
+	}
+	return remote.remote.Stat(name)
+}
+
+// Get returns a reader for the decrypted object with the given checksum. If
+// the object can't be decrypted or its contents don't match the checksum,
+// reading from it fails.
+func (remote encryptedRemote) Get(cksum string) (io.ReadCloser, error) {
+	name, err := remote.objectName(cksum)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := remote.remote.Get(name)
+	if _, ok := err.(MissingFromRemoteError); ok {
+		return nil, MissingFromRemoteError{cksum}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return remote.decrypt(cksum, reader)
+}
+
+// decrypt returns a reader for the decrypted contents of the encrypted object
+// read from reader (see Get). decrypt closes reader if it fails.
+func (remote encryptedRemote) decrypt(cksum string, reader io.ReadCloser) (io.ReadCloser, error) {
+	algo, _, err := checksum.Parse(cksum)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	header := make([]byte, len(encryptedMagic)+encryptedSaltSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		reader.Close()
+		return nil, errors.Wrapf(err, "read encrypted object %s", cksum)
+	}
+	if !bytes.HasPrefix(header, []byte(encryptedMagic)) {
+		reader.Close()
+		return nil, fmt.Errorf("object %s is not encrypted", cksum)
+	}
+	aead, err := remote.newObjectCipher(header[len(encryptedMagic):])
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return &decryptingReader{
+		source:   reader,
+		aead:     aead,
+		checksum: cksum,
+		hash:     algo.NewHash(),
+		algo:     algo,
+	}, nil
+}
+
+// Put encrypts the bytes from reader and stores them as the object with the
+// given checksum.
+func (remote encryptedRemote) Put(cksum string, reader io.Reader, size int64) error {
+	name, err := remote.objectName(cksum)
+	if err != nil {
+		return err
+	}
+	encrypted, encryptedSize, err := remote.encrypt(reader, size)
+	if err != nil {
+		return err
+	}
+	return remote.remote.Put(name, encrypted, encryptedSize)
+}
+
+// encrypt returns a reader for the encrypted object holding the bytes from
+// reader, and the size of the encrypted object. As with Put, size may be -1
+// if unknown, in which case the encrypted size is too.
+func (remote encryptedRemote) encrypt(reader io.Reader, size int64) (io.Reader, int64, error) {
+	header := make([]byte, len(encryptedMagic)+encryptedSaltSize)
+	copy(header, encryptedMagic)
+	if _, err := rand.Read(header[len(encryptedMagic):]); err != nil {
+		return nil, 0, err
+	}
+	aead, err := remote.newObjectCipher(header[len(encryptedMagic):])
+	if err != nil {
+		return nil, 0, err
+	}
+	encryptedSize := int64(-1)
+	if size >= 0 {
+		// Every segment is full-size except the last, which may be empty.
+		numSegments := size/encryptedSegmentSize + 1
+		encryptedSize = int64(len(header)) + size + numSegments*int64(aead.Overhead())
+	}
+	encrypted := io.MultiReader(
+		bytes.NewReader(header),
+		&encryptingReader{source: reader, aead: aead},
+	)
+	return encrypted, encryptedSize, nil
+}
+
+// List always fails, as an encrypted Remote doesn't know the checksums of
+// its objects.
+func (remote encryptedRemote) List(listFn func(checksum string) error) error {
+	return errors.New("objects in an encrypted remote can't be listed")
+}
+
+// An encryptedBatchRemote is an encryptedRemote over a batchRemote. Objects
+// are encrypted into (or decrypted from) a temporary directory in the local
+// cache's staging directory, so batch transfers temporarily need as much
+// extra disk space as the objects being transferred.
+type encryptedBatchRemote struct {
+	encryptedRemote
+	batch batchRemote
+}
+
+// encryptedFileSet returns the paths of the encrypted objects for the object
+// paths in fileSet, mapped to the object paths they encrypt.
+func (remote encryptedBatchRemote) encryptedFileSet(
+	fileSet map[string]struct{},
+) (map[string]string, error) {
+	encryptedPaths := make(map[string]string, len(fileSet))
+	for path := range fileSet {
+		cksum, ok := checksumFromPath(filepath.ToSlash(path))
+		if !ok {
+			return nil, fmt.Errorf("invalid object path %#v", path)
+		}
+		name, err := remote.objectName(cksum)
+		if err != nil {
+			return nil, err
+		}
+		// Object names are valid checksums.
+		encryptedPath, _ := pathForChecksum(name)
+		encryptedPaths[encryptedPath] = path
+	}
+	return encryptedPaths, nil
+}
+
+// pushFiles encrypts the objects in fileSet into a temporary directory and
+// pushes them from there in one batch.
+func (remote encryptedBatchRemote) pushFiles(cacheDir string, fileSet map[string]struct{}) error {
+	encryptedPaths, err := remote.encryptedFileSet(fileSet)
+	if err != nil {
+		return err
+	}
+	tempDir, err := os.MkdirTemp(filepath.Join(cacheDir, stagingDir), "push")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+	encryptedFiles := make(map[string]struct{}, len(encryptedPaths))
+	for encryptedPath, path := range encryptedPaths {
+		if err := remote.encryptFile(
+			filepath.Join(cacheDir, path),
+			filepath.Join(tempDir, encryptedPath),
+		); err != nil {
+			return err
+		}
+		encryptedFiles[encryptedPath] = struct{}{}
+	}
+	return remote.batch.pushFiles(tempDir, encryptedFiles)
+}
+
+// encryptFile writes the encrypted contents of the file at srcPath to
+// a new file at dstPath.
+func (remote encryptedBatchRemote) encryptFile(srcPath, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	encrypted, _, err := remote.encrypt(src, info.Size())
+	if err != nil {
+		return err
+	}
+	return writeNewFile(dstPath, encrypted)
+}
+
+// fetchFiles fetches the encrypted objects for fileSet into a temporary
+// directory in one batch, and decrypts them into cacheDir. As with other
+// batch fetches, objects missing from the remote are skipped.
+func (remote encryptedBatchRemote) fetchFiles(cacheDir string, fileSet map[string]struct{}) error {
+	encryptedPaths, err := remote.encryptedFileSet(fileSet)
+	if err != nil {
+		return err
+	}
+	// The caller's cacheDir is already a temporary directory, and it only
+	// looks for the paths in fileSet.
+	tempDir, err := os.MkdirTemp(cacheDir, "encrypted")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+	encryptedFiles := make(map[string]struct{}, len(encryptedPaths))
+	for encryptedPath := range encryptedPaths {
+		encryptedFiles[encryptedPath] = struct{}{}
+	}
+	if err := remote.batch.fetchFiles(tempDir, encryptedFiles); err != nil {
+		return err
+	}
+	for encryptedPath, path := range encryptedPaths {
+		reader, err := os.Open(filepath.Join(tempDir, encryptedPath))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// Object paths are valid checksums (see encryptedFileSet).
+		cksum, _ := checksumFromPath(filepath.ToSlash(path))
+		decrypted, err := remote.decrypt(cksum, reader)
+		if err != nil {
+			return err
+		}
+		err = writeNewFile(filepath.Join(cacheDir, path), decrypted)
+		decrypted.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeNewFile creates the file at path, and its parent directories, and
+// writes the bytes from reader to it.
+func writeNewFile(path string, reader io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// segmentNonce returns the nonce of the segment at the given index. Each
+// object has its own key, so nonces only need to be unique within an object.
+// Marking the last segment prevents truncating objects at a segment boundary.
+func segmentNonce(aead cipher.AEAD, index uint64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// An encryptingReader encrypts the bytes read from source in segments of
+// encryptedSegmentSize. The last segment is always shorter than
+// encryptedSegmentSize, and may be empty.
+type encryptingReader struct {
+	source  io.Reader
+	aead    cipher.AEAD
+	index   uint64
+	pending []byte
+	done    bool
+}
+
+func (reader *encryptingReader) Read(p []byte) (int, error) {
+	for len(reader.pending) == 0 {
+		if reader.done {
+			return 0, io.EOF
+		}
+		plaintext := make([]byte, encryptedSegmentSize)
+		n, err := io.ReadFull(reader.source, plaintext)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			reader.done = true
+		} else if err != nil {
+			return 0, err
+		}
+		nonce := segmentNonce(reader.aead, reader.index, reader.done)
+		reader.pending = reader.aead.Seal(nil, nonce, plaintext[:n], nil)
+		reader.index++
+	}
+	n := copy(p, reader.pending)
+	reader.pending = reader.pending[n:]
+	return n, nil
+}
+
+// A decryptingReader decrypts the segments read from source (see
+// encryptingReader), and verifies the plaintext against checksum once it's
+// read in full.
+type decryptingReader struct {
+	source   io.ReadCloser
+	aead     cipher.AEAD
+	checksum string
+	hash     hash.Hash
+	algo     *checksum.Algorithm
+	index    uint64
+	pending  []byte
+	done     bool
+}
+
+func (reader *decryptingReader) Read(p []byte) (int, error) {
+	for len(reader.pending) == 0 {
+		if reader.done {
+			return 0, io.EOF
+		}
+		if err := reader.nextSegment(); err != nil {
+			return 0, errors.Wrapf(err, "decrypt object %s", reader.checksum)
+		}
+	}
+	n := copy(p, reader.pending)
+	reader.pending = reader.pending[n:]
+	return n, nil
+}
+
+func (reader *decryptingReader) nextSegment() error {
+	ciphertext := make([]byte, encryptedSegmentSize+reader.aead.Overhead())
+	n, err := io.ReadFull(reader.source, ciphertext)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		reader.done = true
+	} else if err != nil {
+		return err
+	}
+	nonce := segmentNonce(reader.aead, reader.index, reader.done)
+	plaintext, err := reader.aead.Open(nil, nonce, ciphertext[:n], nil)
+	if err != nil {
+		return errors.New("object is corrupt or was encrypted with a different key")
+	}
+	reader.index++
+	reader.hash.Write(plaintext)
+	if reader.done {
+		if actual := reader.algo.FormatSum(reader.hash.Sum(nil)); actual != reader.checksum {
+			return fmt.Errorf("found checksum %#v", actual)
+		}
+	}
+	reader.pending = plaintext
+	return nil
+}
+
+func (reader *decryptingReader) Close() error {
+	return reader.source.Close()
+}
@@ -0,0 +1,303 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/checksum"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestEncryptedRemoteIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	newKey := func(t *testing.T) []byte {
+		key := make([]byte, EncryptionKeySize)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	newEncryptedRemote := func(t *testing.T, dir string, key []byte) Remote {
+		inner, err := newFileRemote(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		remote, err := NewEncryptedRemote(inner, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return remote
+	}
+
+	// remoteFiles returns the paths of all files in dir, relative to dir.
+	remoteFiles := func(t *testing.T, dir string) []string {
+		var files []string
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			relPath, err := filepath.Rel(dir, path)
+			files = append(files, relPath)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+
+	t.Run("put then get", func(t *testing.T) {
+		dir := t.TempDir()
+		key := newKey(t)
+		remote := newEncryptedRemote(t, dir, key)
+		sizes := []int{
+			0,
+			100,
+			encryptedSegmentSize,
+			encryptedSegmentSize + 1,
+			3 * encryptedSegmentSize,
+		}
+		for _, size := range sizes {
+			contents := bytes.Repeat([]byte("secret!"), size/7+1)[:size]
+			cksum, err := checksum.Checksum(bytes.NewReader(contents))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := remote.Put(cksum, bytes.NewReader(contents), int64(size)); err != nil {
+				t.Fatal(err)
+			}
+			exists, err := remote.Stat(cksum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !exists {
+				t.Fatalf("size %d: object missing after Put", size)
+			}
+
+			reader, err := remote.Get(cksum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				t.Fatalf("size %d: %v", size, err)
+			}
+			if !bytes.Equal(contents, got) {
+				t.Fatalf("size %d: decrypted contents differ", size)
+			}
+		}
+
+		// Neither the checksums nor the contents of objects are stored in the
+		// clear.
+		files := remoteFiles(t, dir)
+		if len(files) != len(sizes) {
+			t.Fatalf("expected %d files in remote, got %v", len(sizes), files)
+		}
+		for _, file := range files {
+			encrypted, err := os.ReadFile(filepath.Join(dir, file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(encrypted, []byte("secret!")) {
+				t.Fatalf("%s contains plaintext", file)
+			}
+		}
+	})
+
+	t.Run("get with the wrong key fails", func(t *testing.T) {
+		dir := t.TempDir()
+		contents := []byte("hello, world")
+		cksum, err := checksum.Checksum(bytes.NewReader(contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := newKey(t)
+		remote := newEncryptedRemote(t, dir, key)
+		if err := remote.Put(cksum, bytes.NewReader(contents), -1); err != nil {
+			t.Fatal(err)
+		}
+
+		// With a different key, objects have different names.
+		otherRemote := newEncryptedRemote(t, dir, newKey(t))
+		if _, err := otherRemote.Get(cksum); err == nil {
+			t.Fatal("expected error getting object with the wrong key")
+		}
+
+		// Even if the object is found, it can't be decrypted.
+		file := remoteFiles(t, dir)[0]
+		encrypted, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherInner, err := newFileRemote(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, err := otherRemote.(encryptedRemote).objectName(cksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := otherInner.Put(name, bytes.NewReader(encrypted), -1); err != nil {
+			t.Fatal(err)
+		}
+		reader, err := otherRemote.Get(cksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		if _, err := io.ReadAll(reader); err == nil {
+			t.Fatal("expected error decrypting object with the wrong key")
+		}
+	})
+
+	t.Run("truncated objects are detected", func(t *testing.T) {
+		dir := t.TempDir()
+		contents := bytes.Repeat([]byte("a"), 2*encryptedSegmentSize+10)
+		cksum, err := checksum.Checksum(bytes.NewReader(contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		remote := newEncryptedRemote(t, dir, newKey(t))
+		if err := remote.Put(cksum, bytes.NewReader(contents), int64(len(contents))); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, remoteFiles(t, dir)[0])
+		encrypted, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// Cut the object at the end of the first segment.
+		headerSize := len(encryptedMagic) + encryptedSaltSize
+		segmentSize := encryptedSegmentSize + 16
+		if err := os.WriteFile(path, encrypted[:headerSize+segmentSize], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		reader, err := remote.Get(cksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		if _, err := io.ReadAll(reader); err == nil {
+			t.Fatal("expected error reading truncated object")
+		}
+	})
+
+	t.Run("push then fetch", func(t *testing.T) {
+		logger := agglog.NewNullLogger()
+		dirs, art, ch := setupDirTest(t)
+		defer os.RemoveAll(dirs.CacheDir)
+		defer os.RemoveAll(dirs.WorkDir)
+		if err := ch.Commit(dirs.WorkDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		remoteDir := t.TempDir()
+		remote := newEncryptedRemote(t, remoteDir, newKey(t))
+		arts := map[string]*artifact.Artifact{art.Path: &art}
+		if err := ch.Push(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, file := range remoteFiles(t, remoteDir) {
+			name := strings.ReplaceAll(file, string(filepath.Separator), "")
+			if objectChecksums(t, ch)[name] {
+				t.Fatalf("remote object %s is named after its checksum", file)
+			}
+		}
+
+		fetchCache, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fetchCache.Fetch(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(objectChecksums(t, ch), objectChecksums(t, fetchCache)); diff != "" {
+			t.Fatalf("fetched objects -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("batch push then fetch", func(t *testing.T) {
+		remoteCopyOrig := remoteCopy
+		remoteCopy = mockRemoteCopy
+		defer func() { remoteCopy = remoteCopyOrig }()
+
+		logger := agglog.NewNullLogger()
+		dirs, art, ch := setupDirTest(t)
+		defer os.RemoveAll(dirs.CacheDir)
+		defer os.RemoveAll(dirs.WorkDir)
+		if err := ch.Commit(dirs.WorkDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		remoteDir := t.TempDir()
+		remote, err := NewEncryptedRemote(rcloneRemote{remote: remoteDir}, newKey(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := remote.(batchRemote); !ok {
+			t.Fatal("expected encrypted rclone remote to transfer objects in batches")
+		}
+		arts := map[string]*artifact.Artifact{art.Path: &art}
+		if err := ch.Push(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+
+		files := remoteFiles(t, remoteDir)
+		if len(files) != len(objectChecksums(t, ch)) {
+			t.Fatalf("expected %d files in remote, got %v", len(objectChecksums(t, ch)), files)
+		}
+		for _, file := range files {
+			name := strings.ReplaceAll(file, string(filepath.Separator), "")
+			if objectChecksums(t, ch)[name] {
+				t.Fatalf("remote object %s is named after its checksum", file)
+			}
+		}
+
+		fetchCache, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fetchCache.Fetch(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(objectChecksums(t, ch), objectChecksums(t, fetchCache)); diff != "" {
+			t.Fatalf("fetched objects -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("invalid key size", func(t *testing.T) {
+		inner, err := newFileRemote(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewEncryptedRemote(inner, []byte("too short")); err == nil {
+			t.Fatal("expected error for short key")
+		}
+	})
+
+	t.Run("http remotes are rejected", func(t *testing.T) {
+		inner, err := NewRemote("http://localhost:8080/cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewEncryptedRemote(inner, newKey(t)); err == nil {
+			t.Fatal("expected error encrypting an http remote")
+		}
+	})
+}
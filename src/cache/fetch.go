@@ -1,33 +1,84 @@
 package cache
 
 import (
+	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/checksum"
 	"github.com/pkg/errors"
 )
 
+// ChecksumMismatchError is an error case where fetched objects didn't match
+// their checksums, e.g. because of a truncated download. The fetched files
+// are moved to the cache's quarantine directory instead of the cache.
+type ChecksumMismatchError struct {
+	// Checksums holds the checksum of every mismatched object, sorted.
+	Checksums []string
+}
+
+func (err ChecksumMismatchError) Error() string {
+	return fmt.Sprintf(
+		"%d fetched objects did not match their checksums and were quarantined: %s",
+		len(err.Checksums),
+		strings.Join(err.Checksums, ", "),
+	)
+}
+
 // Fetch downloads an Artifact from a remote location to the local cache.
+// Every fetched object is verified against its checksum before it's added to
+// the cache. If any objects don't match, Fetch fetches as much as it can and
+// then returns a ChecksumMismatchError listing them. In a sparse checkout,
+// only the parts of Artifacts in the checkout are fetched (see WithSparse).
 //
 // This uses a map of Artifacts instead of a slice to ease both testing and
 // calling code. Primarily, a Stage's outputs will be passed to this function,
 // so it's convenient to pass stage.Outputs directly. This also eases testing,
 // because transcribing the map into a slice would introduce non-determinism.
 func (ch LocalCache) Fetch(
-	remoteSrc string,
+	remote Remote,
+	artifacts map[string]*artifact.Artifact,
+) error {
+	mismatched := make(map[string]struct{})
+	if err := ch.fetch(remote, globBaseArtifacts(artifacts), mismatched); err != nil {
+		return err
+	}
+	if len(mismatched) == 0 {
+		return nil
+	}
+	mismatchErr := ChecksumMismatchError{}
+	for cksum := range mismatched {
+		mismatchErr.Checksums = append(mismatchErr.Checksums, cksum)
+	}
+	sort.Strings(mismatchErr.Checksums)
+	return errors.Wrap(mismatchErr, "fetch")
+}
+
+// fetch implements Fetch, adding the checksums of mismatched objects to
+// mismatched.
+func (ch LocalCache) fetch(
+	remote Remote,
 	artifacts map[string]*artifact.Artifact,
+	mismatched map[string]struct{},
 ) error {
 	fetchFiles := make(map[string]struct{})
-	dirArtifacts := make(map[string]*artifact.Artifact)
+	// Directory and chunked file Artifacts, whose manifests reference more
+	// objects to fetch.
+	parentArtifacts := make(map[string]*artifact.Artifact)
 	// It's important not to use/assume what the string key in 'artifacts'
 	// represents. Before recursing below, we change the keys to checksums to
 	// prevent Artifacts with the same relative path from clobbering each
 	// other.
 	for _, art := range artifacts {
-		if art.SkipCache {
+		if art.SkipCache || ch.isExcluded(art.Path, art.IsDir) {
 			continue
 		}
-		status, cachePath, _, err := checksumStatus(ch, *art)
+		status, _, _, err := checksumStatus(ch, *art)
 		if err != nil {
 			return errors.Wrapf(err, "fetch %s", art.Path)
 		}
@@ -40,40 +91,185 @@ func (ch LocalCache) Fetch(
 		}
 		// Fetch an artifact if it's missing from the cache.
 		if !status.ChecksumInCache {
-			fetchFiles[cachePath] = struct{}{}
+			fetchFiles[art.Checksum] = struct{}{}
 		}
-		if art.IsDir {
-			dirArtifacts[cachePath] = art
+		if art.IsDir || art.IsChunked {
+			parentArtifacts[ch.sparseKey(art)] = art
 		}
 	}
 
-	// This length check could/should be handled in remoteCopy, but the tests
-	// currently expect remoteCopy not to be called if there's nothing to
-	// fetch.
 	if len(fetchFiles) > 0 {
-		if err := remoteCopy(remoteSrc, ch.dir, fetchFiles); err != nil {
+		if err := fetchObjects(ch, remote, fetchFiles, mismatched); err != nil {
 			return errors.Wrap(err, "fetch")
 		}
 	}
 
 	children := make(map[string]*artifact.Artifact)
-	// Collect all children of directory artifacts and call Fetch
-	// on all of them at once.
-	for cachePath, dirArt := range dirArtifacts {
-		man, err := readDirManifest(filepath.Join(ch.dir, cachePath))
+	// Collect all children of directory artifacts (and all chunks of chunked
+	// files) and call Fetch on all of them at once.
+	for _, parentArt := range parentArtifacts {
+		checksum := parentArt.Checksum
+		// The manifest wasn't added to the cache, so its children can't be
+		// found.
+		if _, ok := mismatched[checksum]; ok {
+			continue
+		}
+		// Find the manifest only now that it's been fetched, because its
+		// location depends on whether it was stored compressed.
+		cachePath, _, err := ch.findObject(checksum)
+		if err != nil {
+			return errors.Wrapf(err, "fetch %s", parentArt.Path)
+		}
+		cachePath = filepath.Join(ch.dir, cachePath)
+		if parentArt.IsChunked {
+			man, err := readChunkManifest(cachePath)
+			if err != nil {
+				return errors.Wrapf(err, "fetch %s: invalid chunk manifest", parentArt.Path)
+			}
+			for _, chunk := range man.Chunks {
+				chunkArt := &artifact.Artifact{
+					Checksum: chunk.Checksum,
+					Path:     parentArt.Path,
+				}
+				children[ch.sparseKey(chunkArt)] = chunkArt
+			}
+			continue
+		}
+		// Validate the whole manifest before fetching any of its children.
+		man, err := readDirManifest(cachePath)
 		if err != nil {
-			return errors.Wrapf(err, "fetch %s", dirArt.Path)
+			return errors.Wrapf(err, "fetch %s: invalid directory manifest", parentArt.Path)
 		}
 		for _, art := range man.Contents {
-			// Use the Artifact's checksum as a key to ensure Artifacts with
-			// the same relative path (from different parent Artifacts) don't
-			// clobber each other.
-			children[art.Checksum] = art
+			// Give the child its path relative to the workspace, which
+			// decides whether it's part of a sparse checkout.
+			child := *art
+			child.Path = filepath.Join(parentArt.Path, child.Path)
+			// See sparseKey.
+			children[ch.sparseKey(&child)] = &child
 		}
 	}
 	if len(children) == 0 {
 		return nil
 	}
 	// Don't wrap any error here because we're recursing.
-	return ch.Fetch(remoteSrc, children)
+	return ch.fetch(remote, children, mismatched)
+}
+
+// fetchObjects downloads the objects with the given checksums into the
+// cache's staging directory, verifies them, and moves them into place (see
+// admitFetchedFile). Remotes store objects uncompressed, so if the cache
+// compresses objects, each object is fetched and compressed individually. The
+// checksums of objects that fail verification are added to mismatched.
+func fetchObjects(
+	ch LocalCache,
+	remote Remote,
+	checksums map[string]struct{},
+	mismatched map[string]struct{},
+) error {
+	var mutex sync.Mutex
+	recordMismatch := func(checksum string, ok bool, err error) error {
+		if err == nil && !ok {
+			mutex.Lock()
+			mismatched[checksum] = struct{}{}
+			mutex.Unlock()
+		}
+		return err
+	}
+
+	if batch, ok := remote.(batchRemote); ok && !ch.compress {
+		stagingPath := filepath.Join(ch.dir, stagingDir)
+		if err := ch.mkdirAll(stagingPath); err != nil {
+			return err
+		}
+		tempDir, err := os.MkdirTemp(stagingPath, "fetch")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tempDir)
+		if err := batch.fetchFiles(tempDir, toFileSet(checksums)); err != nil {
+			return err
+		}
+		return transferObjects(checksums, "Verifying", func(checksum string) error {
+			// The checksums have already been validated by the caller.
+			path, _ := pathForChecksum(checksum)
+			tempPath := filepath.Join(tempDir, path)
+			// Objects missing from the remote aren't fetched; later uses of
+			// the object will report it missing from the cache.
+			if _, err := os.Stat(tempPath); os.IsNotExist(err) {
+				return nil
+			}
+			ok, err := ch.admitFetchedFile(checksum, tempPath)
+			return recordMismatch(checksum, ok, err)
+		})
+	}
+	return transferObjects(checksums, "Fetching", func(checksum string) error {
+		ok, err := fetchObject(ch, remote, checksum)
+		return recordMismatch(checksum, ok, err)
+	})
+}
+
+// fetchObject downloads the object with the given checksum into the cache's
+// staging directory, then adds it to the cache (see admitFetchedFile). It
+// returns false if the downloaded object didn't match its checksum.
+func fetchObject(ch LocalCache, remote Remote, checksum string) (bool, error) {
+	reader, err := remote.Get(checksum)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+	tempFile, err := ch.createStagingFile()
+	if err != nil {
+		return false, err
+	}
+	// If anything goes wrong, don't leave the temporary file behind. If the
+	// file was moved, this fails harmlessly.
+	defer os.Remove(tempFile.Name())
+	_, err = io.Copy(tempFile, reader)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	// Closing the reader may reveal errors, e.g. from a failed download.
+	if closeErr := reader.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+	return ch.admitFetchedFile(checksum, tempFile.Name())
+}
+
+// admitFetchedFile re-hashes the fetched file at tempPath and, if it matches
+// the given checksum, moves it into the cache as the object with that
+// checksum, compressing it if the cache is so configured. Otherwise, the file
+// is moved to the quarantine directory for inspection, and admitFetchedFile
+// returns false.
+func (ch LocalCache) admitFetchedFile(cksum, tempPath string) (bool, error) {
+	cachePath, err := pathForChecksum(cksum)
+	if err != nil {
+		return false, err
+	}
+	file, err := os.Open(tempPath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	ok, _, err := checksum.Verify(file, cksum)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		dst := filepath.Join(ch.dir, quarantineDir, cachePath)
+		if err := ch.mkdirAll(filepath.Dir(dst)); err != nil {
+			return false, err
+		}
+		return false, os.Rename(tempPath, dst)
+	}
+	if ch.compress {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		return true, ch.storeObject(cksum, file)
+	}
+	return true, ch.placeObject(cksum, tempPath, filepath.Join(ch.dir, cachePath))
 }
@@ -3,8 +3,10 @@ package cache
 import (
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/kevin-hanselman/dud/src/agglog"
 	"github.com/kevin-hanselman/dud/src/artifact"
 	"github.com/kevin-hanselman/dud/src/fsutil"
@@ -24,6 +26,10 @@ func getCacheFiles(cacheDir string) (map[string]struct{}, error) {
 		if err != nil {
 			return nil, err
 		}
+		// Skip bookkeeping files (e.g. locks) that aren't objects.
+		if !isShardDir(filepath.Dir(relFile)) {
+			continue
+		}
 		fileSet[relFile] = struct{}{}
 	}
 	return fileSet, nil
@@ -98,9 +104,12 @@ func TestFetchIntegration(t *testing.T) {
 
 	t.Run("fetch file artifact happy path", func(t *testing.T) {
 		defer resetMocks()
+		// The workspace file must match the Artifact's checksum, as fetched
+		// objects are verified.
 		artStatus := artifact.Status{
 			HasChecksum:         true,
 			WorkspaceFileStatus: fsutil.StatusRegularFile,
+			ContentsMatch:       true,
 		}
 
 		dirs, art, err := testutil.CreateArtifactTestCase(artStatus)
@@ -132,7 +141,7 @@ func TestFetchIntegration(t *testing.T) {
 
 		remoteCopy = mockRemoteCopy
 
-		if err := ch.Fetch(fakeRemote, map[string]*artifact.Artifact{"art": &art}); err != nil {
+		if err := ch.Fetch(rcloneRemote{remote: fakeRemote}, map[string]*artifact.Artifact{"art": &art}); err != nil {
 			t.Fatal(err)
 		}
 
@@ -155,7 +164,7 @@ func TestFetchIntegration(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if err := ch.Fetch("/dev/null", map[string]*artifact.Artifact{"art": &art}); err != nil {
+		if err := ch.Fetch(rcloneRemote{remote: "/dev/null"}, map[string]*artifact.Artifact{"art": &art}); err != nil {
 			t.Fatal(err)
 		}
 	})
@@ -176,7 +185,7 @@ func TestFetchIntegration(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		fetchErr := ch.Fetch("/dev/null", map[string]*artifact.Artifact{"art": &art})
+		fetchErr := ch.Fetch(rcloneRemote{remote: "/dev/null"}, map[string]*artifact.Artifact{"art": &art})
 		if fetchErr == nil {
 			t.Fatal("expected Fetch to return error")
 		}
@@ -217,7 +226,7 @@ func TestFetchIntegration(t *testing.T) {
 
 		remoteCopy = mockRemoteCopy
 
-		if err := cache.Fetch(fakeRemote, map[string]*artifact.Artifact{"art": &art}); err != nil {
+		if err := cache.Fetch(rcloneRemote{remote: fakeRemote}, map[string]*artifact.Artifact{"art": &art}); err != nil {
 			t.Fatal(err)
 		}
 
@@ -285,10 +294,141 @@ func TestFetchIntegration(t *testing.T) {
 
 		remoteCopy = mockRemoteCopy
 
-		if err := cache.Fetch(fakeRemote, map[string]*artifact.Artifact{"art": &art}); err != nil {
+		if err := cache.Fetch(rcloneRemote{remote: fakeRemote}, map[string]*artifact.Artifact{"art": &art}); err != nil {
 			t.Fatal(err)
 		}
 
 		assertCacheDirsEqual(dirs.CacheDir, fakeRemote, t)
 	})
 }
+
+func TestFetchVerificationIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	remoteCopyOrig := remoteCopy
+	remoteCopy = mockRemoteCopy
+	defer func() { remoteCopy = remoteCopyOrig }()
+
+	// setupFetchTest commits the test directory and pushes it to a directory
+	// remote. It returns the committed Artifact, the remote's directory, and
+	// the directory's manifest.
+	setupFetchTest := func(t *testing.T) (artifact.Artifact, string, directoryManifest) {
+		dirs, art, ch := setupDirTest(t)
+		t.Cleanup(func() {
+			os.RemoveAll(dirs.CacheDir)
+			os.RemoveAll(dirs.WorkDir)
+		})
+		if err := ch.Commit(dirs.WorkDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		remoteDir := t.TempDir()
+		remote, err := newFileRemote(remoteDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Push(remote, map[string]*artifact.Artifact{art.Path: &art}); err != nil {
+			t.Fatal(err)
+		}
+		man, err := readDirManifest(objectPath(t, ch, art.Checksum))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return art, remoteDir, man
+	}
+
+	corrupt := func(t *testing.T, remoteDir, checksum string) {
+		path, err := pathForChecksum(checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path = filepath.Join(remoteDir, path)
+		if err := os.Chmod(path, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("garbage"), 0o444); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertMismatched := func(t *testing.T, err error, want ...string) {
+		var mismatchErr ChecksumMismatchError
+		if !errors.As(err, &mismatchErr) {
+			t.Fatalf("expected ChecksumMismatchError, got %#v", err)
+		}
+		sort.Strings(want)
+		if diff := cmp.Diff(want, mismatchErr.Checksums); diff != "" {
+			t.Fatalf("mismatched checksums -want +got:\n%s", diff)
+		}
+	}
+
+	remotes := map[string]func(dir string) Remote{
+		"one object at a time": func(dir string) Remote {
+			return fileRemote{dir: dir}
+		},
+		"batch": func(dir string) Remote {
+			return rcloneRemote{remote: dir}
+		},
+	}
+	for name, newRemote := range remotes {
+		t.Run(name, func(t *testing.T) {
+			t.Run("corrupt objects are quarantined", func(t *testing.T) {
+				art, remoteDir, man := setupFetchTest(t)
+				badChecksums := []string{man.Contents["1.txt"].Checksum, man.Contents["2.txt"].Checksum}
+				for _, checksum := range badChecksums {
+					corrupt(t, remoteDir, checksum)
+				}
+
+				ch, err := NewLocalCache(t.TempDir())
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = ch.Fetch(newRemote(remoteDir), map[string]*artifact.Artifact{art.Path: &art})
+				assertMismatched(t, err, badChecksums...)
+
+				found := objectChecksums(t, ch)
+				for _, checksum := range badChecksums {
+					if found[checksum] {
+						t.Fatalf("corrupt object %s added to the cache", checksum)
+					}
+					path, err := pathForChecksum(checksum)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if _, err := os.Stat(filepath.Join(ch.dir, quarantineDir, path)); err != nil {
+						t.Fatalf("expected corrupt object in quarantine: %v", err)
+					}
+				}
+				// The other files, and the manifests, were fetched.
+				if !found[man.Contents["3.txt"].Checksum] || !found[man.Contents["bar"].Checksum] {
+					t.Fatalf("expected healthy objects to be fetched, got %v", found)
+				}
+				entries, err := os.ReadDir(filepath.Join(ch.dir, stagingDir))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(entries) != 0 {
+					t.Fatalf("expected empty staging directory, got %v", entries)
+				}
+			})
+
+			t.Run("children of corrupt manifests aren't fetched", func(t *testing.T) {
+				art, remoteDir, _ := setupFetchTest(t)
+				corrupt(t, remoteDir, art.Checksum)
+
+				ch, err := NewLocalCache(t.TempDir())
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = ch.Fetch(newRemote(remoteDir), map[string]*artifact.Artifact{art.Path: &art})
+				assertMismatched(t, err, art.Checksum)
+				if found := objectChecksums(t, ch); len(found) != 0 {
+					t.Fatalf("expected no objects fetched, got %v", found)
+				}
+			})
+		})
+	}
+}
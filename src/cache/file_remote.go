@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// A fileRemote is a Remote in a directory on a local or network filesystem.
+// Objects are laid out exactly as they are in the local cache.
+type fileRemote struct {
+	dir string
+}
+
+func newFileRemote(dir string) (fileRemote, error) {
+	if dir == "" {
+		return fileRemote{}, errors.New("file remote path must be set")
+	}
+	return fileRemote{dir: filepath.Clean(dir)}, nil
+}
+
+func (remote fileRemote) objectPath(checksum string) (string, error) {
+	relPath, err := pathForChecksum(checksum)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(remote.dir, relPath), nil
+}
+
+func (remote fileRemote) Stat(checksum string) (bool, error) {
+	path, err := remote.objectPath(checksum)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (remote fileRemote) Get(checksum string) (io.ReadCloser, error) {
+	path, err := remote.objectPath(checksum)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, MissingFromRemoteError{checksum}
+	}
+	return file, err
+}
+
+func (remote fileRemote) Put(checksum string, reader io.Reader, size int64) error {
+	path, err := remote.objectPath(checksum)
+	if err != nil {
+		return err
+	}
+	return writeObject(path, remote.dir, reader, cacheFilePerms)
+}
+
+func (remote fileRemote) List(listFn func(checksum string) error) error {
+	err := walkObjectDir(remote.dir, func(checksum, _ string, _ fs.FileInfo) error {
+		return listFn(checksum)
+	})
+	// An empty remote may not have been created yet.
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
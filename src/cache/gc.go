@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/pkg/errors"
+)
+
+// GarbageCollectResult describes the unreachable objects found by
+// GarbageCollect.
+type GarbageCollectResult struct {
+	// Checksums holds the checksum of every unreachable object, sorted.
+	Checksums []string
+	// Bytes is the total size of all unreachable objects, plus any
+	// reassembled copies of unreachable chunked files.
+	Bytes int64
+}
+
+// GarbageCollect removes all objects from the cache that are not reachable
+// from the given Artifacts. An object is reachable if an Artifact references
+// it directly, or if the directory manifest of a reachable directory Artifact
+// references it. If dryRun is true, GarbageCollect only reports the
+// unreachable objects and leaves the cache untouched.
+//
+// Objects stored after GarbageCollect starts, e.g. by a concurrent commit in
+// another project sharing the cache, are left alone. Note that objects
+// committed by other projects sharing the cache are only reachable if their
+// Artifacts are given.
+func (ch LocalCache) GarbageCollect(
+	arts []*artifact.Artifact,
+	dryRun bool,
+) (result GarbageCollectResult, err error) {
+	start := time.Now()
+	reachable := make(map[string]struct{})
+	for _, art := range arts {
+		if err = gatherReachable(ch, *art, reachable); err != nil {
+			return result, errors.Wrapf(err, "gc %s", art.Path)
+		}
+	}
+	err = ch.walkObjects(func(checksum, cachePath string, info os.FileInfo) error {
+		if _, ok := reachable[checksum]; ok {
+			return nil
+		}
+		if !dryRun {
+			removed, err := ch.removeObject(checksum, cachePath, start)
+			if err != nil || !removed {
+				return err
+			}
+		}
+		result.Checksums = append(result.Checksums, checksum)
+		result.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return result, errors.Wrap(err, "gc")
+	}
+	sort.Strings(result.Checksums)
+
+	// Reassembled chunked files aren't objects, so they're removed along
+	// with their chunk manifests, but not listed in the result.
+	reconstructionPath := filepath.Join(ch.dir, reconstructionDir)
+	err = walkObjectDir(reconstructionPath, func(checksum, path string, info os.FileInfo) error {
+		if _, ok := reachable[checksum]; ok {
+			return nil
+		}
+		if !dryRun {
+			removed, err := ch.removeObject(checksum, filepath.Join(reconstructionDir, path), start)
+			if err != nil || !removed {
+				return err
+			}
+		}
+		result.Bytes += info.Size()
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return result, errors.Wrap(err, "gc")
+}
+
+// gatherReachable adds the checksum of art to reachable. If art is
+// a directory Artifact, gatherReachable recurses into its directory manifest.
+// If art is a chunked file, its chunks are reachable as well.
+// Directory manifests missing from the cache are not an error; none of their
+// children can be reached from this cache anyway.
+func gatherReachable(ch LocalCache, art artifact.Artifact, reachable map[string]struct{}) error {
+	if art.SkipCache || art.Checksum == "" {
+		return nil
+	}
+	if _, err := ch.PathForChecksum(art.Checksum); err != nil {
+		return err
+	}
+	_, seen := reachable[art.Checksum]
+	reachable[art.Checksum] = struct{}{}
+	// Directory trees are often shared between Artifacts (e.g. the same
+	// output at different source control revisions), so avoid walking the
+	// same manifest twice.
+	if !(art.IsDir || art.IsChunked) || seen {
+		return nil
+	}
+	cachePath, _, err := ch.findObject(art.Checksum)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if art.IsChunked {
+		man, err := readChunkManifest(filepath.Join(ch.dir, cachePath))
+		if err != nil {
+			return err
+		}
+		for _, chunk := range man.Chunks {
+			reachable[chunk.Checksum] = struct{}{}
+		}
+		return nil
+	}
+	man, err := readDirManifest(filepath.Join(ch.dir, cachePath))
+	if err != nil {
+		return err
+	}
+	for _, childArt := range man.Contents {
+		if err := gatherReachable(ch, *childArt, reachable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
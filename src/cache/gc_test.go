@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestGarbageCollectIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	// setupGCTest commits a directory Artifact and a file Artifact that
+	// shares no contents with the directory.
+	setupGCTest := func(t *testing.T) (string, string, LocalCache, artifact.Artifact, artifact.Artifact) {
+		dirs, dirArt, ch := setupDirTest(t)
+		fileArt := artifact.Artifact{Path: "orphan.txt"}
+		if err := os.WriteFile(
+			filepath.Join(dirs.WorkDir, fileArt.Path),
+			[]byte("not in the directory"),
+			0o644,
+		); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Commit(dirs.WorkDir, &dirArt, strategy.LinkStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Commit(dirs.WorkDir, &fileArt, strategy.LinkStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		return dirs.CacheDir, dirs.WorkDir, ch, dirArt, fileArt
+	}
+
+	t.Run("removes unreachable objects", func(t *testing.T) {
+		cacheDir, workDir, ch, dirArt, fileArt := setupGCTest(t)
+		defer os.RemoveAll(cacheDir)
+		defer os.RemoveAll(workDir)
+
+		result, err := ch.GarbageCollect([]*artifact.Artifact{&dirArt}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Checksums) != 1 || result.Checksums[0] != fileArt.Checksum {
+			t.Fatalf("expected only %s to be collected, got %v", fileArt.Checksum, result.Checksums)
+		}
+		if result.Bytes != int64(len("not in the directory")) {
+			t.Fatalf("expected %d bytes collected, got %d", len("not in the directory"), result.Bytes)
+		}
+
+		fileStatus, err := ch.Status(workDir, fileArt, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fileStatus.ChecksumInCache {
+			t.Fatal("expected file artifact to be removed from the cache")
+		}
+
+		// All 10 objects (8 unique files and 2 manifests) should remain.
+		objects, err := getCacheFiles(cacheDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objects) != 10 {
+			t.Fatalf("expected 10 objects left in the cache, got %d", len(objects))
+		}
+		dirStatus, err := ch.Status(workDir, dirArt, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !dirStatus.ContentsMatch {
+			t.Fatalf("expected directory artifact to be up-to-date, got %s", dirStatus)
+		}
+	})
+
+	t.Run("removes access records of removed objects", func(t *testing.T) {
+		cacheDir, workDir, ch, dirArt, fileArt := setupGCTest(t)
+		defer os.RemoveAll(cacheDir)
+		defer os.RemoveAll(workDir)
+
+		recordPath := func(art artifact.Artifact) string {
+			path, err := pathForChecksum(art.Checksum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return filepath.Join(cacheDir, accessDir, path)
+		}
+		for _, art := range []artifact.Artifact{dirArt, fileArt} {
+			if _, err := ch.Status(workDir, art, false); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(recordPath(art)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := ch.GarbageCollect([]*artifact.Artifact{&dirArt}, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(recordPath(fileArt)); !os.IsNotExist(err) {
+			t.Fatalf("expected access record of removed object to be removed, got %v", err)
+		}
+		if _, err := os.Stat(recordPath(dirArt)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("dry run removes nothing", func(t *testing.T) {
+		cacheDir, workDir, ch, _, fileArt := setupGCTest(t)
+		defer os.RemoveAll(cacheDir)
+		defer os.RemoveAll(workDir)
+
+		result, err := ch.GarbageCollect([]*artifact.Artifact{}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Checksums) != 11 {
+			t.Fatalf("expected 11 unreachable objects, got %d", len(result.Checksums))
+		}
+
+		objects, err := getCacheFiles(cacheDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objects) != 11 {
+			t.Fatalf("expected 11 objects left in the cache, got %d", len(objects))
+		}
+		fileStatus, err := ch.Status(workDir, fileArt, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fileStatus.ContentsMatch {
+			t.Fatalf("expected file artifact to be up-to-date, got %s", fileStatus)
+		}
+	})
+
+	t.Run("missing directory manifests are ignored", func(t *testing.T) {
+		cacheDir, workDir, ch, dirArt, _ := setupGCTest(t)
+		defer os.RemoveAll(cacheDir)
+		defer os.RemoveAll(workDir)
+
+		ghostArt := artifact.Artifact{
+			Path:     "ghost",
+			IsDir:    true,
+			Checksum: "0000000000000000000000000000000000000000000000000000000000000000",
+		}
+
+		result, err := ch.GarbageCollect([]*artifact.Artifact{&dirArt, &ghostArt}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Checksums) != 1 {
+			t.Fatalf("expected 1 unreachable object, got %d", len(result.Checksums))
+		}
+	})
+
+	t.Run("ignores non-object files", func(t *testing.T) {
+		cacheDir, workDir, ch, dirArt, _ := setupGCTest(t)
+		defer os.RemoveAll(cacheDir)
+		defer os.RemoveAll(workDir)
+
+		strayFile := filepath.Join(cacheDir, "not_an_object")
+		if err := os.WriteFile(strayFile, []byte("foo"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := ch.GarbageCollect([]*artifact.Artifact{&dirArt}, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(strayFile); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
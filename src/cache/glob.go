@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"path/filepath"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/glob"
+)
+
+// globBase returns the directory Artifact at the base directory of the glob
+// Artifact art (see glob.Split), and the pattern its files must match,
+// relative to the base directory. The directory Artifact shares art's
+// Checksum. If art isn't a glob, it's returned as is with a nil pattern.
+func globBase(art artifact.Artifact) (artifact.Artifact, *glob.Pattern, error) {
+	if !art.IsGlob() {
+		return art, nil, nil
+	}
+	base, pattern, err := glob.Split(filepath.ToSlash(art.Path))
+	if err != nil {
+		return art, nil, err
+	}
+	art.Path = filepath.FromSlash(base)
+	art.IsDir = true
+	return art, &pattern, nil
+}
+
+// globBaseArtifacts returns artifacts with any glob Artifacts replaced by
+// their base directory Artifacts (see globBase), so their children have
+// paths in the workspace. Invalid globs are left as they are.
+func globBaseArtifacts(artifacts map[string]*artifact.Artifact) map[string]*artifact.Artifact {
+	out := make(map[string]*artifact.Artifact, len(artifacts))
+	for key, art := range artifacts {
+		if baseArt, pattern, err := globBase(*art); err == nil && pattern != nil {
+			art = &baseArt
+		}
+		out[key] = art
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestGlobIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	writeFiles := func(t *testing.T, dir string, files ...string) {
+		for _, file := range files {
+			path := filepath.Join(dir, file)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	setupGlobTest := func(t *testing.T) (string, LocalCache, artifact.Artifact) {
+		workDir := t.TempDir()
+		ch, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFiles(t, workDir, "reports/a.html", "reports/b.html", "reports/style.css", "reports/old/c.html")
+		art := artifact.Artifact{Path: filepath.Join("reports", "*.html"), IsDir: true}
+		if err := ch.Commit(workDir, &art, strategy.LinkStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		return workDir, ch, art
+	}
+
+	assertStatus := func(t *testing.T, ch LocalCache, workDir string, art artifact.Artifact, want bool) {
+		status, err := ch.Status(workDir, art, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.ContentsMatch != want {
+			t.Fatalf("ContentsMatch = %v, want %v (status %s)", status.ContentsMatch, want, status)
+		}
+		if status.Path != art.Path {
+			t.Fatalf("status path = %#v, want %#v", status.Path, art.Path)
+		}
+	}
+
+	t.Run("commit only tracks matches", func(t *testing.T) {
+		_, ch, art := setupGlobTest(t)
+		man, err := readDirManifest(objectPath(t, ch, art.Checksum))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for path := range man.Contents {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		if diff := cmp.Diff([]string{"a.html", "b.html"}, paths); diff != "" {
+			t.Fatalf("manifest -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("status only summarizes matches", func(t *testing.T) {
+		workDir, ch, art := setupGlobTest(t)
+		status, err := ch.Status(workDir, art, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := status.String(), "2x up-to-date (link)"; got != want {
+			t.Fatalf("status = %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("status detects added and removed matches", func(t *testing.T) {
+		workDir, ch, art := setupGlobTest(t)
+		assertStatus(t, ch, workDir, art, true)
+
+		// Files that don't match don't matter.
+		writeFiles(t, workDir, "reports/other.css", "reports/old/d.html")
+		assertStatus(t, ch, workDir, art, true)
+
+		writeFiles(t, workDir, "reports/new.html")
+		assertStatus(t, ch, workDir, art, false)
+		if err := os.Remove(filepath.Join(workDir, "reports", "new.html")); err != nil {
+			t.Fatal(err)
+		}
+		assertStatus(t, ch, workDir, art, true)
+
+		if err := os.Remove(filepath.Join(workDir, "reports", "a.html")); err != nil {
+			t.Fatal(err)
+		}
+		assertStatus(t, ch, workDir, art, false)
+	})
+
+	t.Run("checkout restores matches", func(t *testing.T) {
+		workDir, ch, art := setupGlobTest(t)
+		for _, name := range []string{"a.html", "b.html"} {
+			if err := os.Remove(filepath.Join(workDir, "reports", name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ch.Checkout(workDir, art, strategy.LinkStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		assertStatus(t, ch, workDir, art, true)
+	})
+}
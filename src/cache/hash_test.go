@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/checksum"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestHashIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	sha256, err := checksum.Lookup("sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// setupHashTest commits the test directory, plus a file committed before
+	// the cache switched to sha256.
+	setupHashTest := func(t *testing.T) (string, LocalCache, artifact.Artifact, artifact.Artifact) {
+		dirs, dirArt, blake3Cache := setupDirTest(t)
+		t.Cleanup(func() {
+			os.RemoveAll(dirs.CacheDir)
+			os.RemoveAll(dirs.WorkDir)
+		})
+		fileArt := artifact.Artifact{Path: "old.txt"}
+		if err := os.WriteFile(filepath.Join(dirs.WorkDir, fileArt.Path), []byte("old"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := blake3Cache.Commit(dirs.WorkDir, &fileArt, strategy.LinkStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		ch, err := NewLocalCache(dirs.CacheDir, WithHash(sha256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Commit(dirs.WorkDir, &dirArt, strategy.LinkStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		return dirs.WorkDir, ch, dirArt, fileArt
+	}
+
+	t.Run("new objects use the cache's algorithm", func(t *testing.T) {
+		workDir, ch, dirArt, fileArt := setupHashTest(t)
+
+		if !strings.HasPrefix(dirArt.Checksum, "sha256:") {
+			t.Fatalf("expected sha256 checksum, got %s", dirArt.Checksum)
+		}
+		if strings.Contains(fileArt.Checksum, ":") {
+			t.Fatalf("expected blake3 checksum, got %s", fileArt.Checksum)
+		}
+		if _, err := os.Stat(objectPath(t, ch, dirArt.Checksum)); err != nil {
+			t.Fatal(err)
+		}
+
+		// Committing the unchanged file again keeps its checksum.
+		oldChecksum := fileArt.Checksum
+		if err := ch.Commit(workDir, &fileArt, strategy.LinkStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		if fileArt.Checksum != oldChecksum {
+			t.Fatalf("expected checksum %s to be kept, got %s", oldChecksum, fileArt.Checksum)
+		}
+	})
+
+	t.Run("objects of all algorithms are walked", func(t *testing.T) {
+		_, ch, dirArt, fileArt := setupHashTest(t)
+
+		found := objectChecksums(t, ch)
+		// 8 unique files, 2 directory manifests, and the old file.
+		if len(found) != 11 {
+			t.Fatalf("expected 11 objects, got %d: %v", len(found), found)
+		}
+		if !found[dirArt.Checksum] || !found[fileArt.Checksum] {
+			t.Fatalf("expected %s and %s in %v", dirArt.Checksum, fileArt.Checksum, found)
+		}
+
+		results, err := ch.Verify([]*artifact.Artifact{&dirArt, &fileArt}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 0 {
+			t.Fatalf("expected no problems, got %v", results)
+		}
+		result, err := ch.GarbageCollect([]*artifact.Artifact{&dirArt, &fileArt}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Checksums) != 0 {
+			t.Fatalf("expected no unreachable objects, got %v", result.Checksums)
+		}
+	})
+
+	t.Run("checkout verifies with the checksum's algorithm", func(t *testing.T) {
+		workDir, ch, dirArt, fileArt := setupHashTest(t)
+		for _, art := range []artifact.Artifact{dirArt, fileArt} {
+			if err := os.RemoveAll(filepath.Join(workDir, art.Path)); err != nil {
+				t.Fatal(err)
+			}
+			if err := ch.Checkout(workDir, art, strategy.CopyStrategy, nil); err != nil {
+				t.Fatal(err)
+			}
+			status, err := ch.Status(workDir, art, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !status.ContentsMatch {
+				t.Fatalf("expected %s to be up-to-date, got %s", art.Path, status)
+			}
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HTTPTokenEnvVar is the environment variable holding the bearer token used to
+// push to an HTTP remote. 'dud serve' reads the same variable to authenticate
+// pushes.
+const HTTPTokenEnvVar = "DUD_HTTP_TOKEN"
+
+// An httpRemote is a Remote served over HTTP(S), typically by 'dud serve'
+// (see Server). Objects are addressed with the same layout as the local cache,
+// so any static file server in front of a cache directory can be used as
+// a read-only httpRemote.
+type httpRemote struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func newHTTPRemote(baseURL string) httpRemote {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Keep a connection open for every transfer worker.
+	transport.MaxIdleConnsPerHost = maxTransferWorkers
+	return httpRemote{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   os.Getenv(HTTPTokenEnvVar),
+		client:  &http.Client{Transport: transport},
+	}
+}
+
+func (remote httpRemote) objectURL(checksum string) (string, error) {
+	relPath, err := pathForChecksum(checksum)
+	if err != nil {
+		return "", err
+	}
+	return remote.baseURL + "/" + filepath.ToSlash(relPath), nil
+}
+
+// httpStatusError is an error case where the server responded with an
+// unexpected status code.
+type httpStatusError struct {
+	method, url, status, message string
+}
+
+func (err httpStatusError) Error() string {
+	msg := fmt.Sprintf("%s %s: %s", err.method, err.url, err.status)
+	if err.message != "" {
+		msg += ": " + err.message
+	}
+	return msg
+}
+
+// newHTTPStatusError creates an httpStatusError from resp, using the start of
+// the response body as the error message. The caller must close resp.Body.
+func newHTTPStatusError(resp *http.Response) httpStatusError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return httpStatusError{
+		method:  resp.Request.Method,
+		url:     resp.Request.URL.String(),
+		status:  resp.Status,
+		message: strings.TrimSpace(string(body)),
+	}
+}
+
+func (remote httpRemote) Stat(checksum string) (bool, error) {
+	url, err := remote.objectURL(checksum)
+	if err != nil {
+		return false, err
+	}
+	resp, err := remote.client.Head(url)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, newHTTPStatusError(resp)
+}
+
+func (remote httpRemote) Get(checksum string) (io.ReadCloser, error) {
+	url, err := remote.objectURL(checksum)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := remote.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, MissingFromRemoteError{checksum}
+	}
+	defer resp.Body.Close()
+	return nil, newHTTPStatusError(resp)
+}
+
+func (remote httpRemote) Put(checksum string, reader io.Reader, size int64) error {
+	url, err := remote.objectURL(checksum)
+	if err != nil {
+		return err
+	}
+	body := io.NopCloser(reader)
+	if size == 0 {
+		body = http.NoBody
+	}
+	req, err := http.NewRequest(http.MethodPut, url, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if remote.token != "" {
+		req.Header.Set("Authorization", "Bearer "+remote.token)
+	}
+	resp, err := remote.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+		return nil
+	}
+	return newHTTPStatusError(resp)
+}
+
+func (remote httpRemote) List(listFn func(checksum string) error) error {
+	resp, err := remote.client.Get(remote.baseURL + "/")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newHTTPStatusError(resp)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if checksum := strings.TrimSpace(scanner.Text()); checksum != "" {
+			if err := listFn(checksum); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
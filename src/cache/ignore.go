@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/glob"
+	"github.com/pkg/errors"
+)
+
+// ignoreFileName is the name of the file at the root of a directory Artifact
+// that lists patterns to exclude from the Artifact, in addition to its
+// Exclude field.
+const ignoreFileName = ".dudignore"
+
+// loadIgnore sets ch.ignore to the exclude patterns of the directory Artifact
+// art, which is at ch.artifactDir in the workspace. Patterns in the Artifact's
+// Exclude field take precedence over those in its ignore file.
+func (ch *LocalCache) loadIgnore(art artifact.Artifact) error {
+	ch.ignore = glob.IgnoreList{}
+	if !art.IsDir {
+		return nil
+	}
+	ignorePath := filepath.Join(ch.artifactDir, ignoreFileName)
+	lines, err := readLines(ignorePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines = append(lines, art.Exclude...)
+	ch.ignore, err = glob.CompileIgnore(lines)
+	return errors.Wrap(err, "exclude")
+}
+
+// readLines returns the lines of the file at path.
+func readLines(path string) (lines []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, errors.Wrap(scanner.Err(), path)
+}
+
+// isIgnored returns true if the file or directory at workPath is excluded
+// from the directory Artifact at ch.artifactDir, either by its exclude
+// patterns or by not matching the pattern of a glob Artifact. Directories
+// match a glob if they could contain matches.
+func (ch LocalCache) isIgnored(workPath string, isDir bool) bool {
+	if ch.ignore.Empty() && ch.include == nil {
+		return false
+	}
+	relPath, err := filepath.Rel(ch.artifactDir, workPath)
+	if err != nil {
+		return false
+	}
+	relPath = filepath.ToSlash(relPath)
+	if ch.include != nil &&
+		!(ch.include.Match(relPath) || isDir && ch.include.MatchPrefix(relPath)) {
+		return true
+	}
+	return ch.ignore.Ignored(relPath, isDir)
+}
+
+// filterIgnored removes the entries of the directory at dirPath that are
+// excluded from the directory Artifact at ch.artifactDir.
+func (ch LocalCache) filterIgnored(dirPath string, entries []os.DirEntry) []os.DirEntry {
+	if ch.ignore.Empty() && ch.include == nil {
+		return entries
+	}
+	out := entries[:0]
+	for _, entry := range entries {
+		if !ch.isIgnored(filepath.Join(dirPath, entry.Name()), entry.IsDir()) {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
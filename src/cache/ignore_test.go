@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestExcludeIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	writeFiles := func(t *testing.T, dir string, files ...string) {
+		for _, file := range files {
+			path := filepath.Join(dir, file)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// setupExcludeTest creates a directory with data files and junk.
+	setupExcludeTest := func(t *testing.T) (string, LocalCache, artifact.Artifact) {
+		workDir := t.TempDir()
+		ch, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFiles(
+			t,
+			filepath.Join(workDir, "runs"),
+			"1/events",
+			"1/events.tmp",
+			"1/__pycache__/mod.pyc",
+			".DS_Store",
+		)
+		art := artifact.Artifact{
+			Path:    "runs",
+			IsDir:   true,
+			Exclude: []string{"*.tmp", "__pycache__/"},
+		}
+		return workDir, ch, art
+	}
+
+	manifestPaths := func(t *testing.T, ch LocalCache, checksum string) (paths []string) {
+		man, err := readDirManifest(objectPath(t, ch, checksum))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for path := range man.Contents {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		return
+	}
+
+	assertUpToDate := func(t *testing.T, ch LocalCache, workDir string, art artifact.Artifact) {
+		status, err := ch.Status(workDir, art, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !status.ContentsMatch {
+			t.Fatalf("expected up-to-date status, got %s", status)
+		}
+	}
+
+	t.Run("commit, status, and checkout skip excluded files", func(t *testing.T) {
+		workDir, ch, art := setupExcludeTest(t)
+		if err := os.WriteFile(
+			filepath.Join(workDir, "runs", ignoreFileName),
+			[]byte("# junk\n.DS_Store\n"),
+			0o644,
+		); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Commit(workDir, &art, strategy.LinkStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{ignoreFileName, "1"}, manifestPaths(t, ch, art.Checksum)); diff != "" {
+			t.Fatalf("manifest -want +got:\n%s", diff)
+		}
+		assertUpToDate(t, ch, workDir, art)
+
+		// More junk doesn't make the directory out-of-date.
+		writeFiles(t, filepath.Join(workDir, "runs"), "1/more.tmp", "1/__pycache__/other.pyc")
+		assertUpToDate(t, ch, workDir, art)
+
+		if err := os.RemoveAll(filepath.Join(workDir, "runs", "1")); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Checkout(workDir, art, strategy.LinkStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(workDir, "runs", "1", "events")); err != nil {
+			t.Fatalf("expected runs/1/events to be checked out: %v", err)
+		}
+		assertUpToDate(t, ch, workDir, art)
+	})
+
+	t.Run("changing patterns changes the directory", func(t *testing.T) {
+		workDir, ch, art := setupExcludeTest(t)
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{".DS_Store", "1"}
+		if diff := cmp.Diff(want, manifestPaths(t, ch, art.Checksum)); diff != "" {
+			t.Fatalf("manifest -want +got:\n%s", diff)
+		}
+
+		art.Exclude = nil
+		status, err := ch.Status(workDir, art, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.ContentsMatch {
+			t.Fatal("expected excluded files to be untracked without patterns")
+		}
+	})
+
+	t.Run("invalid patterns are errors", func(t *testing.T) {
+		workDir, ch, art := setupExcludeTest(t)
+		art.Exclude = []string{"../outside"}
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
@@ -0,0 +1,26 @@
+package cache
+
+import (
+	"io/fs"
+	"runtime"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+)
+
+// trackExecutable is false on platforms without executable bits, where
+// Commit leaves Artifacts' Executable fields as they are and Status ignores
+// them.
+var trackExecutable = runtime.GOOS != "windows"
+
+func isExecutable(info fs.FileInfo) bool {
+	return info.Mode().Perm()&0o111 != 0
+}
+
+// workspaceFilePerms returns the permissions of a file copied into the
+// workspace for art.
+func workspaceFilePerms(art artifact.Artifact) fs.FileMode {
+	if art.Executable {
+		return 0o755
+	}
+	return 0o644
+}
@@ -0,0 +1,215 @@
+//go:build !windows
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestExecutableIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	// setupModeTest commits an executable script with the given strategy.
+	setupModeTest := func(t *testing.T, strat strategy.CheckoutStrategy) (string, LocalCache, artifact.Artifact) {
+		workDir := t.TempDir()
+		ch, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		art := artifact.Artifact{Path: "run.sh"}
+		if err := os.WriteFile(filepath.Join(workDir, art.Path), []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Commit(workDir, &art, strat, logger); err != nil {
+			t.Fatal(err)
+		}
+		return workDir, ch, art
+	}
+
+	assertExecutable := func(t *testing.T, path string, want bool) {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isExecutable(info); got != want {
+			t.Fatalf("%s: executable = %v, want %v (mode %s)", path, got, want, info.Mode())
+		}
+	}
+
+	assertStatus := func(t *testing.T, ch LocalCache, workDir string, art artifact.Artifact, want string) {
+		status, err := ch.Status(workDir, art, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := status.String(); got != want {
+			t.Fatalf("status = %#v, want %#v", got, want)
+		}
+	}
+
+	t.Run("commit records the executable bit", func(t *testing.T) {
+		workDir, ch, art := setupModeTest(t, strategy.CopyStrategy)
+		if !art.Executable {
+			t.Fatal("expected Executable to be true")
+		}
+		assertStatus(t, ch, workDir, art, "up-to-date")
+
+		// Objects are shared by every file with the same contents, so they
+		// aren't made executable.
+		assertExecutable(t, objectPath(t, ch, art.Checksum), false)
+	})
+
+	strategies := []strategy.CheckoutStrategy{
+		strategy.CopyStrategy,
+		strategy.ReflinkStrategy,
+		strategy.HardlinkStrategy,
+		strategy.LinkStrategy,
+	}
+	for _, strat := range strategies {
+		t.Run("checkout restores the executable bit with "+strat.String(), func(t *testing.T) {
+			workDir, ch, art := setupModeTest(t, strategy.CopyStrategy)
+			workPath := filepath.Join(workDir, art.Path)
+			if err := os.Remove(workPath); err != nil {
+				t.Fatal(err)
+			}
+			if err := ch.Checkout(workDir, art, strat, nil); err != nil {
+				t.Fatal(err)
+			}
+			assertExecutable(t, workPath, true)
+			status, err := ch.Status(workDir, art, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !status.ContentsMatch {
+				t.Fatalf("expected up-to-date status, got %s", status)
+			}
+		})
+	}
+
+	t.Run("status reports mode-only changes", func(t *testing.T) {
+		workDir, ch, art := setupModeTest(t, strategy.CopyStrategy)
+		workPath := filepath.Join(workDir, art.Path)
+		if err := os.Chmod(workPath, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		assertStatus(t, ch, workDir, art, "mode changed")
+
+		oldChecksum := art.Checksum
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		if art.Executable || art.Checksum != oldChecksum {
+			t.Fatalf("expected only Executable to change, got %+v", art)
+		}
+		assertStatus(t, ch, workDir, art, "up-to-date")
+	})
+
+	for _, strat := range []strategy.CheckoutStrategy{strategy.HardlinkStrategy, strategy.LinkStrategy} {
+		t.Run("executable files are copied instead of linked with "+strat.String(), func(t *testing.T) {
+			workDir, ch, art := setupModeTest(t, strategy.CopyStrategy)
+			workPath := filepath.Join(workDir, art.Path)
+			if err := os.Remove(workPath); err != nil {
+				t.Fatal(err)
+			}
+			if err := ch.Checkout(workDir, art, strat, nil); err != nil {
+				t.Fatal(err)
+			}
+			workInfo, err := os.Lstat(workPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			objInfo, err := os.Stat(objectPath(t, ch, art.Checksum))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !workInfo.Mode().IsRegular() || os.SameFile(workInfo, objInfo) {
+				t.Fatalf("expected a copy of the object, got mode %s", workInfo.Mode())
+			}
+			assertExecutable(t, workPath, true)
+			assertExecutable(t, objectPath(t, ch, art.Checksum), false)
+			assertStatus(t, ch, workDir, art, "up-to-date")
+		})
+	}
+
+	t.Run("status reports links with the wrong mode", func(t *testing.T) {
+		workDir, ch, art := setupModeTest(t, strategy.CopyStrategy)
+		workPath := filepath.Join(workDir, art.Path)
+		if err := os.Chmod(workPath, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Commit(workDir, &art, strategy.LinkStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		assertStatus(t, ch, workDir, art, "up-to-date (link)")
+
+		// Another file with the same contents is executable.
+		execArt := art
+		execArt.Executable = true
+		assertStatus(t, ch, workDir, execArt, "mode changed (link)")
+		if err := ch.Checkout(workDir, execArt, strategy.LinkStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		assertExecutable(t, workPath, true)
+		assertStatus(t, ch, workDir, execArt, "up-to-date")
+
+		// Links to executable objects don't match non-executable files
+		// either.
+		if err := os.Remove(workPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Checkout(workDir, art, strategy.LinkStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(objectPath(t, ch, art.Checksum), 0o555); err != nil {
+			t.Fatal(err)
+		}
+		assertStatus(t, ch, workDir, art, "mode changed (link)")
+	})
+
+	t.Run("directory manifests record the executable bit", func(t *testing.T) {
+		workDir := t.TempDir()
+		ch, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirPath := filepath.Join(workDir, "bin")
+		if err := os.Mkdir(dirPath, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dirPath, "tool"), []byte("tool"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dirPath, "README"), []byte("docs"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		art := artifact.Artifact{Path: "bin", IsDir: true}
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		man, err := readDirManifest(objectPath(t, ch, art.Checksum))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !man.Contents["tool"].Executable || man.Contents["README"].Executable {
+			t.Fatalf("unexpected manifest contents: %+v, %+v", man.Contents["tool"], man.Contents["README"])
+		}
+
+		if err := os.RemoveAll(dirPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Checkout(workDir, art, strategy.CopyStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		assertExecutable(t, filepath.Join(dirPath, "tool"), true)
+		assertExecutable(t, filepath.Join(dirPath, "README"), false)
+	})
+}
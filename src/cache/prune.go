@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/pkg/errors"
+)
+
+// accessDir is the directory, relative to the cache root, recording when
+// committed Artifacts were last checked out or had their status checked.
+// Files in this directory are laid out like objects, and each holds the
+// Artifact with the given checksum (see recordAccess). A file's modification
+// time is the Artifact's last access time; filesystem access times are too
+// often disabled or coarse to be useful.
+const accessDir = "access"
+
+// recordAccess marks art as accessed now. Prune evicts the objects of the
+// least recently accessed Artifacts first.
+func (ch LocalCache) recordAccess(art artifact.Artifact) error {
+	if art.SkipCache || art.Checksum == "" {
+		return nil
+	}
+	cachePath, err := pathForChecksum(art.Checksum)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(ch.dir, accessDir, cachePath)
+	now := time.Now()
+	err = os.Chtimes(path, now, now)
+	// Only a record's owner may set its times, so other users of a shared
+	// cache replace the record instead.
+	if !os.IsNotExist(err) && !os.IsPermission(err) {
+		return err
+	}
+	// Only the fields needed to find the Artifact's objects are recorded.
+	record, err := json.Marshal(artifact.Artifact{
+		Checksum:  art.Checksum,
+		IsDir:     art.IsDir,
+		IsChunked: art.IsChunked,
+	})
+	if err != nil {
+		return err
+	}
+	if err := ch.mkdirAll(filepath.Dir(path)); err != nil {
+		return err
+	}
+	tempFile, err := ch.createStagingFile()
+	if err != nil {
+		return err
+	}
+	// If the file was moved, this fails harmlessly.
+	defer os.Remove(tempFile.Name())
+	_, err = tempFile.Write(record)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tempFile.Name(), 0o644); err != nil {
+		return err
+	}
+	// Status may record accesses concurrently with other processes, so the
+	// record is replaced atomically.
+	return os.Rename(tempFile.Name(), path)
+}
+
+// PruneResult describes the objects evicted by Prune.
+type PruneResult struct {
+	// Checksums holds the checksum of every evicted object, sorted.
+	Checksums []string
+	// Bytes is the total size of all evicted objects, plus any reassembled
+	// copies of chunked files that were removed.
+	Bytes int64
+	// Size is the total size of the cache after pruning.
+	Size int64
+}
+
+// A pruneCandidate is an object, or a reassembled copy of a chunked file,
+// that Prune may evict.
+type pruneCandidate struct {
+	checksum string
+	// path is the location of the file to remove, relative to the cache
+	// directory.
+	path          string
+	size          int64
+	lastAccess    time.Time
+	reconstructed bool
+}
+
+// Prune evicts the least recently used objects from the cache until the total
+// size of the cache is at most maxSize bytes. Only objects that are present on
+// the remote are evicted, so that any of them can be fetched again. Objects
+// reachable from the given Artifacts (see GarbageCollect), which are expected
+// to be the Artifacts checked out in the workspace, are never evicted.
+//
+// An object was last used when it was committed or fetched, or when an
+// Artifact referencing it was last checked out or had its status checked,
+// whichever is latest. Reassembled copies of chunked files are evicted by the
+// same rule, but don't need to be on the remote. As in GarbageCollect, objects
+// stored after Prune starts are left alone. If dryRun is true, Prune only
+// reports the objects it would evict and leaves the cache untouched.
+func (ch LocalCache) Prune(
+	remote Remote,
+	arts []*artifact.Artifact,
+	maxSize int64,
+	dryRun bool,
+) (result PruneResult, err error) {
+	start := time.Now()
+	protected := make(map[string]struct{})
+	for _, art := range arts {
+		if err = gatherReachable(ch, *art, protected); err != nil {
+			return result, errors.Wrapf(err, "prune %s", art.Path)
+		}
+	}
+	lastAccess, err := ch.readAccessTimes(dryRun)
+	if err != nil {
+		return result, errors.Wrap(err, "prune")
+	}
+
+	var candidates []pruneCandidate
+	addCandidate := func(checksum, path string, info os.FileInfo, reconstructed bool) {
+		result.Size += info.Size()
+		if _, ok := protected[checksum]; ok {
+			return
+		}
+		candidate := pruneCandidate{
+			checksum:      checksum,
+			path:          path,
+			size:          info.Size(),
+			lastAccess:    info.ModTime(),
+			reconstructed: reconstructed,
+		}
+		if accessed := lastAccess[checksum]; accessed.After(candidate.lastAccess) {
+			candidate.lastAccess = accessed
+		}
+		candidates = append(candidates, candidate)
+	}
+	err = ch.walkObjects(func(checksum, cachePath string, info os.FileInfo) error {
+		addCandidate(checksum, cachePath, info, false)
+		return nil
+	})
+	if err != nil {
+		return result, errors.Wrap(err, "prune")
+	}
+	err = walkObjectDir(
+		filepath.Join(ch.dir, reconstructionDir),
+		func(checksum, path string, info os.FileInfo) error {
+			addCandidate(checksum, filepath.Join(reconstructionDir, path), info, true)
+			return nil
+		},
+	)
+	if err != nil && !os.IsNotExist(err) {
+		return result, errors.Wrap(err, "prune")
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].lastAccess.Equal(candidates[j].lastAccess) {
+			return candidates[i].path < candidates[j].path
+		}
+		return candidates[i].lastAccess.Before(candidates[j].lastAccess)
+	})
+	for _, candidate := range candidates {
+		if result.Size <= maxSize {
+			break
+		}
+		if !candidate.reconstructed {
+			onRemote, err := remote.Stat(candidate.checksum)
+			if err != nil {
+				return result, errors.Wrap(err, "prune")
+			}
+			if !onRemote {
+				continue
+			}
+		}
+		if !dryRun {
+			removed, err := ch.removeObject(candidate.checksum, candidate.path, start)
+			if err != nil {
+				return result, errors.Wrap(err, "prune")
+			}
+			if !removed {
+				continue
+			}
+		}
+		result.Size -= candidate.size
+		result.Bytes += candidate.size
+		if !candidate.reconstructed {
+			result.Checksums = append(result.Checksums, candidate.checksum)
+		}
+	}
+	sort.Strings(result.Checksums)
+	return result, nil
+}
+
+// readAccessTimes returns the last access time of every object reachable from
+// an Artifact recorded by recordAccess. Records of Artifacts no longer in the
+// cache are removed, unless dryRun is true.
+func (ch LocalCache) readAccessTimes(dryRun bool) (map[string]time.Time, error) {
+	lastAccess := make(map[string]time.Time)
+	recordDir := filepath.Join(ch.dir, accessDir)
+	err := walkObjectDir(recordDir, func(checksum, path string, info os.FileInfo) error {
+		if _, _, err := ch.findObject(checksum); os.IsNotExist(err) {
+			if dryRun {
+				return nil
+			}
+			return os.Remove(filepath.Join(recordDir, path))
+		} else if err != nil {
+			return err
+		}
+		recordBytes, err := os.ReadFile(filepath.Join(recordDir, path))
+		if err != nil {
+			return err
+		}
+		var art artifact.Artifact
+		if err := json.Unmarshal(recordBytes, &art); err != nil {
+			return errors.Wrapf(err, "access record %s", checksum)
+		}
+		reachable := make(map[string]struct{})
+		if err := gatherReachable(ch, art, reachable); err != nil {
+			return err
+		}
+		for reachableChecksum := range reachable {
+			if info.ModTime().After(lastAccess[reachableChecksum]) {
+				lastAccess[reachableChecksum] = info.ModTime()
+			}
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return lastAccess, err
+}
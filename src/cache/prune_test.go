@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestPruneIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	// setObjectTime sets the modification time of an object, which Prune
+	// treats as the time the object was committed.
+	setObjectTime := func(t *testing.T, ch LocalCache, checksum string, modTime time.Time) {
+		if err := os.Chtimes(objectPath(t, ch, checksum), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newRemote := func(t *testing.T) Remote {
+		remote, err := NewRemote("file://" + t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return remote
+	}
+
+	push := func(t *testing.T, ch LocalCache, remote Remote, arts ...*artifact.Artifact) {
+		artMap := make(map[string]*artifact.Artifact)
+		for _, art := range arts {
+			artMap[art.Path] = art
+		}
+		if err := ch.Push(remote, artMap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// setupPruneTest commits five files of 100 bytes each. The files are
+	// committed in order, one day apart.
+	setupPruneTest := func(t *testing.T) (string, LocalCache, map[string]*artifact.Artifact) {
+		workDir := t.TempDir()
+		ch, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		arts := make(map[string]*artifact.Artifact)
+		start := time.Now().Add(-30 * 24 * time.Hour)
+		for i, name := range []string{"a", "b", "c", "d", "e"} {
+			art := &artifact.Artifact{Path: name}
+			contents := []byte(name)
+			for len(contents) < 100 {
+				contents = append(contents, '.')
+			}
+			if err := os.WriteFile(filepath.Join(workDir, name), contents, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := ch.Commit(workDir, art, strategy.CopyStrategy, logger); err != nil {
+				t.Fatal(err)
+			}
+			setObjectTime(t, ch, art.Checksum, start.Add(time.Duration(i)*24*time.Hour))
+			arts[name] = art
+		}
+		return workDir, ch, arts
+	}
+
+	t.Run("evicts least recently used objects on the remote", func(t *testing.T) {
+		workDir, ch, arts := setupPruneTest(t)
+		remote := newRemote(t)
+		push(t, ch, remote, arts["a"], arts["b"], arts["c"], arts["e"])
+
+		// Accessing the oldest object makes it the most recently used.
+		if _, err := ch.Status(workDir, *arts["a"], false); err != nil {
+			t.Fatal(err)
+		}
+
+		// "b" and "d" are the least recently used, but "b" is checked out and
+		// "d" isn't on the remote. Evicting "c" and "e" gets the cache down
+		// to size.
+		result, err := ch.Prune(remote, []*artifact.Artifact{arts["b"]}, 300, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := PruneResult{
+			Bytes: 200,
+			Size:  300,
+		}
+		for _, name := range []string{"c", "e"} {
+			want.Checksums = append(want.Checksums, arts[name].Checksum)
+		}
+		sort.Strings(want.Checksums)
+		if diff := cmp.Diff(want, result); diff != "" {
+			t.Fatalf("Prune() -want +got:\n%s", diff)
+		}
+
+		for name, wantInCache := range map[string]bool{
+			"a": true,
+			"b": true,
+			"c": false,
+			"d": true,
+			"e": false,
+		} {
+			status, err := ch.Status(workDir, *arts[name], false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.ChecksumInCache != wantInCache {
+				t.Fatalf("%s in cache = %v, want %v", name, status.ChecksumInCache, wantInCache)
+			}
+		}
+	})
+
+	t.Run("dry run leaves the cache untouched", func(t *testing.T) {
+		_, ch, arts := setupPruneTest(t)
+		remote := newRemote(t)
+		push(t, ch, remote, arts["a"], arts["b"], arts["c"], arts["d"], arts["e"])
+		before := objectChecksums(t, ch)
+
+		result, err := ch.Prune(remote, nil, 250, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Checksums) != 3 || result.Size != 200 {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+		if diff := cmp.Diff(before, objectChecksums(t, ch)); diff != "" {
+			t.Fatalf("objects -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("does nothing when the cache is small enough", func(t *testing.T) {
+		_, ch, arts := setupPruneTest(t)
+		remote := newRemote(t)
+		push(t, ch, remote, arts["a"], arts["b"], arts["c"], arts["d"], arts["e"])
+
+		result, err := ch.Prune(remote, nil, 500, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(PruneResult{Size: 500}, result); diff != "" {
+			t.Fatalf("Prune() -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("directory accesses apply to all their objects", func(t *testing.T) {
+		dirs, dirArt, ch := setupDirTest(t)
+		defer os.RemoveAll(dirs.CacheDir)
+		defer os.RemoveAll(dirs.WorkDir)
+		fileArt := artifact.Artifact{Path: "orphan.txt"}
+		if err := os.WriteFile(
+			filepath.Join(dirs.WorkDir, fileArt.Path),
+			[]byte("not in the directory"),
+			0o644,
+		); err != nil {
+			t.Fatal(err)
+		}
+		for _, art := range []*artifact.Artifact{&dirArt, &fileArt} {
+			if err := ch.Commit(dirs.WorkDir, art, strategy.CopyStrategy, logger); err != nil {
+				t.Fatal(err)
+			}
+		}
+		remote := newRemote(t)
+		push(t, ch, remote, &dirArt, &fileArt)
+
+		// All objects were committed long ago, but the directory was
+		// recently checked out.
+		longAgo := time.Now().Add(-time.Hour)
+		for checksum := range objectChecksums(t, ch) {
+			setObjectTime(t, ch, checksum, longAgo)
+		}
+		if err := os.RemoveAll(filepath.Join(dirs.WorkDir, dirArt.Path)); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Checkout(dirs.WorkDir, dirArt, strategy.CopyStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		var totalSize int64
+		if err := ch.walkObjects(func(_, _ string, info os.FileInfo) error {
+			totalSize += info.Size()
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Evicting any single object is enough, and the file is the least
+		// recently used.
+		result, err := ch.Prune(remote, nil, totalSize-1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{fileArt.Checksum}, result.Checksums); diff != "" {
+			t.Fatalf("evicted objects -want +got:\n%s", diff)
+		}
+	})
+}
@@ -1,12 +1,7 @@
 package cache
 
 import (
-	"fmt"
-	"io/fs"
-	"os"
-	"os/exec"
 	"path/filepath"
-	"sync"
 
 	"github.com/cheggaaa/pb/v3"
 	"github.com/kevin-hanselman/dud/src/artifact"
@@ -19,27 +14,57 @@ import (
 // calling code. Primarily, a Stage's outputs will be passed to this function,
 // so it's convenient to pass stage.Outputs directly. This also eases testing,
 // because transcribing the map into a slice would introduce non-determinism.
-func (ch LocalCache) Push(remoteDst string, arts map[string]*artifact.Artifact) error {
+func (ch LocalCache) Push(remote Remote, arts map[string]*artifact.Artifact) error {
 	progress := newProgress(progressTemplateCount, 0, "Gathering files")
 	progress.Start()
 	pushFiles := make(map[string]struct{})
-	for _, art := range arts {
+	for _, art := range globBaseArtifacts(arts) {
 		if err := gatherFilesToPush(ch, *art, pushFiles, progress); err != nil {
 			progress.Finish()
 			return errors.Wrapf(err, "push %s", art.Path)
 		}
 	}
 	progress.Finish()
-	if len(pushFiles) > 0 {
-		return errors.Wrap(remoteCopy(ch.dir, remoteDst, pushFiles), "push")
+	if len(pushFiles) == 0 {
+		return nil
 	}
-	return nil
+	if batch, ok := remote.(batchRemote); ok {
+		// Remotes store objects uncompressed, so compressed objects must be
+		// pushed individually.
+		compressed, err := removeCompressed(ch, pushFiles)
+		if err != nil {
+			return errors.Wrap(err, "push")
+		}
+		if len(pushFiles) > 0 {
+			// Batch remotes may stage files (see encryptedBatchRemote).
+			if err := ch.mkdirAll(filepath.Join(ch.dir, stagingDir)); err != nil {
+				return errors.Wrap(err, "push")
+			}
+			if err := batch.pushFiles(ch.dir, toFileSet(pushFiles)); err != nil {
+				return errors.Wrap(err, "push")
+			}
+		}
+		if len(compressed) == 0 {
+			return nil
+		}
+		pushFiles = compressed
+	}
+	return errors.Wrap(
+		transferObjects(pushFiles, "Pushing", func(checksum string) error {
+			return pushObject(ch, remote, checksum)
+		}),
+		"push",
+	)
 }
 
+// gatherFilesToPush adds the checksums of art and all of its children or
+// chunks (if any) to checksums. Files excluded from a sparse checkout are
+// pushed if they're in the cache, and otherwise skipped, as they're usually
+// missing from the cache.
 func gatherFilesToPush(
 	ch LocalCache,
 	art artifact.Artifact,
-	filesToPush map[string]struct{},
+	checksums map[string]struct{},
 	progress *pb.ProgressBar,
 ) error {
 	if art.SkipCache {
@@ -53,6 +78,9 @@ func gatherFilesToPush(
 		return InvalidChecksumError{art.Checksum}
 	}
 	if !status.ChecksumInCache {
+		if ch.isExcluded(art.Path, art.IsDir) {
+			return nil
+		}
 		return MissingFromCacheError{art.Checksum}
 	}
 	if art.IsDir {
@@ -61,118 +89,67 @@ func gatherFilesToPush(
 			return err
 		}
 		for _, childArt := range man.Contents {
-			if err := gatherFilesToPush(ch, *childArt, filesToPush, progress); err != nil {
+			child := *childArt
+			child.Path = filepath.Join(art.Path, child.Path)
+			if err := gatherFilesToPush(ch, child, checksums, progress); err != nil {
+				return err
+			}
+		}
+	} else if art.IsChunked {
+		man, err := readChunkManifest(filepath.Join(ch.dir, cachePath))
+		if err != nil {
+			return err
+		}
+		for _, chunk := range man.Chunks {
+			chunkArt := artifact.Artifact{Checksum: chunk.Checksum, Path: art.Path}
+			if err := gatherFilesToPush(ch, chunkArt, checksums, progress); err != nil {
 				return err
 			}
 		}
 	}
 	progress.Increment()
-	filesToPush[cachePath] = struct{}{}
+	checksums[art.Checksum] = struct{}{}
 	return nil
 }
 
-var remoteCopy = func(src, dst string, fileSet map[string]struct{}) error {
-	cmd := exec.Command(
-		"rclone",
-		"--config",
-		".dud/rclone.conf",
-		// Ideally these sorts of flags could be added to the rclone config,
-		// but I haven't found a way to add them.
-		// See: https://github.com/rclone/rclone/issues/2697
-		"--progress",
-		"--immutable",
-		// If file modification times change locally, without "--size-only",
-		// rclone will error-out because of the "--immutable" flag above.
-		"--size-only",
-		"copy",
-		// "--files-from -" means to get the list of files to copy from STDIN.
-		"--files-from",
-		"-",
-		src,
-		dst,
-	)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return err
+// removeCompressed removes the checksums of compressed objects from
+// checksums and returns them.
+func removeCompressed(ch LocalCache, checksums map[string]struct{}) (map[string]struct{}, error) {
+	compressed := make(map[string]struct{})
+	for checksum := range checksums {
+		cachePath, _, err := ch.findObject(checksum)
+		if err != nil {
+			return nil, err
+		}
+		if isCompressedObject(cachePath) {
+			compressed[checksum] = struct{}{}
+			delete(checksums, checksum)
+		}
 	}
+	return compressed, nil
+}
 
-	if err := cmd.Start(); err != nil {
+// pushObject uploads the object with the given checksum unless it's already
+// on the remote. Objects are immutable, so there's no need to compare
+// contents. Compressed objects are decompressed before uploading.
+func pushObject(ch LocalCache, remote Remote, checksum string) error {
+	exists, err := remote.Stat(checksum)
+	if err != nil || exists {
 		return err
 	}
-
-	go func() {
-		defer stdin.Close()
-		for file := range fileSet {
-			// We can ignore errors here because cmd.Wait() will return an
-			// error on any I/O failures.
-			fmt.Fprintln(stdin, file)
-		}
-	}()
-
-	if err := cmd.Wait(); err != nil {
+	cachePath, info, err := ch.findObject(checksum)
+	if err != nil {
 		return err
 	}
-
-	// Ensure any local files that were created end up as read-only. Try to
-	// chmod all files, ignoring "no such file" errors which are probably due
-	// to the destination being remote. This is important even for push,
-	// because the "remote" might be a local directory.
-	return setFilePerms(dst, fileSet, cacheFilePerms)
-}
-
-func setFilePerms(commonDir string, fileSet map[string]struct{}, mode fs.FileMode) error {
-	numFiles := len(fileSet)
-	progress := newProgress(progressTemplateCount, numFiles, "Fixing permissions")
-	progress.Start()
-	defer progress.Finish()
-
-	// If there's a small number of files don't bother with concurrency.
-	if numFiles < maxSharedWorkers {
-		var chmodErr error = nil
-		for file := range fileSet {
-			err := os.Chmod(filepath.Join(commonDir, file), mode)
-			if err == nil || os.IsNotExist(err) {
-				progress.Increment()
-			} else {
-				chmodErr = err
-			}
-		}
-		return chmodErr
+	reader, err := openObject(filepath.Join(ch.dir, cachePath))
+	if err != nil {
+		return err
 	}
-
-	errs := make(chan error, numFiles)
-	fileChan := make(chan string)
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for file := range fileSet {
-			fileChan <- file
-		}
-		close(fileChan)
-	}()
-	for i := 0; i < maxSharedWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for file := range fileChan {
-				err := os.Chmod(filepath.Join(commonDir, file), mode)
-				// TODO: Consider exiting early on "no such file" errors; this
-				// likely means the remote is truly remote.
-				if err == nil || os.IsNotExist(err) {
-					progress.Increment()
-				} else {
-					errs <- err
-				}
-			}
-		}()
+	defer reader.Close()
+	// The size of a compressed object's contents isn't known in advance.
+	size := info.Size()
+	if isCompressedObject(cachePath) {
+		size = -1
 	}
-	wg.Wait()
-	close(errs)
-	// Return the first error reported and ignore the rest. If there were no
-	// errors, because this is a buffered channel, we should receive the zero
-	// value, nil.
-	return <-errs
+	return remote.Put(checksum, reader, size)
 }
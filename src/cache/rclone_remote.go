@@ -0,0 +1,263 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// rcloneConfig is the path to the rclone config file, relative to the project
+// root.
+const rcloneConfig = ".dud/rclone.conf"
+
+// An rcloneRemote is a Remote accessed through the rclone command-line tool.
+// See https://rclone.org/docs/#syntax-of-remote-paths for the syntax of
+// rclone remotes.
+//
+// Individual object operations each spawn an rclone process, so Push and Fetch
+// prefer the batch operations, which transfer all objects with a single
+// process.
+type rcloneRemote struct {
+	remote string
+}
+
+func (remote rcloneRemote) objectPath(checksum string) (string, error) {
+	relPath, err := pathForChecksum(checksum)
+	if err != nil {
+		return "", err
+	}
+	// path.Join would mangle remotes such as "s3:", which refers to the root
+	// of the remote named "s3".
+	if strings.HasSuffix(remote.remote, ":") || strings.HasSuffix(remote.remote, "/") {
+		return remote.remote + relPath, nil
+	}
+	return remote.remote + "/" + relPath, nil
+}
+
+// for mocking
+var newRcloneCommand = func(args ...string) *exec.Cmd {
+	return exec.Command("rclone", append([]string{"--config", rcloneConfig}, args...)...)
+}
+
+// rclone exits with these codes when the requested directory or file doesn't
+// exist. See: https://rclone.org/docs/#exit-code
+func isRcloneNotFound(err error) bool {
+	exitErr, ok := err.(*exec.ExitError)
+	return ok && (exitErr.ExitCode() == 3 || exitErr.ExitCode() == 4)
+}
+
+func (remote rcloneRemote) Stat(checksum string) (bool, error) {
+	path, err := remote.objectPath(checksum)
+	if err != nil {
+		return false, err
+	}
+	out, err := newRcloneCommand("lsf", path).Output()
+	if isRcloneNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(bytes.TrimSpace(out)) > 0, nil
+}
+
+// rcloneReader streams stdout from an rclone process, and waits for the
+// process to exit when closed.
+type rcloneReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (reader rcloneReader) Close() error {
+	// Close the pipe first so rclone can't block on a full pipe if the reader
+	// was not drained.
+	reader.ReadCloser.Close()
+	return reader.cmd.Wait()
+}
+
+func (remote rcloneRemote) Get(checksum string) (io.ReadCloser, error) {
+	exists, err := remote.Stat(checksum)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, MissingFromRemoteError{checksum}
+	}
+	path, err := remote.objectPath(checksum)
+	if err != nil {
+		return nil, err
+	}
+	cmd := newRcloneCommand("cat", path)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return rcloneReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+func (remote rcloneRemote) Put(checksum string, reader io.Reader, size int64) error {
+	path, err := remote.objectPath(checksum)
+	if err != nil {
+		return err
+	}
+	cmd := newRcloneCommand("rcat", "--size", strconv.FormatInt(size, 10), path)
+	cmd.Stdin = reader
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func (remote rcloneRemote) List(listFn func(checksum string) error) error {
+	cmd := newRcloneCommand("lsf", "--recursive", "--files-only", remote.remote)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		cksum, ok := checksumFromPath(scanner.Text())
+		if !ok {
+			continue
+		}
+		if err := listFn(cksum); err != nil {
+			stdout.Close()
+			cmd.Wait()
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		cmd.Wait()
+		return err
+	}
+	err = cmd.Wait()
+	if isRcloneNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (remote rcloneRemote) pushFiles(cacheDir string, fileSet map[string]struct{}) error {
+	return remoteCopy(cacheDir, remote.remote, fileSet)
+}
+
+func (remote rcloneRemote) fetchFiles(cacheDir string, fileSet map[string]struct{}) error {
+	return remoteCopy(remote.remote, cacheDir, fileSet)
+}
+
+var remoteCopy = func(src, dst string, fileSet map[string]struct{}) error {
+	cmd := newRcloneCommand(
+		// Ideally these sorts of flags could be added to the rclone config,
+		// but I haven't found a way to add them.
+		// See: https://github.com/rclone/rclone/issues/2697
+		"--progress",
+		"--immutable",
+		// If file modification times change locally, without "--size-only",
+		// rclone will error-out because of the "--immutable" flag above.
+		"--size-only",
+		"copy",
+		// "--files-from -" means to get the list of files to copy from STDIN.
+		"--files-from",
+		"-",
+		src,
+		dst,
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		defer stdin.Close()
+		for file := range fileSet {
+			// We can ignore errors here because cmd.Wait() will return an
+			// error on any I/O failures.
+			fmt.Fprintln(stdin, file)
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+
+	// Ensure any local files that were created end up as read-only. Try to
+	// chmod all files, ignoring "no such file" errors which are probably due
+	// to the destination being remote. This is important even for push,
+	// because the "remote" might be a local directory.
+	return setFilePerms(dst, fileSet, cacheFilePerms)
+}
+
+func setFilePerms(commonDir string, fileSet map[string]struct{}, mode fs.FileMode) error {
+	numFiles := len(fileSet)
+	progress := newProgress(progressTemplateCount, numFiles, "Fixing permissions")
+	progress.Start()
+	defer progress.Finish()
+
+	// If there's a small number of files don't bother with concurrency.
+	if numFiles < maxSharedWorkers {
+		var chmodErr error = nil
+		for file := range fileSet {
+			err := os.Chmod(filepath.Join(commonDir, file), mode)
+			if err == nil || os.IsNotExist(err) {
+				progress.Increment()
+			} else {
+				chmodErr = err
+			}
+		}
+		return chmodErr
+	}
+
+	errs := make(chan error, numFiles)
+	fileChan := make(chan string)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for file := range fileSet {
+			fileChan <- file
+		}
+		close(fileChan)
+	}()
+	for i := 0; i < maxSharedWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range fileChan {
+				err := os.Chmod(filepath.Join(commonDir, file), mode)
+				// TODO: Consider exiting early on "no such file" errors; this
+				// likely means the remote is truly remote.
+				if err == nil || os.IsNotExist(err) {
+					progress.Increment()
+				} else {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	// Return the first error reported and ignore the rest. If there were no
+	// errors, because this is a buffered channel, we should receive the zero
+	// value, nil.
+	return <-errs
+}
@@ -0,0 +1,19 @@
+package cache
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates a copy-on-write clone of the file at srcPath at dstPath,
+// which must not exist. If the filesystem doesn't support cloning (or the files
+// are on different filesystems), cloneFile returns an error and dstPath is
+// left absent.
+func cloneFile(srcPath, dstPath string) error {
+	if err := unix.Clonefile(srcPath, dstPath, unix.CLONE_NOFOLLOW); err != nil {
+		return err
+	}
+	// Clones inherit the permissions of the (read-only) cache file.
+	return os.Chmod(dstPath, 0o644)
+}
@@ -0,0 +1,29 @@
+package cache
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates a copy-on-write clone of the file at srcPath at dstPath,
+// which must not exist. If the filesystem doesn't support cloning (or the files
+// are on different filesystems), cloneFile returns an error and dstPath is
+// left absent.
+func cloneFile(srcPath, dstPath string) error {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	dstFile, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlFileClone(int(dstFile.Fd()), int(srcFile.Fd())); err != nil {
+		dstFile.Close()
+		os.Remove(dstPath)
+		return err
+	}
+	return dstFile.Close()
+}
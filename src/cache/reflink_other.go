@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package cache
+
+import "errors"
+
+// cloneFile always fails on this platform, because there's no support for
+// copy-on-write clones.
+func cloneFile(srcPath, dstPath string) error {
+	return errors.New("reflinks are not supported on this platform")
+}
@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// The number of objects transferred to or from a Remote concurrently. Most
+// Remotes are network-bound, so this is independent of the number of CPUs.
+var maxTransferWorkers = 16
+
+// A Remote stores checksum-addressed objects outside of the local cache. It is
+// the destination for Push and the source for Fetch.
+type Remote interface {
+	// Stat returns true if the object with the given checksum exists on the
+	// remote.
+	Stat(checksum string) (bool, error)
+	// Get returns a reader for the object with the given checksum. The caller
+	// must close the reader. If the object does not exist, Get returns
+	// a MissingFromRemoteError.
+	Get(checksum string) (io.ReadCloser, error)
+	// Put stores the bytes read from reader as the object with the given
+	// checksum. size is the number of bytes expected from reader, or -1 if
+	// unknown.
+	Put(checksum string, reader io.Reader, size int64) error
+	// List calls listFn with the checksum of every object on the remote.
+	List(listFn func(checksum string) error) error
+}
+
+// A batchRemote is a Remote that transfers many objects at once more
+// efficiently than one at a time. The file sets hold object paths relative to
+// the local cache directory, as returned by PathForChecksum.
+type batchRemote interface {
+	Remote
+	pushFiles(cacheDir string, fileSet map[string]struct{}) error
+	fetchFiles(cacheDir string, fileSet map[string]struct{}) error
+}
+
+// NewRemote creates a Remote from the remote string in the Dud config. The
+// scheme of the remote, when given as a URL, selects the implementation:
+//
+//	file:///path/to/dir   a directory on a local or network filesystem
+//	s3://bucket/prefix    an AWS S3 (or S3-compatible) bucket
+//	http(s)://host/path   a cache served over HTTP (see Server)
+//
+// Any remote that is not a URL (i.e. has no "://") is treated as an rclone
+// remote path (e.g. "s3:bucket/dud"), and requires rclone to be installed.
+func NewRemote(remote string) (Remote, error) {
+	if remote == "" {
+		return nil, errors.New("remote must be set")
+	}
+	if !strings.Contains(remote, "://") {
+		return rcloneRemote{remote: remote}, nil
+	}
+	remoteURL, err := url.Parse(remote)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse remote")
+	}
+	switch remoteURL.Scheme {
+	case "file":
+		// Use the raw string rather than the parsed URL to support relative
+		// paths, e.g. "file://my_remote" (which a URL parser sees as a host).
+		return newFileRemote(strings.TrimPrefix(remote, "file://"))
+	case "s3":
+		return newS3Remote(remoteURL.Host, remoteURL.Path)
+	case "http", "https":
+		if remoteURL.Host == "" {
+			return nil, errors.New("http remote host must be set")
+		}
+		return newHTTPRemote(remote), nil
+	}
+	return nil, fmt.Errorf("unsupported remote type %#v", remoteURL.Scheme)
+}
+
+// MissingFromRemoteError is an error case where an object was expected on
+// a Remote but not found.
+type MissingFromRemoteError struct {
+	checksum string
+}
+
+func (err MissingFromRemoteError) Error() string {
+	return fmt.Sprintf("checksum missing from remote: %#v", err.checksum)
+}
+
+func isMissingFromRemote(err error) bool {
+	_, ok := errors.Cause(err).(MissingFromRemoteError)
+	return ok
+}
+
+// transferObjects calls transferFn concurrently for every checksum in
+// checksums, and returns the first error encountered. Errors are annotated
+// with the checksum of the object that failed.
+func transferObjects(
+	checksums map[string]struct{},
+	progressPrefix string,
+	transferFn func(checksum string) error,
+) error {
+	progress := newProgress(progressTemplateCount, len(checksums), progressPrefix)
+	progress.Start()
+	defer progress.Finish()
+
+	errGroup, groupCtx := errgroup.WithContext(context.Background())
+	work := make(chan string)
+	errGroup.Go(func() error {
+		defer close(work)
+		for checksum := range checksums {
+			select {
+			case work <- checksum:
+			case <-groupCtx.Done():
+				return groupCtx.Err()
+			}
+		}
+		return nil
+	})
+	for i := 0; i < maxTransferWorkers; i++ {
+		errGroup.Go(func() error {
+			for checksum := range work {
+				if err := transferFn(checksum); err != nil {
+					return errors.Wrap(err, checksum)
+				}
+				progress.Increment()
+			}
+			return nil
+		})
+	}
+	return errGroup.Wait()
+}
+
+// toFileSet converts a set of checksums to a set of object paths, as returned
+// by pathForChecksum.
+func toFileSet(checksums map[string]struct{}) map[string]struct{} {
+	fileSet := make(map[string]struct{}, len(checksums))
+	for checksum := range checksums {
+		// The checksums have already been validated by the caller.
+		path, _ := pathForChecksum(checksum)
+		fileSet[path] = struct{}{}
+	}
+	return fileSet
+}
+
+// writeObject atomically writes the bytes from reader to path. The bytes are
+// first written to a temporary file in tempDir, which must be on the same
+// filesystem as path, and then the file is moved into place. The file's
+// permissions are set to perms.
+func writeObject(path, tempDir string, reader io.Reader, perms os.FileMode) error {
+	tempFile, err := createTempObject(tempDir)
+	if err != nil {
+		return err
+	}
+	// If anything goes wrong, don't leave the temporary file behind. If the
+	// file was successfully renamed, this fails harmlessly.
+	defer os.Remove(tempFile.Name())
+	if _, err := io.Copy(tempFile, reader); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return moveObject(tempFile.Name(), path, perms)
+}
+
+// createTempObject creates a temporary file in tempDir (creating tempDir if
+// necessary) to be moved into place with moveObject. The caller is
+// responsible for removing the file.
+func createTempObject(tempDir string) (*os.File, error) {
+	if err := os.MkdirAll(tempDir, 0o755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(tempDir, "")
+}
+
+// moveObject moves the file at tempPath to path, creating path's parent
+// directory if necessary, and sets the file's permissions to perms.
+func moveObject(tempPath, path string, perms os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// As in LocalCache.placeObject, this rename may race others writing the
+	// same object, but they're all writing the same bytes, so the race is
+	// benign.
+	if err := os.Rename(tempPath, path); err != nil {
+		return err
+	}
+	return os.Chmod(path, perms)
+}
@@ -0,0 +1,233 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/strategy"
+	"github.com/pkg/errors"
+)
+
+func TestNewRemote(t *testing.T) {
+	t.Run("rclone remotes", func(t *testing.T) {
+		for _, input := range []string{"s3:bucket/dud", "my_remote:", "/mnt/dud"} {
+			remote, err := NewRemote(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(rcloneRemote{remote: input}, remote, cmp.AllowUnexported(rcloneRemote{})); diff != "" {
+				t.Fatalf("NewRemote(%#v) -want +got:\n%s", input, diff)
+			}
+		}
+	})
+
+	t.Run("file remotes", func(t *testing.T) {
+		cases := map[string]string{
+			"file:///mnt/dud":   "/mnt/dud",
+			"file://rel/dir/":   "rel/dir",
+			"file://../sibling": "../sibling",
+		}
+		for input, want := range cases {
+			remote, err := NewRemote(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(fileRemote{dir: want}, remote, cmp.AllowUnexported(fileRemote{})); diff != "" {
+				t.Fatalf("NewRemote(%#v) -want +got:\n%s", input, diff)
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, input := range []string{"", "file://", "ftp://example.com/dud"} {
+			if _, err := NewRemote(input); err == nil {
+				t.Fatalf("NewRemote(%#v): expected error", input)
+			}
+		}
+	})
+}
+
+func TestFileRemoteIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	checksum := "0123456789abcdef"
+	contents := []byte("hello, world")
+
+	t.Run("put then get", func(t *testing.T) {
+		remote, err := newFileRemote(filepath.Join(t.TempDir(), "remote"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exists, err := remote.Stat(checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists {
+			t.Fatal("object exists before Put")
+		}
+
+		if err := remote.Put(checksum, bytes.NewReader(contents), int64(len(contents))); err != nil {
+			t.Fatal(err)
+		}
+
+		exists, err = remote.Stat(checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatal("object missing after Put")
+		}
+
+		info, err := os.Stat(filepath.Join(remote.dir, "01", "23456789abcdef"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != cacheFilePerms {
+			t.Fatalf("object mode = %v, want %v", info.Mode(), cacheFilePerms)
+		}
+
+		reader, err := remote.Get(checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		got, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(contents, got); diff != "" {
+			t.Fatalf("Get() -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("get missing object", func(t *testing.T) {
+		remote, err := newFileRemote(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = remote.Get(checksum)
+		if _, ok := err.(MissingFromRemoteError); !ok {
+			t.Fatalf("expected MissingFromRemoteError, got %#v", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		remote, err := newFileRemote(filepath.Join(t.TempDir(), "remote"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		listFn := func(checksum string) error {
+			got = append(got, checksum)
+			return nil
+		}
+
+		// A remote that doesn't exist yet is empty.
+		if err := remote.List(listFn); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 0 {
+			t.Fatalf("expected empty remote, got %v", got)
+		}
+
+		want := []string{checksum, "ffff", "ab12"}
+		for _, sum := range want {
+			if err := remote.Put(sum, bytes.NewReader(contents), int64(len(contents))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Files that aren't objects should be ignored.
+		if err := os.WriteFile(filepath.Join(remote.dir, "README"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := remote.List(listFn); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(want)
+		sort.Strings(got)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("List() -want +got:\n%s", diff)
+		}
+	})
+}
+
+func TestFileRemotePushFetchIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	dirs, art, ch := setupDirTest(t)
+	defer os.RemoveAll(dirs.CacheDir)
+	defer os.RemoveAll(dirs.WorkDir)
+
+	if err := ch.Commit(dirs.WorkDir, &art, strategy.LinkStrategy, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	remote, err := NewRemote("file://" + filepath.Join(dirs.WorkDir, "remote"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	arts := map[string]*artifact.Artifact{"art": &art}
+
+	if err := ch.Push(remote, arts); err != nil {
+		t.Fatal(err)
+	}
+	assertCacheDirsEqual(dirs.CacheDir, filepath.Join(dirs.WorkDir, "remote"), t)
+
+	// Pushing again should be a no-op.
+	if err := ch.Push(remote, arts); err != nil {
+		t.Fatal(err)
+	}
+
+	freshCacheDir := filepath.Join(dirs.WorkDir, "fresh_cache")
+	freshCache, err := NewLocalCache(freshCacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := freshCache.Fetch(remote, arts); err != nil {
+		t.Fatal(err)
+	}
+	assertCacheDirsEqual(dirs.CacheDir, freshCacheDir, t)
+
+	results, err := freshCache.Verify([]*artifact.Artifact{&art}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("fetched objects failed verification: %+v", results)
+	}
+
+	t.Run("fetch reports missing objects", func(t *testing.T) {
+		emptyRemote, err := NewRemote("file://" + filepath.Join(dirs.WorkDir, "empty"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		emptyCache, err := NewLocalCache(filepath.Join(dirs.WorkDir, "empty_cache"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = emptyCache.Fetch(emptyRemote, arts)
+		if _, ok := errors.Cause(err).(MissingFromRemoteError); !ok {
+			t.Fatalf("expected MissingFromRemoteError, got %#v", err)
+		}
+		if !strings.Contains(err.Error(), art.Checksum) {
+			t.Fatalf("error %q does not name checksum %s", err, art.Checksum)
+		}
+	})
+}
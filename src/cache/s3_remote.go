@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+)
+
+// The default region used to sign requests if none is configured. S3 redirects
+// requests for buckets in other regions, and most S3-compatible servers ignore
+// the region altogether.
+const defaultS3Region = "us-east-1"
+
+// An s3Remote is a Remote in an AWS S3 bucket, or a bucket on any server that
+// implements the S3 API. Objects are stored under the prefix with the same
+// layout as the local cache, which is also the layout rclone uses, so Dud and
+// rclone can push to and fetch from the same bucket.
+//
+// Credentials, the region, and the endpoint URL are read from the standard AWS
+// environment variables (e.g. AWS_ACCESS_KEY_ID, AWS_REGION, and
+// AWS_ENDPOINT_URL) and config files (e.g. ~/.aws/credentials).
+type s3Remote struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+	prefix   string
+}
+
+func newS3Remote(bucket, prefix string) (s3Remote, error) {
+	if bucket == "" {
+		return s3Remote{}, errors.New("s3 remote bucket must be set")
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return s3Remote{}, errors.Wrap(err, "load AWS config")
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultS3Region
+	}
+	client := s3.NewFromConfig(cfg, func(opts *s3.Options) {
+		// Most S3-compatible servers don't support virtual-hosted-style
+		// bucket addressing.
+		opts.UsePathStyle = cfg.BaseEndpoint != nil
+	})
+	return s3Remote{
+		client: client,
+		// The uploader switches to concurrent multipart uploads for objects
+		// larger than its part size.
+		uploader: manager.NewUploader(client),
+		bucket:   bucket,
+		prefix:   strings.Trim(prefix, "/"),
+	}, nil
+}
+
+func (remote s3Remote) objectKey(checksum string) (string, error) {
+	relPath, err := pathForChecksum(checksum)
+	if err != nil {
+		return "", err
+	}
+	// Object keys always use forward slashes, regardless of the OS.
+	return path.Join(remote.prefix, filepath.ToSlash(relPath)), nil
+}
+
+// isS3NotFound returns true if err is S3's response to a missing object. HEAD
+// requests have no response body, so they report a bare "NotFound" code.
+func isS3NotFound(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code := apiErr.ErrorCode()
+		return code == "NotFound" || code == "NoSuchKey"
+	}
+	return false
+}
+
+func (remote s3Remote) Stat(checksum string) (bool, error) {
+	key, err := remote.objectKey(checksum)
+	if err != nil {
+		return false, err
+	}
+	_, err = remote.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(remote.bucket),
+		Key:    aws.String(key),
+	})
+	if isS3NotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (remote s3Remote) Get(checksum string) (io.ReadCloser, error) {
+	key, err := remote.objectKey(checksum)
+	if err != nil {
+		return nil, err
+	}
+	out, err := remote.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(remote.bucket),
+		Key:    aws.String(key),
+	})
+	if isS3NotFound(err) {
+		return nil, MissingFromRemoteError{checksum}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (remote s3Remote) Put(checksum string, reader io.Reader, _ int64) error {
+	key, err := remote.objectKey(checksum)
+	if err != nil {
+		return err
+	}
+	// The uploader determines the size of the object itself. Readers that
+	// implement io.Seeker (e.g. local cache files) avoid buffering whole parts
+	// in memory.
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(remote.bucket),
+		Key:    aws.String(key),
+		Body:   reader,
+	}
+	_, err = remote.uploader.Upload(context.Background(), input)
+	return err
+}
+
+func (remote s3Remote) List(listFn func(checksum string) error) error {
+	listPrefix := ""
+	if remote.prefix != "" {
+		listPrefix = remote.prefix + "/"
+	}
+	paginator := s3.NewListObjectsV2Paginator(remote.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(remote.bucket),
+		Prefix: aws.String(listPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return err
+		}
+		for _, object := range page.Contents {
+			relKey := strings.TrimPrefix(aws.ToString(object.Key), listPrefix)
+			cksum, ok := checksumFromPath(relKey)
+			if !ok {
+				continue
+			}
+			if err := listFn(cksum); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
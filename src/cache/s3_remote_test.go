@@ -0,0 +1,283 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+// fakeS3 is an in-memory S3-compatible server that records the requests it
+// receives.
+type fakeS3 struct {
+	backend  *s3mem.Backend
+	mutex    sync.Mutex
+	requests []string
+}
+
+// record wraps handler to record each request's method, path, and query.
+func (f *fakeS3) record(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mutex.Lock()
+		f.requests = append(f.requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+		f.mutex.Unlock()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// countRequests returns the number of recorded requests with the given method
+// whose query contains query.
+func (f *fakeS3) countRequests(method, query string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	count := 0
+	for _, req := range f.requests {
+		if strings.HasPrefix(req, method+" ") && strings.Contains(req, query) {
+			count++
+		}
+	}
+	return count
+}
+
+func (f *fakeS3) resetRequests() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.requests = nil
+}
+
+// startFakeS3 starts a fake S3 server with the given bucket, and points the
+// AWS environment variables at it.
+func startFakeS3(t *testing.T, bucket string) *fakeS3 {
+	f := &fakeS3{backend: s3mem.New()}
+	if err := f.backend.CreateBucket(bucket); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(f.record(gofakes3.New(f.backend).Server()))
+	t.Cleanup(server.Close)
+
+	// Isolate the tests from any AWS config on the host.
+	noConfig := filepath.Join(t.TempDir(), "none")
+	t.Setenv("AWS_CONFIG_FILE", noConfig)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", noConfig)
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "dud")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "dud")
+	t.Setenv("AWS_REGION", "")
+	return f
+}
+
+func TestS3RemoteIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	checksum := "0123456789abcdef"
+	contents := []byte("hello, world")
+
+	t.Run("put, stat, get, and list", func(t *testing.T) {
+		fake := startFakeS3(t, "bucket")
+		remote, err := NewRemote("s3://bucket/some/prefix")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exists, err := remote.Stat(checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists {
+			t.Fatal("object exists before Put")
+		}
+
+		if err := remote.Put(checksum, bytes.NewReader(contents), int64(len(contents))); err != nil {
+			t.Fatal(err)
+		}
+
+		// Objects must be laid out as rclone would lay them out.
+		obj, err := fake.backend.HeadObject("bucket", "some/prefix/01/23456789abcdef")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.Size != int64(len(contents)) {
+			t.Fatalf("object size = %d, want %d", obj.Size, len(contents))
+		}
+
+		exists, err = remote.Stat(checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatal("object missing after Put")
+		}
+
+		reader, err := remote.Get(checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(contents, got); diff != "" {
+			t.Fatalf("Get() -want +got:\n%s", diff)
+		}
+
+		if err := remote.Put("abcd", bytes.NewReader(contents), int64(len(contents))); err != nil {
+			t.Fatal(err)
+		}
+		// Keys outside of the object layout should be ignored.
+		if _, err := fake.backend.PutObject(
+			"bucket",
+			"some/prefix/README",
+			nil,
+			bytes.NewReader(nil),
+			0,
+		); err != nil {
+			t.Fatal(err)
+		}
+		var listed []string
+		if err := remote.List(func(checksum string) error {
+			listed = append(listed, checksum)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(listed)
+		if diff := cmp.Diff([]string{checksum, "abcd"}, listed); diff != "" {
+			t.Fatalf("List() -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("get missing object", func(t *testing.T) {
+		startFakeS3(t, "bucket")
+		remote, err := NewRemote("s3://bucket")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = remote.Get(checksum)
+		if _, ok := err.(MissingFromRemoteError); !ok {
+			t.Fatalf("expected MissingFromRemoteError, got %#v", err)
+		}
+	})
+
+	t.Run("large objects use multipart uploads", func(t *testing.T) {
+		fake := startFakeS3(t, "bucket")
+		remote, err := NewRemote("s3://bucket/prefix")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Larger than two of the uploader's default 5 MiB parts.
+		big := make([]byte, 11*1024*1024)
+		if _, err := rand.Read(big); err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Put(checksum, bytes.NewReader(big), int64(len(big))); err != nil {
+			t.Fatal(err)
+		}
+		if fake.countRequests(http.MethodPost, "uploads") != 1 {
+			t.Fatalf("expected a multipart upload, got requests: %v", fake.requests)
+		}
+		if parts := fake.countRequests(http.MethodPut, "partNumber"); parts != 3 {
+			t.Fatalf("expected 3 parts, got %d", parts)
+		}
+
+		reader, err := remote.Get(checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(big, got) {
+			t.Fatal("multipart object contents differ")
+		}
+	})
+
+	t.Run("bucket must be set", func(t *testing.T) {
+		if _, err := NewRemote("s3:///prefix"); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestS3RemotePushFetchIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	dirs, art, ch := setupDirTest(t)
+	defer os.RemoveAll(dirs.CacheDir)
+	defer os.RemoveAll(dirs.WorkDir)
+
+	if err := ch.Commit(dirs.WorkDir, &art, strategy.LinkStrategy, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := startFakeS3(t, "bucket")
+	remote, err := NewRemote("s3://bucket/dud/cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	arts := map[string]*artifact.Artifact{"art": &art}
+
+	if err := ch.Push(remote, arts); err != nil {
+		t.Fatal(err)
+	}
+	cacheFiles, err := getCacheFiles(dirs.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if puts := fake.countRequests(http.MethodPut, ""); puts != len(cacheFiles) {
+		t.Fatalf("expected %d uploads, got %d", len(cacheFiles), puts)
+	}
+
+	// Objects are immutable, so pushing again should only check for existence.
+	fake.resetRequests()
+	if err := ch.Push(remote, arts); err != nil {
+		t.Fatal(err)
+	}
+	if puts := fake.countRequests(http.MethodPut, ""); puts != 0 {
+		t.Fatalf("expected no uploads, got %d", puts)
+	}
+	if heads := fake.countRequests(http.MethodHead, ""); heads != len(cacheFiles) {
+		t.Fatalf("expected %d existence checks, got %d", len(cacheFiles), heads)
+	}
+
+	freshCacheDir := filepath.Join(dirs.WorkDir, "fresh_cache")
+	freshCache, err := NewLocalCache(freshCacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := freshCache.Fetch(remote, arts); err != nil {
+		t.Fatal(err)
+	}
+	assertCacheDirsEqual(dirs.CacheDir, freshCacheDir, t)
+
+	results, err := freshCache.Verify([]*artifact.Artifact{&art}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("fetched objects failed verification: %+v", results)
+	}
+}
@@ -0,0 +1,262 @@
+package cache
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/checksum"
+	"golang.org/x/sync/singleflight"
+)
+
+// A Server serves the objects in a LocalCache over HTTP, so other projects
+// can use the cache as a Remote (see NewRemote). Objects are served at the
+// same paths they occupy in the cache (e.g. "/ab/cdef..."), and a GET request
+// for "/" lists the checksums of all objects in the cache, one per line.
+//
+// GET and HEAD requests are always allowed. PUT requests store a new object,
+// and are only allowed if the Server has a token. Clients must present the
+// token as a bearer token (i.e. "Authorization: Bearer <token>").
+//
+// If the Server has an upstream Remote, objects missing from the cache are
+// fetched from the upstream Remote on demand. This allows a Server to act as
+// a caching proxy in front of a slower or more expensive Remote.
+type Server struct {
+	cache    LocalCache
+	upstream Remote
+	token    string
+	logger   *agglog.AggLogger
+	fetches  *singleflight.Group
+}
+
+// NewServer creates a Server for the given cache. If upstream is nil, the
+// Server only serves objects already in the cache. If token is empty, PUT
+// requests are rejected.
+func NewServer(ch LocalCache, upstream Remote, token string, logger *agglog.AggLogger) Server {
+	return Server{
+		cache:    ch,
+		upstream: upstream,
+		token:    token,
+		logger:   logger,
+		fetches:  new(singleflight.Group),
+	}
+}
+
+// checksumFromURLPath converts a URL path (e.g. "/ab/cdef") to a checksum
+// (e.g. "abcdef"). The second return value is false if the path doesn't
+// address an object.
+func checksumFromURLPath(urlPath string) (string, bool) {
+	return checksumFromPath(strings.TrimPrefix(urlPath, "/"))
+}
+
+func (srv Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.logger.Debug.Printf("%s %s\n", r.Method, r.URL.Path)
+	if r.URL.Path == "/" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		srv.listObjects(w)
+		return
+	}
+	cksum, ok := checksumFromURLPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		srv.getObject(w, r, cksum)
+	case http.MethodHead:
+		srv.headObject(w, r, cksum)
+	case http.MethodPut:
+		srv.putObject(w, r, cksum)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serverError logs err and responds with an internal server error. The error
+// isn't sent to the client, as it may contain details about the server's
+// filesystem.
+func (srv Server) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	srv.logger.Error.Printf("%s %s: %v\n", r.Method, r.URL.Path, err)
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
+
+func (srv Server) listObjects(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writer := bufio.NewWriter(w)
+	err := srv.cache.walkObjects(func(cksum, _ string, _ fs.FileInfo) error {
+		_, err := fmt.Fprintln(writer, cksum)
+		return err
+	})
+	if err != nil {
+		// The response is already underway, so the best we can do is log the
+		// error and cut the response short.
+		srv.logger.Error.Printf("list objects: %v\n", err)
+		return
+	}
+	writer.Flush()
+}
+
+// findObject returns the location of the object with the given checksum in
+// the cache (see LocalCache.findObject). If the object isn't in the cache, it
+// is first fetched from the upstream Remote, if any.
+func (srv Server) findObject(cksum string) (string, error) {
+	cachePath, _, err := srv.cache.findObject(cksum)
+	if !os.IsNotExist(err) || srv.upstream == nil {
+		return cachePath, err
+	}
+	// Only fetch each object once, no matter how many clients request it.
+	_, err, _ = srv.fetches.Do(cksum, func() (interface{}, error) {
+		srv.logger.Debug.Printf("fetching %s from upstream\n", cksum)
+		ok, err := fetchObject(srv.cache, srv.upstream, cksum)
+		if err == nil && !ok {
+			err = ChecksumMismatchError{Checksums: []string{cksum}}
+		}
+		return nil, err
+	})
+	if err != nil {
+		return "", err
+	}
+	cachePath, _, err = srv.cache.findObject(cksum)
+	return cachePath, err
+}
+
+func (srv Server) getObject(w http.ResponseWriter, r *http.Request, cksum string) {
+	cachePath, err := srv.findObject(cksum)
+	if os.IsNotExist(err) || isMissingFromRemote(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		srv.serverError(w, r, err)
+		return
+	}
+	reader, err := openObject(filepath.Join(srv.cache.dir, cachePath))
+	if err != nil {
+		srv.serverError(w, r, err)
+		return
+	}
+	defer reader.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	// Objects never change, so clients and proxies may cache them forever.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	// Uncompressed objects support range requests and the like. Clients
+	// always receive the contents of compressed objects, which are simply
+	// streamed.
+	if file, ok := reader.(*os.File); ok {
+		http.ServeContent(w, r, "", time.Time{}, file)
+		return
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		// The response is already underway, so the best we can do is log the
+		// error and cut the response short.
+		srv.logger.Error.Printf("%s %s: %v\n", r.Method, r.URL.Path, err)
+	}
+}
+
+func (srv Server) headObject(w http.ResponseWriter, r *http.Request, cksum string) {
+	cachePath, info, err := srv.cache.findObject(cksum)
+	if _, ok := err.(InvalidChecksumError); ok {
+		http.NotFound(w, r)
+		return
+	}
+	if err == nil {
+		// The size of a compressed object's contents isn't known in advance.
+		if !isCompressedObject(cachePath) {
+			w.Header().Set("Content-Length", fmt.Sprint(info.Size()))
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !os.IsNotExist(err) {
+		srv.serverError(w, r, err)
+		return
+	}
+	// Don't download the object just to report that it exists.
+	exists := false
+	if srv.upstream != nil {
+		if exists, err = srv.upstream.Stat(cksum); err != nil {
+			srv.serverError(w, r, err)
+			return
+		}
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (srv Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(srv.token)) == 1
+}
+
+func (srv Server) putObject(w http.ResponseWriter, r *http.Request, cksum string) {
+	if srv.token == "" {
+		http.Error(w, "push is disabled on this server", http.StatusForbidden)
+		return
+	}
+	if !srv.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dud"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	cachePath, _, err := srv.cache.findObject(cksum)
+	if _, ok := err.(InvalidChecksumError); ok {
+		http.NotFound(w, r)
+		return
+	}
+	// Objects are immutable, so there's no need to replace existing objects.
+	if err == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	tempFile, err := srv.cache.createStagingFile()
+	if err != nil {
+		srv.serverError(w, r, err)
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	writer, suffix := srv.cache.newObjectWriter(tempFile)
+	ok, actual, err := checksum.Verify(io.TeeReader(r.Body, writer), cksum)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		srv.serverError(w, r, err)
+		return
+	}
+	// Never let a client store bytes under the wrong checksum.
+	if !ok {
+		http.Error(
+			w,
+			fmt.Sprintf("checksum mismatch: got %s", actual),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	fullPath := filepath.Join(srv.cache.dir, cachePath+suffix)
+	if err := srv.cache.placeObject(cksum, tempFile.Name(), fullPath); err != nil {
+		srv.serverError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
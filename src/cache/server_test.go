@@ -0,0 +1,288 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/checksum"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestChecksumFromURLPath(t *testing.T) {
+	cases := map[string]string{
+		"/ab/cdef":        "abcdef",
+		"/sha256/ab/cdef": "sha256:abcdef",
+		"/blake3/ab/cdef": "",
+		"/md5/ab/cdef":    "",
+		"/ab/":            "",
+		"/abc/def":        "",
+		"/ab/cd/ef":       "",
+		"/AB/cdef":        "",
+		"/ab/cdeg":        "",
+		"/":               "",
+		"/..":             "",
+	}
+	for input, want := range cases {
+		got, ok := checksumFromURLPath(input)
+		if ok != (want != "") || got != want {
+			t.Errorf("checksumFromURLPath(%#v) = %#v, %v; want %#v", input, got, ok, want)
+		}
+	}
+}
+
+func TestServerIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+	contents := []byte("hello, world")
+	cksum, err := checksum.Checksum(bytes.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// startServer serves a new, empty cache and returns a Remote for it.
+	startServer := func(t *testing.T, upstream Remote, token string) (LocalCache, Remote) {
+		ch, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := httptest.NewServer(NewServer(ch, upstream, token, logger))
+		t.Cleanup(server.Close)
+		t.Setenv(HTTPTokenEnvVar, token)
+		remote, err := NewRemote(server.URL + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ch, remote
+	}
+
+	put := func(remote Remote, cksum string, contents []byte) error {
+		return remote.Put(cksum, bytes.NewReader(contents), int64(len(contents)))
+	}
+
+	t.Run("push is disabled without a token", func(t *testing.T) {
+		_, remote := startServer(t, nil, "")
+		err := put(remote, cksum, contents)
+		if err == nil || !strings.Contains(err.Error(), "403") {
+			t.Fatalf("expected forbidden error, got %v", err)
+		}
+	})
+
+	t.Run("push requires the token", func(t *testing.T) {
+		_, remote := startServer(t, nil, "secret")
+		badRemote := remote.(httpRemote)
+		badRemote.token = "wrong"
+		err := put(badRemote, cksum, contents)
+		if err == nil || !strings.Contains(err.Error(), "401") {
+			t.Fatalf("expected unauthorized error, got %v", err)
+		}
+	})
+
+	t.Run("push rejects checksum mismatches", func(t *testing.T) {
+		ch, remote := startServer(t, nil, "secret")
+		err := put(remote, cksum, []byte("not the same"))
+		if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Fatalf("expected checksum mismatch error, got %v", err)
+		}
+		objects := 0
+		if err := ch.walkObjects(func(string, string, os.FileInfo) error {
+			objects++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if objects != 0 {
+			t.Fatalf("expected empty cache, found %d objects", objects)
+		}
+	})
+
+	t.Run("push, stat, get, and list", func(t *testing.T) {
+		ch, remote := startServer(t, nil, "secret")
+
+		exists, err := remote.Stat(cksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists {
+			t.Fatal("object exists before Put")
+		}
+		if _, err := remote.Get(cksum); !isMissingFromRemote(err) {
+			t.Fatalf("expected MissingFromRemoteError, got %v", err)
+		}
+
+		if err := put(remote, cksum, contents); err != nil {
+			t.Fatal(err)
+		}
+		// Pushing an existing object is a no-op.
+		if err := put(remote, cksum, contents); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(objectPath(t, ch, cksum))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != cacheFilePerms {
+			t.Fatalf("object mode = %v, want %v", info.Mode(), cacheFilePerms)
+		}
+
+		exists, err = remote.Stat(cksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatal("object missing after Put")
+		}
+
+		reader, err := remote.Get(cksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(contents, got); diff != "" {
+			t.Fatalf("Get() -want +got:\n%s", diff)
+		}
+
+		emptyCksum, err := checksum.Checksum(bytes.NewReader(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := put(remote, emptyCksum, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		var listed []string
+		if err := remote.List(func(cksum string) error {
+			listed = append(listed, cksum)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{cksum, emptyCksum}
+		sort.Strings(want)
+		sort.Strings(listed)
+		if diff := cmp.Diff(want, listed); diff != "" {
+			t.Fatalf("List() -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("read-through fetches from upstream", func(t *testing.T) {
+		upstream, err := newFileRemote(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := put(upstream, cksum, contents); err != nil {
+			t.Fatal(err)
+		}
+		ch, remote := startServer(t, upstream, "")
+
+		// HEAD consults the upstream without downloading the object.
+		exists, err := remote.Stat(cksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatal("expected object to exist upstream")
+		}
+		if _, err := os.Stat(objectPath(t, ch, cksum)); !os.IsNotExist(err) {
+			t.Fatalf("expected object not to be fetched, got %v", err)
+		}
+
+		reader, err := remote.Get(cksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(contents, got); diff != "" {
+			t.Fatalf("Get() -want +got:\n%s", diff)
+		}
+		if _, err := os.Stat(objectPath(t, ch, cksum)); err != nil {
+			t.Fatalf("expected object to be fetched into the cache: %v", err)
+		}
+
+		if _, err := remote.Get("0123456789"); !isMissingFromRemote(err) {
+			t.Fatalf("expected MissingFromRemoteError, got %v", err)
+		}
+	})
+
+	t.Run("unsupported methods", func(t *testing.T) {
+		_, remote := startServer(t, nil, "")
+		req, err := http.NewRequest(http.MethodDelete, remote.(httpRemote).baseURL+"/ab/cdef", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Fatalf("DELETE status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func TestServerPushFetchIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	dirs, art, ch := setupDirTest(t)
+	defer os.RemoveAll(dirs.CacheDir)
+	defer os.RemoveAll(dirs.WorkDir)
+
+	if err := ch.Commit(dirs.WorkDir, &art, strategy.LinkStrategy, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	serverCache, err := NewLocalCache(filepath.Join(dirs.WorkDir, "server_cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewServer(serverCache, nil, "secret", logger))
+	defer server.Close()
+
+	t.Setenv(HTTPTokenEnvVar, "secret")
+	remote, err := NewRemote(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arts := map[string]*artifact.Artifact{"art": &art}
+
+	if err := ch.Push(remote, arts); err != nil {
+		t.Fatal(err)
+	}
+	assertCacheDirsEqual(dirs.CacheDir, serverCache.dir, t)
+
+	freshCacheDir := filepath.Join(dirs.WorkDir, "fresh_cache")
+	freshCache, err := NewLocalCache(freshCacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := freshCache.Fetch(remote, arts); err != nil {
+		t.Fatal(err)
+	}
+	assertCacheDirsEqual(dirs.CacheDir, freshCacheDir, t)
+}
@@ -0,0 +1,267 @@
+package cache
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kevin-hanselman/dud/src/fsutil"
+)
+
+const (
+	// stagingDir is the directory, relative to the cache root, holding
+	// temporary files being written to the cache. Keeping them out of the
+	// cache root makes abandoned files easy to find (see CleanStaging).
+	stagingDir = "staging"
+
+	// lockDir is the directory, relative to the cache root, holding the lock
+	// files used to coordinate access to objects in a shared cache (see
+	// lockObject).
+	lockDir = "locks"
+
+	// stagingMaxAge is how long a temporary file may go unmodified before
+	// CleanStaging assumes it was abandoned. Files are modified constantly
+	// while they're written, and are moved out of the staging directory as
+	// soon as they're complete.
+	stagingMaxAge = 24 * time.Hour
+
+	sharedDirPerms = 0o775 | fs.ModeSetgid
+)
+
+// mkdirAll is like os.MkdirAll for directories in the cache. In a shared
+// cache (see WithSharedGroup), new directories are owned by the cache's group
+// and are group-writable and setgid.
+func (ch LocalCache) mkdirAll(path string) error {
+	if !ch.shared {
+		return os.MkdirAll(path, 0o755)
+	}
+	info, err := os.Stat(path)
+	if err == nil {
+		if !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: path, Err: fs.ErrExist}
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if err := ch.mkdirAll(filepath.Dir(path)); err != nil {
+		return err
+	}
+	if err := os.Mkdir(path, 0o755); err != nil {
+		// Another process may have beaten us to it, in which case it also
+		// sets the permissions.
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Chown(path, -1, ch.group); err != nil {
+		return err
+	}
+	// Chmod ignores the umask, which would otherwise clear the group-write
+	// bit.
+	return os.Chmod(path, sharedDirPerms)
+}
+
+// createStagingFile creates a temporary file in the cache's staging directory
+// to be moved into place with placeObject. The caller is responsible for
+// removing the file.
+func (ch LocalCache) createStagingFile() (*os.File, error) {
+	dir := filepath.Join(ch.dir, stagingDir)
+	if err := ch.mkdirAll(dir); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, "")
+}
+
+// placeObject moves the file at tempPath to path in the cache, creating
+// path's parent directory if necessary, and makes it read-only. checksum
+// identifies the object (see lockObject). The file's modification time is set
+// to now, so that processes removing objects concurrently can tell it was just
+// stored (see removeObject).
+func (ch LocalCache) placeObject(checksum, tempPath, path string) error {
+	unlock, err := ch.lockObject(checksum, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := ch.mkdirAll(filepath.Dir(path)); err != nil {
+		return err
+	}
+	// This rename may race others, but luckily we don't care who wins the
+	// race. Everyone in the race is trying to put the same exact file in the
+	// cache (because of content-addressed storage), so the outcome is the same
+	// no matter who wins the race. At the OS level rename is atomic, so
+	// there's no risk of corrupting the destination file with multiple
+	// concurrent syscalls. (This is at least true for UNIX, but that's all we
+	// support. See also: https://github.com/golang/go/issues/8914)
+	if err := os.Rename(tempPath, path); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return err
+	}
+	return os.Chmod(path, cacheFilePerms)
+}
+
+// lockObject locks the object with the given checksum against concurrent
+// changes by other processes sharing the cache (see WithSharedGroup). Adding
+// an object to the cache doesn't conflict with adding it elsewhere, so
+// placeObject takes a shared lock; removing or moving an object takes an
+// exclusive lock. Each object has its own lock file, laid out like the objects
+// themselves, which is created when it's first needed and removed along with
+// the object (see removeLock). Caches that aren't shared are only used by one
+// project at a time, which the project lock already ensures, so their objects
+// aren't locked. The returned function releases the lock.
+func (ch LocalCache) lockObject(checksum string, exclusive bool) (func(), error) {
+	cachePath, err := pathForChecksum(checksum)
+	if err != nil {
+		return nil, err
+	}
+	if !ch.shared {
+		return func() {}, nil
+	}
+	path := filepath.Join(ch.dir, lockDir, cachePath)
+	if err := ch.mkdirAll(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	for {
+		// Locking only requires read access, so lock files can be read-only,
+		// and shared by every user of the cache.
+		lockFile, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0o444)
+		if err != nil {
+			return nil, err
+		}
+		if err := fsutil.Lock(lockFile, exclusive); err != nil {
+			lockFile.Close()
+			return nil, err
+		}
+		// If the lock file was removed while we waited for the lock, another
+		// process may already hold the lock on a new lock file, so try again.
+		lockInfo, err := lockFile.Stat()
+		if err != nil {
+			lockFile.Close()
+			return nil, err
+		}
+		pathInfo, err := os.Stat(path)
+		if err == nil && os.SameFile(lockInfo, pathInfo) {
+			return func() { lockFile.Close() }, nil
+		}
+		lockFile.Close()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// removeLock removes the lock file of the object with the given checksum,
+// which must be locked exclusively (see lockObject), once the object is
+// removed from the cache.
+func (ch LocalCache) removeLock(checksum string) error {
+	if !ch.shared {
+		return nil
+	}
+	cachePath, err := pathForChecksum(checksum)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(ch.dir, lockDir, cachePath))
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return err
+}
+
+// removeObject removes the file at path, relative to the cache directory,
+// which holds the object (or reassembled file) with the given checksum. The
+// access record and lock file of the object (see recordAccess and lockObject)
+// are removed with it. If the file was stored after the given time, presumably
+// by another process using the cache, or if it no longer exists, removeObject
+// leaves it alone and returns false.
+func (ch LocalCache) removeObject(checksum, path string, storedBefore time.Time) (bool, error) {
+	unlock, err := ch.lockObject(checksum, true)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	fullPath := filepath.Join(ch.dir, path)
+	info, err := os.Stat(fullPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().After(storedBefore) {
+		return false, nil
+	}
+	if err := os.Remove(fullPath); err != nil {
+		return false, err
+	}
+	// Reassembled files share their chunk manifests' access records and
+	// locks.
+	if strings.HasPrefix(path, reconstructionDir+string(filepath.Separator)) {
+		return true, nil
+	}
+	if err := ch.removeLock(checksum); err != nil {
+		return true, err
+	}
+	recordPath, err := pathForChecksum(checksum)
+	if err != nil {
+		return true, err
+	}
+	err = os.Remove(filepath.Join(ch.dir, accessDir, recordPath))
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return true, err
+}
+
+// CleanStaging removes temporary files (and directories of fetched files)
+// abandoned by Dud processes that exited before they finished writing to the
+// cache, including those left in the cache root by older versions of Dud.
+// Recently modified files may belong to another process using the cache, so
+// they're left alone.
+func (ch LocalCache) CleanStaging() error {
+	cutoff := time.Now().Add(-stagingMaxAge)
+	for _, dir := range []string{filepath.Join(ch.dir, stagingDir), ch.dir} {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			isStagedDir := entry.IsDir() && dir != ch.dir
+			if !entry.Type().IsRegular() && !isStagedDir {
+				continue
+			}
+			// Older versions of Dud created temporary files in the cache root
+			// with os.CreateTemp, which uses numeric names. Leave any other
+			// files there alone.
+			if dir == ch.dir && strings.Trim(entry.Name(), "0123456789") != "" {
+				continue
+			}
+			info, err := entry.Info()
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if info.ModTime().After(cutoff) {
+				continue
+			}
+			err = os.RemoveAll(filepath.Join(dir, entry.Name()))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestSharedCacheIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	// commitFile commits a file with the given contents to the cache.
+	commitFile := func(t *testing.T, ch LocalCache, contents string) artifact.Artifact {
+		workDir := t.TempDir()
+		art := artifact.Artifact{Path: "file.txt"}
+		if err := os.WriteFile(filepath.Join(workDir, art.Path), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		return art
+	}
+
+	t.Run("temporary files are written to the staging directory", func(t *testing.T) {
+		ch, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		commitFile(t, ch, "foo")
+		entries, err := os.ReadDir(ch.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				t.Fatalf("found file %s in cache root", entry.Name())
+			}
+		}
+	})
+
+	t.Run("CleanStaging removes abandoned temporary files", func(t *testing.T) {
+		ch, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		longAgo := time.Now().Add(-2 * stagingMaxAge)
+		files := map[string]bool{
+			filepath.Join(stagingDir, "123"):     false,
+			filepath.Join(stagingDir, "recent"):  true,
+			"456":                                false,
+			"789-recent":                         true,
+			"notes.txt":                          true,
+			filepath.Join(quarantineDir, "1234"): true,
+		}
+		for path := range files {
+			fullPath := filepath.Join(ch.dir, path)
+			if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(fullPath, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(path, "recent") {
+				if err := os.Chtimes(fullPath, longAgo, longAgo); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		// Directories of fetched files are removed along with their contents.
+		for _, dir := range []string{"fetch123", "fetch456-recent"} {
+			dirPath := filepath.Join(ch.dir, stagingDir, dir)
+			if err := os.MkdirAll(filepath.Join(dirPath, "ab"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dirPath, "ab", "cdef"), nil, 0o444); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(dir, "recent") {
+				if err := os.Chtimes(dirPath, longAgo, longAgo); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		files[filepath.Join(stagingDir, "fetch123")] = false
+		files[filepath.Join(stagingDir, "fetch456-recent", "ab", "cdef")] = true
+
+		if err := ch.CleanStaging(); err != nil {
+			t.Fatal(err)
+		}
+
+		for path, wantExists := range files {
+			_, err := os.Stat(filepath.Join(ch.dir, path))
+			if exists := err == nil; exists != wantExists {
+				t.Errorf("%s exists = %v, want %v", path, exists, wantExists)
+			}
+		}
+	})
+
+	t.Run("objects in unshared caches are not locked", func(t *testing.T) {
+		ch, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		commitFile(t, ch, "foo")
+		if _, err := os.Stat(filepath.Join(ch.dir, lockDir)); !os.IsNotExist(err) {
+			t.Fatalf("os.Stat(%s) error = %v, want not exist", lockDir, err)
+		}
+	})
+
+	t.Run("removeObject leaves objects stored after the given time", func(t *testing.T) {
+		ch, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		art := commitFile(t, ch, "foo")
+		cachePath, _, err := ch.findObject(art.Checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		removed, err := ch.removeObject(art.Checksum, cachePath, start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed {
+			t.Fatal("expected newly stored object not to be removed")
+		}
+		removed, err = ch.removeObject(art.Checksum, cachePath, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !removed {
+			t.Fatal("expected object to be removed")
+		}
+		if _, _, err := ch.findObject(art.Checksum); !os.IsNotExist(err) {
+			t.Fatalf("expected object to be gone, got %v", err)
+		}
+	})
+
+	t.Run("quarantine leaves repaired objects", func(t *testing.T) {
+		ch, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		art := commitFile(t, ch, "foo")
+		quarantined, err := ch.quarantineObject(art.Checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if quarantined {
+			t.Fatal("expected healthy object not to be quarantined")
+		}
+
+		objPath := objectPath(t, ch, art.Checksum)
+		if err := os.Chmod(objPath, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(objPath, []byte("bar"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		quarantined, err = ch.quarantineObject(art.Checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !quarantined {
+			t.Fatal("expected corrupt object to be quarantined")
+		}
+	})
+}
@@ -0,0 +1,141 @@
+//go:build !windows
+
+package cache
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestSharedGroupIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	// Any group the user belongs to will do.
+	ch, err := NewLocalCache(filepath.Join(t.TempDir(), "cache"), WithSharedGroup(os.Getgid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	workDir := t.TempDir()
+	art := artifact.Artifact{Path: "file.txt"}
+	if err := os.WriteFile(filepath.Join(workDir, art.Path), []byte("foo"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Commit(workDir, &art, strategy.CopyStrategy, agglog.NewNullLogger()); err != nil {
+		t.Fatal(err)
+	}
+	objectDir := filepath.Dir(objectPath(t, ch, art.Checksum))
+	for _, dir := range []string{ch.dir, objectDir, filepath.Join(ch.dir, stagingDir)} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode()&fs.ModePerm != 0o775 || info.Mode()&fs.ModeSetgid == 0 {
+			t.Fatalf("%s has mode %s, want %s", dir, info.Mode(), fs.ModeDir|sharedDirPerms)
+		}
+		if gid := int(info.Sys().(*syscall.Stat_t).Gid); gid != os.Getgid() {
+			t.Fatalf("%s has group %d, want %d", dir, gid, os.Getgid())
+		}
+	}
+}
+
+func TestSharedObjectLocksIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	newSharedCache := func(t *testing.T) LocalCache {
+		ch, err := NewLocalCache(filepath.Join(t.TempDir(), "cache"), WithSharedGroup(os.Getgid()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ch
+	}
+
+	// lockInBackground takes an exclusive lock on the given object and
+	// releases it at once, closing the returned channel when done.
+	lockInBackground := func(t *testing.T, ch LocalCache, checksum string) chan struct{} {
+		locked := make(chan struct{})
+		go func() {
+			unlock, err := ch.lockObject(checksum, true)
+			if err != nil {
+				t.Error(err)
+			} else {
+				unlock()
+			}
+			close(locked)
+		}()
+		return locked
+	}
+
+	t.Run("exclusive object locks wait for shared locks", func(t *testing.T) {
+		ch := newSharedCache(t)
+		checksum := "0123456789abcdef"
+		unlock, err := ch.lockObject(checksum, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		locked := lockInBackground(t, ch, checksum)
+		select {
+		case <-locked:
+			t.Fatal("exclusive lock taken while shared lock held")
+		case <-time.After(50 * time.Millisecond):
+		}
+		unlock()
+		select {
+		case <-locked:
+		case <-time.After(5 * time.Second):
+			t.Fatal("exclusive lock not taken after shared lock released")
+		}
+	})
+
+	t.Run("objects are locked individually", func(t *testing.T) {
+		ch := newSharedCache(t)
+		unlock, err := ch.lockObject("0123456789abcdef", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unlock()
+		// This object is in the same shard as the locked one.
+		select {
+		case <-lockInBackground(t, ch, "01ffff"):
+		case <-time.After(5 * time.Second):
+			t.Fatal("lock on one object blocked another")
+		}
+	})
+
+	t.Run("lock files are removed with their objects", func(t *testing.T) {
+		ch := newSharedCache(t)
+		workDir := t.TempDir()
+		art := artifact.Artifact{Path: "file.txt"}
+		if err := os.WriteFile(filepath.Join(workDir, art.Path), []byte("foo"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, agglog.NewNullLogger()); err != nil {
+			t.Fatal(err)
+		}
+		cachePath, err := pathForChecksum(art.Checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lockPath := filepath.Join(ch.dir, lockDir, cachePath)
+		if _, err := os.Stat(lockPath); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ch.removeObject(art.Checksum, cachePath, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+			t.Fatalf("os.Stat(lock file) error = %v, want not exist", err)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"path/filepath"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+)
+
+// isExcluded returns true if the file or directory at relPath (relative to
+// the workspace) is excluded from a sparse checkout (see WithSparse).
+// Directories are only excluded if nothing inside them could match.
+func (ch LocalCache) isExcluded(relPath string, isDir bool) bool {
+	if ch.sparse == nil {
+		return false
+	}
+	relPath = filepath.ToSlash(relPath)
+	for _, pattern := range ch.sparse {
+		if isDir && pattern.MatchPrefix(relPath) || pattern.Match(relPath) {
+			return false
+		}
+	}
+	return true
+}
+
+// isExcludedWorkPath is like isExcluded, but for workPath, a path in the
+// workspace at sparseRoot.
+func (ch LocalCache) isExcludedWorkPath(workPath string, isDir bool) bool {
+	if ch.sparse == nil {
+		return false
+	}
+	relPath, err := filepath.Rel(ch.sparseRoot, workPath)
+	if err != nil {
+		return false
+	}
+	return ch.isExcluded(relPath, isDir)
+}
+
+// sparseKey returns a key under which to collect art with other Artifacts.
+// Artifacts are keyed by checksum so duplicates are only handled once, except
+// in a sparse checkout, where the same directory may be excluded in one
+// place but not another.
+func (ch LocalCache) sparseKey(art *artifact.Artifact) string {
+	if ch.sparse == nil {
+		return art.Checksum
+	}
+	return art.Checksum + ":" + art.Path
+}
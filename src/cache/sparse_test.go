@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/glob"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestSparseIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	// setupSparseTest commits the test directory, pushes it to a remote, and
+	// returns the committed Artifact, the remote, and an empty workspace and
+	// cache for a sparse checkout of foo/bar.
+	setupSparseTest := func(t *testing.T) (artifact.Artifact, Remote, string, LocalCache) {
+		dirs, art, ch := setupDirTest(t)
+		t.Cleanup(func() {
+			os.RemoveAll(dirs.CacheDir)
+			os.RemoveAll(dirs.WorkDir)
+		})
+		if err := ch.Commit(dirs.WorkDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		remote, err := newFileRemote(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Push(remote, map[string]*artifact.Artifact{art.Path: &art}); err != nil {
+			t.Fatal(err)
+		}
+		patterns, err := glob.CompileAll([]string{"foo/bar/**"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sparseCache, err := NewLocalCache(t.TempDir(), WithSparse(patterns))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return art, remote, t.TempDir(), sparseCache
+	}
+
+	workspaceFiles := func(t *testing.T, workDir string) (files []string) {
+		err := filepath.Walk(workDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			relPath, err := filepath.Rel(workDir, path)
+			files = append(files, filepath.ToSlash(relPath))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(files)
+		return
+	}
+
+	t.Run("fetch and checkout only included files", func(t *testing.T) {
+		art, remote, workDir, ch := setupSparseTest(t)
+		arts := map[string]*artifact.Artifact{art.Path: &art}
+		if err := ch.Fetch(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+		// Two directory manifests and the five files in foo/bar.
+		if found := objectChecksums(t, ch); len(found) != 7 {
+			t.Fatalf("expected 7 objects fetched, got %d: %v", len(found), found)
+		}
+
+		if err := ch.Checkout(workDir, art, strategy.LinkStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			"foo/bar/4.txt",
+			"foo/bar/5.txt",
+			"foo/bar/6.txt",
+			"foo/bar/7.txt",
+			"foo/bar/8.txt",
+		}
+		if diff := cmp.Diff(want, workspaceFiles(t, workDir)); diff != "" {
+			t.Fatalf("workspace files -want +got:\n%s", diff)
+		}
+
+		status, err := ch.Status(workDir, art, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !status.ContentsMatch {
+			t.Fatalf("expected sparse checkout to be up-to-date, got %s", status)
+		}
+		if !status.ChildrenStatus["1.txt"].Sparse {
+			t.Fatalf("expected foo/1.txt to be sparse, got %s", status.ChildrenStatus["1.txt"])
+		}
+		if status.ChildrenStatus["bar"].Sparse {
+			t.Fatal("expected foo/bar not to be sparse")
+		}
+	})
+
+	t.Run("commit keeps excluded files", func(t *testing.T) {
+		art, remote, workDir, ch := setupSparseTest(t)
+		arts := map[string]*artifact.Artifact{art.Path: &art}
+		if err := ch.Fetch(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Checkout(workDir, art, strategy.CopyStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		newFile := filepath.Join(workDir, "foo", "bar", "9.txt")
+		if err := os.WriteFile(newFile, []byte("9"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		oldChecksum := art.Checksum
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		if art.Checksum == oldChecksum {
+			t.Fatal("expected commit to change the checksum")
+		}
+		man, err := readDirManifest(objectPath(t, ch, art.Checksum))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// foo/1.txt through foo/5.txt, and foo/bar.
+		if len(man.Contents) != 6 {
+			t.Fatalf("expected excluded files in manifest, got %v", man.Contents)
+		}
+
+		// Pushing doesn't need the excluded files, and the result can be
+		// fetched in full.
+		if err := ch.Push(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+		fullCache, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fullCache.Fetch(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+		fullWorkDir := t.TempDir()
+		if err := fullCache.Checkout(fullWorkDir, art, strategy.CopyStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		if files := workspaceFiles(t, fullWorkDir); len(files) != 11 {
+			t.Fatalf("expected 11 files in full checkout, got %v", files)
+		}
+	})
+
+	t.Run("push includes excluded files in the cache", func(t *testing.T) {
+		dirs, art, fullCache := setupDirTest(t)
+		defer os.RemoveAll(dirs.CacheDir)
+		defer os.RemoveAll(dirs.WorkDir)
+		if err := fullCache.Commit(dirs.WorkDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		patterns, err := glob.CompileAll([]string{"foo/bar/**"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ch, err := NewLocalCache(dirs.CacheDir, WithSparse(patterns))
+		if err != nil {
+			t.Fatal(err)
+		}
+		remote, err := newFileRemote(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		arts := map[string]*artifact.Artifact{art.Path: &art}
+		if err := ch.Push(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+
+		fetchCache, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fetchCache.Fetch(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(objectChecksums(t, fullCache), objectChecksums(t, fetchCache)); diff != "" {
+			t.Fatalf("fetched objects -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("excluded artifacts are skipped", func(t *testing.T) {
+		art, remote, workDir, ch := setupSparseTest(t)
+		fileArt := artifact.Artifact{Path: "other.txt", Checksum: art.Checksum}
+		arts := map[string]*artifact.Artifact{fileArt.Path: &fileArt}
+		if err := ch.Fetch(remote, arts); err != nil {
+			t.Fatal(err)
+		}
+		if found := objectChecksums(t, ch); len(found) != 0 {
+			t.Fatalf("expected no objects fetched, got %v", found)
+		}
+		if err := ch.Checkout(workDir, fileArt, strategy.CopyStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		status, err := ch.Status(workDir, fileArt, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !status.Sparse {
+			t.Fatalf("expected sparse status, got %s", status)
+		}
+		if err := ch.Commit(workDir, &fileArt, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		if fileArt.Checksum != art.Checksum {
+			t.Fatalf("expected commit to keep checksum, got %s", fileArt.Checksum)
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kevin-hanselman/dud/src/checksum"
+)
+
+// A StatCache remembers the checksums of workspace files along with the
+// metadata the files had when they were checksummed, much like Git's index.
+// As long as a file's metadata is unchanged, its contents are assumed to be
+// unchanged as well, so the file needn't be read again. A StatCache is safe
+// for concurrent use.
+type StatCache struct {
+	path    string
+	mutex   sync.Mutex
+	entries map[string]statEntry
+	dirty   bool
+}
+
+// statEntry holds the checksum of a file and the file's metadata when the
+// checksum was computed.
+type statEntry struct {
+	Inode      uint64 `json:"inode,omitempty"`
+	Size       int64  `json:"size"`
+	ModTime    int64  `json:"mtime"`
+	ChangeTime int64  `json:"ctime,omitempty"`
+	Checksum   string `json:"checksum"`
+}
+
+// racyWindow is how recently a file may have been modified to be left out of
+// the StatCache. The file could be modified again without its modification
+// time changing (Git calls such files "racily clean").
+const racyWindow = 2 * time.Second
+
+// OpenStatCache loads the StatCache saved at path. If there's no file at
+// path, or the file is unreadable, the StatCache starts out empty.
+func OpenStatCache(path string) (*StatCache, error) {
+	stats := &StatCache{path: path, entries: make(map[string]statEntry)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &stats.entries); err != nil {
+		// The StatCache is only a cache; start over.
+		stats.entries = make(map[string]statEntry)
+		stats.dirty = true
+	}
+	return stats, nil
+}
+
+func newStatEntry(info fs.FileInfo, cksum string) statEntry {
+	inode, changeTime := fileIdentity(info)
+	return statEntry{
+		Inode:      inode,
+		Size:       info.Size(),
+		ModTime:    info.ModTime().UnixNano(),
+		ChangeTime: changeTime,
+		Checksum:   cksum,
+	}
+}
+
+// Lookup returns the checksum recorded for the file at path, if the file
+// still has the given metadata.
+func (stats *StatCache) Lookup(path string, info fs.FileInfo) (string, bool) {
+	if stats == nil {
+		return "", false
+	}
+	stats.mutex.Lock()
+	entry, ok := stats.entries[path]
+	stats.mutex.Unlock()
+	if !ok {
+		return "", false
+	}
+	cksum := entry.Checksum
+	entry.Checksum = ""
+	if entry != newStatEntry(info, "") {
+		return "", false
+	}
+	return cksum, true
+}
+
+// Record records the checksum of the file at path, which has the given
+// metadata. Files modified too recently to be trusted aren't recorded.
+func (stats *StatCache) Record(path string, info fs.FileInfo, cksum string) {
+	if stats == nil || info == nil {
+		return
+	}
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	oldEntry, ok := stats.entries[path]
+	if time.Since(info.ModTime()) < racyWindow {
+		if ok {
+			delete(stats.entries, path)
+			stats.dirty = true
+		}
+		return
+	}
+	newEntry := newStatEntry(info, cksum)
+	if !ok || oldEntry != newEntry {
+		stats.entries[path] = newEntry
+		stats.dirty = true
+	}
+}
+
+// Save writes the StatCache back to its file, if it was modified. Entries
+// for files that no longer exist are dropped.
+func (stats *StatCache) Save() error {
+	if stats == nil {
+		return nil
+	}
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	if !stats.dirty {
+		return nil
+	}
+	for path := range stats.entries {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			delete(stats.entries, path)
+		}
+	}
+	data, err := json.Marshal(stats.entries)
+	if err != nil {
+		return err
+	}
+	// Write a temporary file and rename it so concurrent readers never see
+	// a partial file.
+	tempFile, err := os.CreateTemp(filepath.Dir(stats.path), filepath.Base(stats.path))
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(data)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), stats.path)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	stats.dirty = false
+	return nil
+}
+
+// statChecksum returns the checksum recorded in ch's StatCache for the file
+// at workPath, if the file hasn't changed since and the checksum was computed
+// with ch's hash algorithm. Unless skipCache is true, the file's object must
+// also be in the cache.
+func (ch LocalCache) statChecksum(workPath string, info fs.FileInfo, skipCache bool) (string, bool) {
+	cksum, ok := ch.stats.Lookup(workPath, info)
+	if !ok {
+		return "", false
+	}
+	if algo, _, err := checksum.Parse(cksum); err != nil || algo != ch.hash {
+		return "", false
+	}
+	if !skipCache {
+		if _, _, err := ch.findObject(cksum); err != nil {
+			return "", false
+		}
+	}
+	return cksum, true
+}
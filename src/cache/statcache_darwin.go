@@ -0,0 +1,15 @@
+package cache
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileIdentity returns the inode number and change time (in nanoseconds) of
+// the file described by info, if available.
+func fileIdentity(info fs.FileInfo) (inode uint64, changeTime int64) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Ino, stat.Ctimespec.Nano()
+	}
+	return 0, 0
+}
@@ -0,0 +1,236 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/strategy"
+	"github.com/kevin-hanselman/dud/src/testutil"
+)
+
+func TestStatCache(t *testing.T) {
+	oldInfo := testutil.MockFileInfo{
+		MockName:    "file.txt",
+		MockSize:    100,
+		MockModTime: time.Now().Add(-time.Hour),
+	}
+
+	t.Run("lookup requires matching metadata", func(t *testing.T) {
+		stats, err := OpenStatCache(filepath.Join(t.TempDir(), "stat-cache"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats.Record("file.txt", oldInfo, "abc")
+		if cksum, ok := stats.Lookup("file.txt", oldInfo); !ok || cksum != "abc" {
+			t.Fatalf("Lookup = %#v, %v, want \"abc\", true", cksum, ok)
+		}
+		changed := oldInfo
+		changed.MockSize++
+		if _, ok := stats.Lookup("file.txt", changed); ok {
+			t.Fatal("expected changed size to miss")
+		}
+		changed = oldInfo
+		changed.MockModTime = changed.MockModTime.Add(time.Nanosecond)
+		if _, ok := stats.Lookup("file.txt", changed); ok {
+			t.Fatal("expected changed modification time to miss")
+		}
+		if _, ok := stats.Lookup("other.txt", oldInfo); ok {
+			t.Fatal("expected unknown path to miss")
+		}
+	})
+
+	t.Run("recently modified files aren't recorded", func(t *testing.T) {
+		stats, err := OpenStatCache(filepath.Join(t.TempDir(), "stat-cache"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats.Record("file.txt", oldInfo, "abc")
+		newInfo := oldInfo
+		newInfo.MockModTime = time.Now()
+		stats.Record("file.txt", newInfo, "def")
+		if _, ok := stats.Lookup("file.txt", newInfo); ok {
+			t.Fatal("expected racily clean file to miss")
+		}
+		if _, ok := stats.Lookup("file.txt", oldInfo); ok {
+			t.Fatal("expected old entry to be removed")
+		}
+	})
+
+	t.Run("save and reopen", func(t *testing.T) {
+		workDir := t.TempDir()
+		filePath := filepath.Join(workDir, "file.txt")
+		if err := os.WriteFile(filePath, []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		statPath := filepath.Join(t.TempDir(), "stat-cache")
+		stats, err := OpenStatCache(statPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stats.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(statPath); !os.IsNotExist(err) {
+			t.Fatalf("expected unmodified StatCache not to be saved, got %v", err)
+		}
+		stats.Record(filePath, oldInfo, "abc")
+		stats.Record(filepath.Join(workDir, "deleted.txt"), oldInfo, "def")
+		if err := stats.Save(); err != nil {
+			t.Fatal(err)
+		}
+		reopened, err := OpenStatCache(statPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cksum, ok := reopened.Lookup(filePath, oldInfo); !ok || cksum != "abc" {
+			t.Fatalf("Lookup = %#v, %v, want \"abc\", true", cksum, ok)
+		}
+		if len(reopened.entries) != 1 {
+			t.Fatalf("expected entries for missing files to be dropped, got %v", reopened.entries)
+		}
+	})
+
+	t.Run("corrupt files are ignored", func(t *testing.T) {
+		statPath := filepath.Join(t.TempDir(), "stat-cache")
+		if err := os.WriteFile(statPath, []byte("not json"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		stats, err := OpenStatCache(statPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats.entries) != 0 {
+			t.Fatalf("expected empty StatCache, got %v", stats.entries)
+		}
+	})
+}
+
+func TestStatCacheIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	// setupStatTest commits an old file, so the StatCache records it.
+	setupStatTest := func(t *testing.T, skipCache bool) (string, LocalCache, *StatCache, artifact.Artifact) {
+		workDir := t.TempDir()
+		stats, err := OpenStatCache(filepath.Join(t.TempDir(), "stat-cache"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ch, err := NewLocalCache(t.TempDir(), WithStatCache(stats))
+		if err != nil {
+			t.Fatal(err)
+		}
+		art := artifact.Artifact{Path: "file.txt", SkipCache: skipCache}
+		workPath := filepath.Join(workDir, art.Path)
+		if err := os.WriteFile(workPath, []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(workPath, old, old); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(workPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cksum, ok := stats.Lookup(workPath, info); !ok || cksum != art.Checksum {
+			t.Fatalf("Lookup = %#v, %v, want %#v, true", cksum, ok, art.Checksum)
+		}
+		return workDir, ch, stats, art
+	}
+
+	// forgeEntry records a wrong checksum for the file, which is only used if
+	// the file isn't read.
+	forgeEntry := func(stats *StatCache, workPath, cksum string) {
+		entry := stats.entries[workPath]
+		entry.Checksum = cksum
+		stats.entries[workPath] = entry
+	}
+
+	for _, skipCache := range []bool{false, true} {
+		name := "cached file"
+		if skipCache {
+			name = "skip-cache file"
+		}
+
+		t.Run(name+" status trusts unchanged metadata", func(t *testing.T) {
+			workDir, ch, stats, art := setupStatTest(t, skipCache)
+			workPath := filepath.Join(workDir, art.Path)
+			status, err := ch.Status(workDir, art, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !status.ContentsMatch {
+				t.Fatalf("expected up-to-date status, got %s", status)
+			}
+
+			forged, err := ch.checksum(strings.NewReader("forged"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			forgeEntry(stats, workPath, forged)
+			forgedArt := art
+			forgedArt.Checksum = forged
+			status, err = ch.Status(workDir, forgedArt, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Cached files are only trusted if their objects exist.
+			if status.ContentsMatch != skipCache {
+				t.Fatalf("ContentsMatch = %v, want %v", status.ContentsMatch, skipCache)
+			}
+
+			// A mismatched checksum is no proof the file changed, so the file is
+			// read.
+			status, err = ch.Status(workDir, art, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !status.ContentsMatch {
+				t.Fatalf("expected status to read the file, got %s", status)
+			}
+		})
+
+		t.Run(name+" status reads changed files", func(t *testing.T) {
+			workDir, ch, _, art := setupStatTest(t, skipCache)
+			workPath := filepath.Join(workDir, art.Path)
+			if err := os.WriteFile(workPath, []byte("DATA"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			status, err := ch.Status(workDir, art, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.ContentsMatch {
+				t.Fatal("expected modified status")
+			}
+		})
+	}
+
+	t.Run("commit trusts unchanged metadata", func(t *testing.T) {
+		workDir, ch, stats, art := setupStatTest(t, true)
+		workPath := filepath.Join(workDir, art.Path)
+		forged, err := ch.checksum(strings.NewReader("forged"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		forgeEntry(stats, workPath, forged)
+		if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		if art.Checksum != forged {
+			t.Fatalf("expected commit to trust the StatCache, got %#v", art.Checksum)
+		}
+	})
+}
@@ -17,47 +17,68 @@ import (
 // true, Status will exit as soon as the overall state of the Artifact is known
 // -- saving time, but potentially leaving artifact.Status.ChildrenStatus
 // incomplete. If shortCircuit is false, Status will fully populate
-// artifact.Status.ChildrenStatus.
+// artifact.Status.ChildrenStatus. In a sparse checkout, files excluded from
+// the checkout that are missing from the workspace are reported as sparse
+// (see WithSparse).
 func (ch LocalCache) Status(workspaceDir string, art artifact.Artifact, shortCircuit bool) (
 	status artifact.Status,
 	err error,
 ) {
+	if art.IsGlob() {
+		baseArt, pattern, err := globBase(art)
+		if err != nil {
+			return status, errors.Wrapf(err, "status %s", art.Path)
+		}
+		ch.include = pattern
+		status, err = ch.Status(workspaceDir, baseArt, shortCircuit)
+		status.Artifact = art
+		return status, err
+	}
+	ch.sparseRoot = workspaceDir
+	ch.artifactDir = filepath.Join(workspaceDir, art.Path)
+	if err := ch.loadIgnore(art); err != nil {
+		return status, errors.Wrapf(err, "status %s", art.Path)
+	}
 	if art.IsDir {
-		activeSharedWorkers := make(chan struct{}, maxSharedWorkers)
 		status, err = dirArtifactStatus(
 			context.Background(),
 			ch,
 			workspaceDir,
 			art,
 			shortCircuit,
-			activeSharedWorkers,
+			ch.sharedWorkers,
 		)
+	} else if art.IsLink {
+		status, err = symlinkArtifactStatus(ch, workspaceDir, art)
 	} else {
 		status, err = fileArtifactStatus(ch, workspaceDir, art)
 	}
+	if err == nil && status.ChecksumInCache {
+		// Access records only inform Prune, so failing to write one (e.g. in
+		// a read-only shared cache) shouldn't fail the status check.
+		_ = ch.recordAccess(art)
+	}
 	err = errors.Wrapf(err, "status %s", art.Path)
 	return
 }
 
 // checksumStatus populates the HasChecksum and ChecksumInCache fields of
-// artifact.Status and returns any relevant cache file information.
+// artifact.Status and returns any relevant cache file information. cachePath
+// is the location of the object in the cache, which may be compressed (see
+// findObject).
 func checksumStatus(ch LocalCache, art artifact.Artifact) (
 	status artifact.Status,
 	cachePath string,
 	cacheFileInfo fs.FileInfo,
 	err error,
 ) {
-	cachePath, err = ch.PathForChecksum(art.Checksum)
+	cachePath, cacheFileInfo, err = ch.findObject(art.Checksum)
 	if _, ok := err.(InvalidChecksumError); ok {
 		err = nil
 		status.HasChecksum = false
 		return
 	}
-	if err != nil {
-		return
-	}
 	status.HasChecksum = true
-	cacheFileInfo, err = os.Stat(filepath.Join(ch.dir, cachePath))
 	if err == nil {
 		status.ChecksumInCache = true
 	} else if os.IsNotExist(err) {
@@ -69,9 +90,9 @@ func checksumStatus(ch LocalCache, art artifact.Artifact) (
 
 // quickStatus populates all artifact.Status fields except for ContentsMatch
 // and ChildrenStatus. However, this function will set ContentsMatch if the
-// Artifact is a file, the workspace file is a link, and the other status
-// booleans are true. Checking to see if a link points to the cache is, as this
-// function suggests, quick.
+// Artifact is a file, the workspace file is a symlink or hard link to the
+// file in the cache, and the other status booleans are true. Checking to see
+// if a link points to the cache is, as this function suggests, quick.
 var quickStatus = func(
 	ch LocalCache,
 	workspaceDir string,
@@ -92,9 +113,9 @@ var quickStatus = func(
 	if err != nil {
 		return
 	}
-	if status.HasChecksum &&
-		status.ChecksumInCache &&
-		status.WorkspaceFileStatus == fsutil.StatusLink {
+	isLinkCandidate := status.WorkspaceFileStatus == fsutil.StatusLink ||
+		(status.WorkspaceFileStatus == fsutil.StatusRegularFile && !art.IsDir)
+	if status.HasChecksum && status.ChecksumInCache && isLinkCandidate {
 		workFileInfo, err = os.Stat(workPath)
 		// A NotExist error here means the link is dead. Leave ContentsMatch as
 		// false and let the caller handle the invalid link.
@@ -104,7 +125,29 @@ var quickStatus = func(
 		} else if err != nil {
 			return
 		}
+		// Chunked files are linked to their reassembled copy, not their chunk
+		// manifest.
+		if art.IsChunked {
+			var reconstructedPath string
+			reconstructedPath, err = ch.reconstructedPath(art.Checksum)
+			if err != nil {
+				return
+			}
+			cacheFileInfo, err = os.Stat(reconstructedPath)
+			if os.IsNotExist(err) {
+				err = nil
+				return
+			} else if err != nil {
+				return
+			}
+		}
 		status.ContentsMatch = os.SameFile(cacheFileInfo, workFileInfo)
+		// Links share the mode of their object, which may not match the
+		// Artifact's (see checkoutFile).
+		if status.ContentsMatch && trackExecutable && isExecutable(workFileInfo) != art.Executable {
+			status.ContentsMatch = false
+			status.ModeChanged = true
+		}
 	}
 	return
 }
@@ -120,11 +163,33 @@ func fileArtifactStatus(
 	}
 	cachePath = filepath.Join(ch.dir, cachePath)
 
-	if status.WorkspaceFileStatus != fsutil.StatusRegularFile {
+	if ch.isSparse(status, workPath) {
+		return sparseStatus(status), nil
+	}
+
+	// Hard links to the cache are up-to-date by definition.
+	if status.WorkspaceFileStatus != fsutil.StatusRegularFile || status.ContentsMatch {
 		return status, nil
 	}
 
-	if art.SkipCache {
+	// A file that matched the Artifact still does if its metadata hasn't
+	// changed (see WithStatCache). Chunked files are checksummed by their
+	// chunk manifests, which are never recorded.
+	var fileInfo fs.FileInfo
+	statMatch := false
+	if ch.stats != nil && status.HasChecksum && !art.IsChunked &&
+		(art.SkipCache || status.ChecksumInCache) {
+		fileInfo, err = os.Stat(workPath)
+		if err != nil {
+			return status, err
+		}
+		cksum, ok := ch.stats.Lookup(workPath, fileInfo)
+		statMatch = ok && cksum == art.Checksum
+	}
+
+	if statMatch {
+		status.ContentsMatch = true
+	} else if art.SkipCache {
 		if !status.HasChecksum {
 			return status, nil
 		}
@@ -133,19 +198,35 @@ func fileArtifactStatus(
 			return status, err
 		}
 		defer fileReader.Close()
-		workspaceFileChecksum, err := checksum.Checksum(fileReader)
+		status.ContentsMatch, _, err = checksum.Verify(fileReader, art.Checksum)
 		if err != nil {
 			return status, err
 		}
-		status.ContentsMatch = workspaceFileChecksum == art.Checksum
 	} else {
 		if !status.ChecksumInCache {
 			return status, nil
 		}
-		status.ContentsMatch, err = fsutil.SameContents(workPath, cachePath)
+		if art.IsChunked {
+			status.ContentsMatch, err = ch.sameContentsAsChunkedFile(workPath, cachePath)
+		} else {
+			status.ContentsMatch, err = sameContentsAsObject(workPath, cachePath)
+		}
+		if err != nil {
+			return status, err
+		}
+	}
+	if status.ContentsMatch && !statMatch {
+		ch.stats.Record(workPath, fileInfo, art.Checksum)
+	}
+	if status.ContentsMatch && trackExecutable {
+		info, err := os.Stat(workPath)
 		if err != nil {
 			return status, err
 		}
+		if isExecutable(info) != art.Executable {
+			status.ContentsMatch = false
+			status.ModeChanged = true
+		}
 	}
 	return status, nil
 }
@@ -164,6 +245,10 @@ func dirArtifactStatus(
 	}
 	cachePath = filepath.Join(ch.dir, cachePath)
 
+	if ch.isSparse(status, workPath) {
+		return sparseStatus(status), nil
+	}
+
 	if !(status.HasChecksum && status.ChecksumInCache) && shortCircuit {
 		return status, nil
 	}
@@ -185,11 +270,11 @@ func dirArtifactStatus(
 			return status, err
 		}
 
-		children := make([]*artifact.Artifact, len(manifest.Contents))
-		i := 0
+		children := make([]*artifact.Artifact, 0, len(manifest.Contents))
 		for _, art := range manifest.Contents {
-			children[i] = art
-			i++
+			if !ch.isIgnored(filepath.Join(workPath, art.Path), art.IsDir) {
+				children = append(children, art)
+			}
 		}
 
 		err = concurrentStatus(
@@ -214,6 +299,7 @@ func dirArtifactStatus(
 	if err != nil {
 		return status, err
 	}
+	entries = ch.filterIgnored(workPath, entries)
 	children := make([]*artifact.Artifact, 0, len(entries))
 
 	for _, entry := range entries {
@@ -248,6 +334,22 @@ func dirArtifactStatus(
 	return status, err
 }
 
+// isSparse returns true if the Artifact with the given status is intentionally
+// missing from a sparse checkout.
+func (ch LocalCache) isSparse(status artifact.Status, workPath string) bool {
+	return status.WorkspaceFileStatus == fsutil.StatusAbsent &&
+		status.HasChecksum &&
+		ch.isExcludedWorkPath(workPath, status.IsDir)
+}
+
+// sparseStatus marks status as sparse. Sparse Artifacts are as expected, so
+// they don't make their parent directories out-of-date.
+func sparseStatus(status artifact.Status) artifact.Status {
+	status.Sparse = true
+	status.ContentsMatch = true
+	return status
+}
+
 type shortCircuited struct{}
 
 func (c shortCircuited) Error() string {
@@ -398,6 +500,8 @@ func statusWorker(
 				shortCircuit,
 				activeSharedWorkers,
 			)
+		} else if art.IsLink {
+			st, err = symlinkArtifactStatus(ch, workspaceDir, *art)
 		} else {
 			st, err = fileArtifactStatus(ch, workspaceDir, *art)
 		}
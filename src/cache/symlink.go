@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/fsutil"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+// Symlinks inside directory Artifacts are tracked as Artifacts with IsLink
+// set. The object of such an Artifact holds the symlink's target, with
+// slashes as path separators. Symlinks to the cache are not tracked as
+// symlinks; they are files checked out with a link strategy.
+
+// isTrackedSymlink returns true if the symlink at workPath should be tracked
+// as a symlink, i.e. it doesn't point into the cache.
+func (ch LocalCache) isTrackedSymlink(workPath string) (bool, error) {
+	target, err := os.Readlink(workPath)
+	if err != nil {
+		return false, err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(workPath), target)
+	}
+	return !isInside(ch.dir, target), nil
+}
+
+// isInside returns true if path is dir or is inside dir. Both paths must be
+// absolute or relative to the same directory.
+func isInside(dir, path string) bool {
+	relPath, err := filepath.Rel(dir, path)
+	return err == nil &&
+		relPath != ".." &&
+		!strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
+
+// checkSymlinkTarget returns an error if the symlink at workPath with the
+// given target is absolute or points outside of the directory Artifact being
+// committed or checked out (see LocalCache.artifactDir).
+func (ch LocalCache) checkSymlinkTarget(workPath, target string) error {
+	if filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return fmt.Errorf("%s: symlink target %#v must be a relative path", workPath, target)
+	}
+	resolved := filepath.Join(filepath.Dir(workPath), target)
+	if !isInside(ch.artifactDir, resolved) {
+		return fmt.Errorf(
+			"%s: symlink target %#v is outside of %s",
+			workPath,
+			target,
+			ch.artifactDir,
+		)
+	}
+	return nil
+}
+
+// commitSymlink commits the symlink at art.Path in workspaceDir, storing its
+// target in the cache.
+func commitSymlink(ch LocalCache, workspaceDir string, art *artifact.Artifact) error {
+	workPath := filepath.Join(workspaceDir, art.Path)
+	target, err := os.Readlink(workPath)
+	if err != nil {
+		return err
+	}
+	if err := ch.checkSymlinkTarget(workPath, target); err != nil {
+		return err
+	}
+	cksum, err := ch.commitBytes(strings.NewReader(filepath.ToSlash(target)), "")
+	if err != nil {
+		return err
+	}
+	art.Checksum = cksum
+	art.IsLink = true
+	return nil
+}
+
+// readSymlinkObject returns the symlink target stored in the object at
+// cachePath, using the platform's path separators.
+func readSymlinkObject(cachePath string) (string, error) {
+	reader, err := openObject(cachePath)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	target, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return filepath.FromSlash(string(target)), nil
+}
+
+// checkoutSymlink creates the symlink described by art in workspaceDir. If
+// the symlink already exists with the correct target, there's nothing to do.
+func checkoutSymlink(
+	ch LocalCache,
+	workspaceDir string,
+	art artifact.Artifact,
+	strat strategy.CheckoutStrategy,
+	progress *pb.ProgressBar,
+) error {
+	status, cachePath, _, err := checksumStatus(ch, art)
+	if err != nil {
+		return err
+	}
+	if !status.HasChecksum {
+		return InvalidChecksumError{art.Checksum}
+	}
+	if !status.ChecksumInCache {
+		return MissingFromCacheError{art.Checksum}
+	}
+	// See checkoutFile.
+	if strat.IsLink() && progress != nil {
+		defer progress.Increment()
+	}
+	target, err := readSymlinkObject(filepath.Join(ch.dir, cachePath))
+	if err != nil {
+		return err
+	}
+	workPath := filepath.Join(workspaceDir, art.Path)
+	// Don't trust the manifest to contain only safe symlinks.
+	if err := ch.checkSymlinkTarget(workPath, target); err != nil {
+		return err
+	}
+	if currentTarget, err := os.Readlink(workPath); err == nil {
+		if currentTarget == target {
+			return nil
+		}
+		// Replace symlinks with stale targets.
+		if err := os.Remove(workPath); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(target, workPath)
+}
+
+// symlinkArtifactStatus returns the status of a symlink Artifact. The
+// contents of a symlink match if its target matches the committed target.
+func symlinkArtifactStatus(
+	ch LocalCache,
+	workspaceDir string,
+	art artifact.Artifact,
+) (artifact.Status, error) {
+	status, cachePath, _, err := checksumStatus(ch, art)
+	if err != nil {
+		return status, err
+	}
+	status.Artifact = art
+	workPath := filepath.Join(workspaceDir, art.Path)
+	status.WorkspaceFileStatus, err = fsutil.FileStatusFromPath(workPath)
+	if err != nil {
+		return status, err
+	}
+	if ch.isSparse(status, workPath) {
+		return sparseStatus(status), nil
+	}
+	if status.WorkspaceFileStatus != fsutil.StatusLink || !status.ChecksumInCache {
+		return status, nil
+	}
+	target, err := readSymlinkObject(filepath.Join(ch.dir, cachePath))
+	if err != nil {
+		return status, err
+	}
+	currentTarget, err := os.Readlink(workPath)
+	if err != nil {
+		return status, err
+	}
+	status.ContentsMatch = currentTarget == target
+	return status, nil
+}
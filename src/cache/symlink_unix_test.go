@@ -0,0 +1,133 @@
+//go:build !windows
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestSymlinkIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	// setupSymlinkTest creates a directory with a file, a relative symlink to
+	// the file, and an empty directory.
+	setupSymlinkTest := func(t *testing.T) (string, LocalCache, artifact.Artifact) {
+		workDir := t.TempDir()
+		ch, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirPath := filepath.Join(workDir, "data")
+		if err := os.MkdirAll(filepath.Join(dirPath, "empty"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dirPath, "file.txt"), []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("file.txt", filepath.Join(dirPath, "latest")); err != nil {
+			t.Fatal(err)
+		}
+		return workDir, ch, artifact.Artifact{Path: "data", IsDir: true}
+	}
+
+	assertStatus := func(t *testing.T, ch LocalCache, workDir string, art artifact.Artifact, child, want string) {
+		status, err := ch.Status(workDir, art, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := status.ChildrenStatus[child].String(); got != want {
+			t.Fatalf("%s status = %#v, want %#v", child, got, want)
+		}
+	}
+
+	for _, strat := range []strategy.CheckoutStrategy{strategy.CopyStrategy, strategy.LinkStrategy} {
+		t.Run("round trip with "+strat.String(), func(t *testing.T) {
+			workDir, ch, art := setupSymlinkTest(t)
+			if err := ch.Commit(workDir, &art, strat, logger); err != nil {
+				t.Fatal(err)
+			}
+			man, err := readDirManifest(objectPath(t, ch, art.Checksum))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !man.Contents["latest"].IsLink {
+				t.Fatalf("expected latest to be a symlink, got %+v", man.Contents["latest"])
+			}
+			if !man.Contents["empty"].IsDir {
+				t.Fatalf("expected empty to be a directory, got %+v", man.Contents["empty"])
+			}
+			assertStatus(t, ch, workDir, art, "latest", "up-to-date (symlink)")
+
+			dirPath := filepath.Join(workDir, art.Path)
+			if err := os.RemoveAll(dirPath); err != nil {
+				t.Fatal(err)
+			}
+			if err := ch.Checkout(workDir, art, strat, nil); err != nil {
+				t.Fatal(err)
+			}
+			target, err := os.Readlink(filepath.Join(dirPath, "latest"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target != "file.txt" {
+				t.Fatalf("symlink target = %#v, want %#v", target, "file.txt")
+			}
+			info, err := os.Stat(filepath.Join(dirPath, "empty"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.IsDir() {
+				t.Fatal("expected empty directory to be checked out")
+			}
+			status, err := ch.Status(workDir, art, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !status.ContentsMatch {
+				t.Fatalf("expected up-to-date status, got %s", status)
+			}
+		})
+	}
+
+	t.Run("status reports changed targets", func(t *testing.T) {
+		workDir, ch, art := setupSymlinkTest(t)
+		if err := ch.Commit(workDir, &art, strategy.LinkStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		linkPath := filepath.Join(workDir, art.Path, "latest")
+		if err := os.Remove(linkPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("empty", linkPath); err != nil {
+			t.Fatal(err)
+		}
+		assertStatus(t, ch, workDir, art, "latest", "modified (symlink)")
+
+		if err := ch.Checkout(workDir, art, strategy.LinkStrategy, nil); err != nil {
+			t.Fatal(err)
+		}
+		assertStatus(t, ch, workDir, art, "latest", "up-to-date (symlink)")
+	})
+
+	t.Run("commit rejects symlinks leaving the artifact", func(t *testing.T) {
+		for _, target := range []string{"../outside", "/etc/passwd", "empty/../../outside"} {
+			workDir, ch, art := setupSymlinkTest(t)
+			if err := os.Symlink(target, filepath.Join(workDir, art.Path, "bad")); err != nil {
+				t.Fatal(err)
+			}
+			if err := ch.Commit(workDir, &art, strategy.CopyStrategy, logger); err == nil {
+				t.Fatalf("expected error committing symlink to %#v", target)
+			}
+		}
+	})
+}
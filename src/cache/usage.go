@@ -0,0 +1,297 @@
+package cache
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/pkg/errors"
+)
+
+// DiskUsage describes the disk usage of one or more Artifacts.
+type DiskUsage struct {
+	// Files is the number of files in the Artifacts, counting each file in a
+	// directory Artifact.
+	Files int64 `json:"files"`
+	// LogicalBytes is the total size of the Artifacts' files when checked
+	// out, i.e. before deduplication and compression.
+	LogicalBytes int64 `json:"logical-bytes"`
+	// UniqueBytes is the total size of the cache files referenced by the
+	// Artifacts and nothing else. This is how much space removing the
+	// Artifacts from the cache would free.
+	UniqueBytes int64 `json:"unique-bytes"`
+	// SharedBytes is the total size of the cache files referenced by the
+	// Artifacts that are also referenced by others.
+	SharedBytes int64 `json:"shared-bytes"`
+	// MissingObjects is the number of objects referenced by the Artifacts
+	// that are missing from the cache. The sizes of missing files are
+	// unknown, so they aren't counted in LogicalBytes.
+	MissingObjects int64 `json:"missing-objects"`
+}
+
+func (usage *DiskUsage) add(other DiskUsage) {
+	usage.Files += other.Files
+	usage.LogicalBytes += other.LogicalBytes
+	usage.MissingObjects += other.MissingObjects
+}
+
+// GroupDiskUsage describes the disk usage of a group of Artifacts, and of
+// each Artifact in the group.
+type GroupDiskUsage struct {
+	DiskUsage
+	// Artifacts maps Artifact paths to their disk usage. UniqueBytes and
+	// SharedBytes are relative to all other Artifacts, including those in the
+	// same group.
+	Artifacts map[string]DiskUsage `json:"artifacts"`
+}
+
+// DiskUsageResult describes the disk usage of the cache, as reported by
+// LocalCache.DiskUsage.
+type DiskUsageResult struct {
+	// Groups maps the names of the groups of Artifacts given to
+	// LocalCache.DiskUsage to their disk usage. UniqueBytes and SharedBytes
+	// are relative to the other groups.
+	Groups map[string]GroupDiskUsage `json:"-"`
+	// Objects is the number of objects in the cache.
+	Objects int64 `json:"objects"`
+	// TotalBytes is the total size of all files in the cache directory,
+	// including bookkeeping and temporary files.
+	TotalBytes int64 `json:"total-bytes"`
+	// ObjectBytes is the total size of all objects in the cache, plus any
+	// reassembled copies of chunked files.
+	ObjectBytes int64 `json:"object-bytes"`
+	// ReferencedBytes is the portion of ObjectBytes referenced by any of the
+	// given Artifacts.
+	ReferencedBytes int64 `json:"referenced-bytes"`
+	// OrphanedBytes is the portion of ObjectBytes not referenced by any of
+	// the given Artifacts, i.e. what GarbageCollect would remove.
+	OrphanedBytes int64 `json:"orphaned-bytes"`
+}
+
+// DiskUsage reports how much space the given groups of Artifacts (e.g. the
+// outputs of each stage, keyed by stage path) take up in the cache, and how
+// much of it they share. Like GarbageCollect, DiskUsage walks the directory
+// manifests of directory Artifacts and the chunk lists of chunked files. The
+// logical size of compressed objects is only known after decompressing them,
+// so DiskUsage reads every compressed object referenced by the Artifacts.
+func (ch LocalCache) DiskUsage(groups map[string][]*artifact.Artifact) (
+	result DiskUsageResult,
+	err error,
+) {
+	walker := usageWalker{
+		ch:        ch,
+		cacheSize: make(map[string]int64),
+		objects:   make(map[string]string),
+		trees:     make(map[string]DiskUsage),
+		logical:   make(map[string]int64),
+	}
+	err = ch.walkObjects(func(checksum, cachePath string, info fs.FileInfo) error {
+		walker.cacheSize[checksum] += info.Size()
+		walker.objects[checksum] = cachePath
+		result.Objects++
+		result.ObjectBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return result, errors.Wrap(err, "disk usage")
+	}
+	err = walkObjectDir(
+		filepath.Join(ch.dir, reconstructionDir),
+		func(checksum, path string, info fs.FileInfo) error {
+			walker.cacheSize[checksum] += info.Size()
+			result.ObjectBytes += info.Size()
+			return nil
+		},
+	)
+	if err != nil && !os.IsNotExist(err) {
+		return result, errors.Wrap(err, "disk usage")
+	}
+	err = filepath.WalkDir(ch.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		result.TotalBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return result, errors.Wrap(err, "disk usage")
+	}
+
+	// Find the checksums referenced by each Artifact and each group, and count
+	// how many Artifacts and groups reference each checksum.
+	artRefs := make(map[string]map[string]map[string]struct{}, len(groups))
+	groupRefs := make(map[string]map[string]struct{}, len(groups))
+	artOwners := make(map[string]int)
+	groupOwners := make(map[string]int)
+	result.Groups = make(map[string]GroupDiskUsage, len(groups))
+	for name, arts := range groups {
+		group := GroupDiskUsage{Artifacts: make(map[string]DiskUsage, len(arts))}
+		artRefs[name] = make(map[string]map[string]struct{}, len(arts))
+		groupRefs[name] = make(map[string]struct{})
+		for _, art := range arts {
+			refs := make(map[string]struct{})
+			usage, err := walker.walk(*art, refs)
+			if err != nil {
+				return result, errors.Wrapf(err, "disk usage %s", art.Path)
+			}
+			group.Artifacts[art.Path] = usage
+			group.add(usage)
+			artRefs[name][art.Path] = refs
+			for checksum := range refs {
+				artOwners[checksum]++
+				groupRefs[name][checksum] = struct{}{}
+			}
+		}
+		for checksum := range groupRefs[name] {
+			groupOwners[checksum]++
+		}
+		result.Groups[name] = group
+	}
+
+	referenced := make(map[string]struct{})
+	for name, group := range result.Groups {
+		for path, usage := range group.Artifacts {
+			usage.UniqueBytes, usage.SharedBytes = walker.splitBytes(artRefs[name][path], artOwners)
+			group.Artifacts[path] = usage
+		}
+		group.UniqueBytes, group.SharedBytes = walker.splitBytes(groupRefs[name], groupOwners)
+		result.Groups[name] = group
+		for checksum := range groupRefs[name] {
+			referenced[checksum] = struct{}{}
+		}
+	}
+	for checksum := range referenced {
+		result.ReferencedBytes += walker.cacheSize[checksum]
+	}
+	result.OrphanedBytes = result.ObjectBytes - result.ReferencedBytes
+	return result, nil
+}
+
+// usageWalker walks Artifacts to find their disk usage. Results are memoized
+// by checksum, as the same files and directory trees are often shared between
+// Artifacts.
+type usageWalker struct {
+	ch LocalCache
+	// cacheSize maps checksums to the total size of the files storing them in
+	// the cache, including reassembled copies of chunked files.
+	cacheSize map[string]int64
+	// objects maps the checksums of all objects in the cache to their paths
+	// relative to the cache directory.
+	objects map[string]string
+	// trees maps the checksums of directory manifests to the disk usage of
+	// their contents (excluding UniqueBytes and SharedBytes).
+	trees map[string]DiskUsage
+	// logical maps the checksums of file objects to their logical sizes.
+	logical map[string]int64
+}
+
+// walk returns the disk usage of art, excluding UniqueBytes and SharedBytes,
+// and adds the checksum of every object it references to refs.
+func (walker usageWalker) walk(art artifact.Artifact, refs map[string]struct{}) (
+	usage DiskUsage,
+	err error,
+) {
+	if art.SkipCache || art.Checksum == "" {
+		return
+	}
+	if _, err = pathForChecksum(art.Checksum); err != nil {
+		return
+	}
+	_, seen := refs[art.Checksum]
+	refs[art.Checksum] = struct{}{}
+	cachePath, inCache := walker.objects[art.Checksum]
+	if !art.IsDir {
+		usage.Files = 1
+	}
+	if !inCache {
+		usage.MissingObjects = 1
+		return
+	}
+	objectPath := filepath.Join(walker.ch.dir, cachePath)
+	switch {
+	case art.IsDir:
+		// The contents of a directory tree seen earlier are already in refs,
+		// so we can skip walking it again.
+		if tree, ok := walker.trees[art.Checksum]; ok && seen {
+			return tree, nil
+		}
+		man, err := readDirManifest(objectPath)
+		if err != nil {
+			return usage, err
+		}
+		for _, childArt := range man.Contents {
+			childUsage, err := walker.walk(*childArt, refs)
+			if err != nil {
+				return usage, err
+			}
+			usage.add(childUsage)
+		}
+		walker.trees[art.Checksum] = usage
+	case art.IsChunked:
+		man, err := readChunkManifest(objectPath)
+		if err != nil {
+			return usage, err
+		}
+		usage.LogicalBytes = man.Size
+		for _, chunk := range man.Chunks {
+			refs[chunk.Checksum] = struct{}{}
+			if _, ok := walker.objects[chunk.Checksum]; !ok {
+				usage.MissingObjects++
+			}
+		}
+	default:
+		usage.LogicalBytes, err = walker.logicalSize(art.Checksum, objectPath)
+	}
+	return
+}
+
+// logicalSize returns the size of the file stored in the object at
+// objectPath, decompressing it if necessary.
+func (walker usageWalker) logicalSize(checksum, objectPath string) (int64, error) {
+	if size, ok := walker.logical[checksum]; ok {
+		return size, nil
+	}
+	var size int64
+	if isCompressedObject(objectPath) {
+		object, err := openObject(objectPath)
+		if err != nil {
+			return 0, err
+		}
+		size, err = io.Copy(io.Discard, object)
+		if closeErr := object.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		size = walker.cacheSize[checksum]
+	}
+	walker.logical[checksum] = size
+	return size, nil
+}
+
+// splitBytes sums the cache sizes of the checksums in refs, split into those
+// with a single owner (unique) and those with several (shared).
+func (walker usageWalker) splitBytes(refs map[string]struct{}, owners map[string]int) (
+	unique int64,
+	shared int64,
+) {
+	for checksum := range refs {
+		if owners[checksum] > 1 {
+			shared += walker.cacheSize[checksum]
+		} else {
+			unique += walker.cacheSize[checksum]
+		}
+	}
+	return
+}
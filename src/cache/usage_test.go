@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestDiskUsageIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	commitFile := func(t *testing.T, ch LocalCache, workDir, path string, contents []byte) *artifact.Artifact {
+		art := &artifact.Artifact{Path: path}
+		if err := os.WriteFile(filepath.Join(workDir, path), contents, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Commit(workDir, art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		return art
+	}
+
+	objectSize := func(t *testing.T, ch LocalCache, checksum string) int64 {
+		_, info, err := ch.findObject(checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+
+	t.Run("splits unique and shared bytes", func(t *testing.T) {
+		dirs, dirArt, ch := setupDirTest(t)
+		defer os.RemoveAll(dirs.CacheDir)
+		defer os.RemoveAll(dirs.WorkDir)
+		if err := ch.Commit(dirs.WorkDir, &dirArt, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		// This file has the same contents as foo/1.txt.
+		sharedArt := commitFile(t, ch, dirs.WorkDir, "one.txt", []byte("1"))
+		orphanArt := commitFile(t, ch, dirs.WorkDir, "orphan.txt", []byte("orphaned"))
+
+		result, err := ch.DiskUsage(map[string][]*artifact.Artifact{
+			"a.yaml": {&dirArt},
+			"b.yaml": {sharedArt},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dirStatus, err := ch.Status(dirs.WorkDir, dirArt, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The directory holds 8 unique 1-byte files, plus the manifests of
+		// foo and foo/bar.
+		manifestBytes := objectSize(t, ch, dirArt.Checksum) +
+			objectSize(t, ch, dirStatus.ChildrenStatus["bar"].Artifact.Checksum)
+		dirUsage := DiskUsage{
+			Files:        10,
+			LogicalBytes: 10,
+			UniqueBytes:  7 + manifestBytes,
+			SharedBytes:  1,
+		}
+		fileUsage := DiskUsage{
+			Files:        1,
+			LogicalBytes: 1,
+			SharedBytes:  1,
+		}
+		want := map[string]GroupDiskUsage{
+			"a.yaml": {DiskUsage: dirUsage, Artifacts: map[string]DiskUsage{"foo": dirUsage}},
+			"b.yaml": {DiskUsage: fileUsage, Artifacts: map[string]DiskUsage{"one.txt": fileUsage}},
+		}
+		if diff := cmp.Diff(want, result.Groups); diff != "" {
+			t.Fatalf("Groups -want +got:\n%s", diff)
+		}
+
+		if result.Objects != 11 {
+			t.Fatalf("expected 11 objects, got %d", result.Objects)
+		}
+		orphanBytes := objectSize(t, ch, orphanArt.Checksum)
+		if result.OrphanedBytes != orphanBytes {
+			t.Fatalf("expected %d orphaned bytes, got %d", orphanBytes, result.OrphanedBytes)
+		}
+		if result.ReferencedBytes != dirUsage.UniqueBytes+1 {
+			t.Fatalf(
+				"expected %d referenced bytes, got %d",
+				dirUsage.UniqueBytes+1,
+				result.ReferencedBytes,
+			)
+		}
+		if result.ObjectBytes != result.ReferencedBytes+result.OrphanedBytes {
+			t.Fatalf("expected object bytes to be referenced plus orphaned, got %+v", result)
+		}
+		if result.TotalBytes < result.ObjectBytes {
+			t.Fatalf("expected total bytes to include object bytes, got %+v", result)
+		}
+	})
+
+	t.Run("artifacts in the same group share bytes", func(t *testing.T) {
+		workDir := t.TempDir()
+		ch, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		artA := commitFile(t, ch, workDir, "a.txt", []byte("same"))
+		artB := commitFile(t, ch, workDir, "b.txt", []byte("same"))
+
+		result, err := ch.DiskUsage(map[string][]*artifact.Artifact{
+			"stage.yaml": {artA, artB},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		group := result.Groups["stage.yaml"]
+		want := DiskUsage{Files: 2, LogicalBytes: 8, UniqueBytes: 4}
+		if diff := cmp.Diff(want, group.DiskUsage); diff != "" {
+			t.Fatalf("group usage -want +got:\n%s", diff)
+		}
+		wantArt := DiskUsage{Files: 1, LogicalBytes: 4, SharedBytes: 4}
+		if diff := cmp.Diff(wantArt, group.Artifacts["a.txt"]); diff != "" {
+			t.Fatalf("artifact usage -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("reports the logical size of compressed objects", func(t *testing.T) {
+		workDir := t.TempDir()
+		ch, err := NewLocalCache(t.TempDir(), WithCompression())
+		if err != nil {
+			t.Fatal(err)
+		}
+		art := commitFile(t, ch, workDir, "a.txt", bytes.Repeat([]byte("a"), 10000))
+
+		result, err := ch.DiskUsage(map[string][]*artifact.Artifact{"stage.yaml": {art}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		usage := result.Groups["stage.yaml"].Artifacts["a.txt"]
+		if usage.LogicalBytes != 10000 {
+			t.Fatalf("expected 10000 logical bytes, got %d", usage.LogicalBytes)
+		}
+		if compressedSize := objectSize(t, ch, art.Checksum); usage.UniqueBytes != compressedSize {
+			t.Fatalf("expected %d unique bytes, got %d", compressedSize, usage.UniqueBytes)
+		}
+	})
+
+	t.Run("counts missing objects", func(t *testing.T) {
+		ch, err := NewLocalCache(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ghostArt := &artifact.Artifact{
+			Path:     "ghost",
+			IsDir:    true,
+			Checksum: "0000000000000000000000000000000000000000000000000000000000000000",
+		}
+		skipArt := &artifact.Artifact{Path: "skip.txt", SkipCache: true, Checksum: "1234"}
+
+		result, err := ch.DiskUsage(map[string][]*artifact.Artifact{
+			"stage.yaml": {ghostArt, skipArt},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := DiskUsage{MissingObjects: 1}
+		if diff := cmp.Diff(want, result.Groups["stage.yaml"].DiskUsage); diff != "" {
+			t.Fatalf("group usage -want +got:\n%s", diff)
+		}
+	})
+}
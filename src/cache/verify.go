@@ -0,0 +1,370 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/checksum"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// quarantineDir is the directory, relative to the cache root, to which
+// Verify moves damaged objects.
+const quarantineDir = "quarantine"
+
+// Hashing is CPU-bound, so there's little to gain from more workers than CPUs.
+var maxVerifyWorkers = runtime.NumCPU()
+
+// ObjectProblem enumerates the ways an object in the cache can be damaged.
+type ObjectProblem int
+
+const (
+	// ObjectCorrupt means the object's contents don't match its checksum.
+	ObjectCorrupt ObjectProblem = iota
+	// ObjectTruncated means the object is empty, but its checksum is not the
+	// checksum of zero bytes. This is the usual result of an interrupted
+	// transfer.
+	ObjectTruncated
+	// ObjectBadPermissions means the object is writable or is otherwise
+	// missing the read-only permissions set on commit.
+	ObjectBadPermissions
+	// ObjectInvalidManifest means a directory (or chunked file) Artifact's
+	// object could not be read as a directory (or chunk) manifest.
+	ObjectInvalidManifest
+	// ObjectMissing means a directory or chunk manifest references an object
+	// that is not in the cache.
+	ObjectMissing
+)
+
+func (prob ObjectProblem) String() string {
+	return [...]string{
+		"corrupt",
+		"truncated",
+		"bad permissions",
+		"invalid manifest",
+		"missing",
+	}[prob]
+}
+
+// VerifyResult describes a damaged object found by Verify.
+type VerifyResult struct {
+	// Checksum identifies the damaged object.
+	Checksum string
+	Problem  ObjectProblem
+	// Detail adds human-readable context to the Problem, e.g. the checksum
+	// actually found, or the Artifact referencing a missing object.
+	Detail string
+	// Quarantined is true if the object was moved to the quarantine
+	// directory.
+	Quarantined bool
+}
+
+// Verify checks the integrity of every object in the cache. Every object is
+// re-hashed to ensure it matches its checksum, and its permissions are
+// checked. Additionally, the directory manifests of the given Artifacts are
+// walked to ensure no directory references objects missing from the cache.
+//
+// If quarantine is true, corrupt and truncated objects are moved out of the
+// cache into a quarantine directory, so that a later fetch can replace
+// them.
+//
+// Results are sorted by checksum.
+func (ch LocalCache) Verify(
+	arts []*artifact.Artifact,
+	quarantine bool,
+) (results []VerifyResult, err error) {
+	objects := make(map[string]os.FileInfo)
+	err = ch.walkObjects(func(checksum, cachePath string, info os.FileInfo) error {
+		objects[checksum] = info
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "verify")
+	}
+
+	results, err = verifyObjects(ch, objects)
+	if err != nil {
+		return nil, errors.Wrap(err, "verify")
+	}
+
+	damaged := make(map[string]bool, len(results))
+	for _, result := range results {
+		if result.Problem == ObjectCorrupt || result.Problem == ObjectTruncated {
+			damaged[result.Checksum] = true
+		}
+	}
+
+	visited := make(map[string]bool)
+	for _, art := range arts {
+		if err := verifyManifests(ch, *art, objects, damaged, visited, &results); err != nil {
+			return nil, errors.Wrapf(err, "verify %s", art.Path)
+		}
+	}
+
+	if quarantine {
+		quarantined := make(map[string]bool, len(damaged))
+		for cksum := range damaged {
+			quarantined[cksum], err = ch.quarantineObject(cksum)
+			if err != nil {
+				return nil, errors.Wrap(err, "verify")
+			}
+		}
+		for i := range results {
+			results[i].Quarantined = quarantined[results[i].Checksum]
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Checksum < results[j].Checksum
+	})
+	return results, nil
+}
+
+// verifyObjects re-hashes the given objects concurrently and checks their
+// permissions.
+func verifyObjects(ch LocalCache, objects map[string]os.FileInfo) ([]VerifyResult, error) {
+	progress := newProgress(progressTemplateCount, len(objects), "Verifying objects")
+	progress.Start()
+	defer progress.Finish()
+
+	var (
+		results []VerifyResult
+		mutex   sync.Mutex
+	)
+	addResult := func(result VerifyResult) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		results = append(results, result)
+	}
+
+	errGroup, groupCtx := errgroup.WithContext(context.Background())
+	work := make(chan string)
+	errGroup.Go(func() error {
+		defer close(work)
+		for cksum := range objects {
+			select {
+			case work <- cksum:
+			case <-groupCtx.Done():
+				return groupCtx.Err()
+			}
+		}
+		return nil
+	})
+	for i := 0; i < maxVerifyWorkers; i++ {
+		errGroup.Go(func() error {
+			for cksum := range work {
+				info := objects[cksum]
+				if perms := info.Mode().Perm(); perms != cacheFilePerms {
+					addResult(VerifyResult{
+						Checksum: cksum,
+						Problem:  ObjectBadPermissions,
+						Detail:   info.Mode().Perm().String(),
+					})
+				}
+				actual, err := ch.checksumObject(cksum)
+				// Another process sharing the cache may have removed the
+				// object since we found it.
+				if os.IsNotExist(err) {
+					progress.Increment()
+					continue
+				}
+				if decodeErr, ok := err.(decompressError); ok {
+					addResult(VerifyResult{
+						Checksum: cksum,
+						Problem:  ObjectCorrupt,
+						Detail:   decodeErr.Error(),
+					})
+					progress.Increment()
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if actual != cksum {
+					result := VerifyResult{
+						Checksum: cksum,
+						Problem:  ObjectCorrupt,
+						Detail:   "found checksum " + actual,
+					}
+					if info.Size() == 0 {
+						result.Problem = ObjectTruncated
+						result.Detail = "empty file"
+					}
+					addResult(result)
+				}
+				progress.Increment()
+			}
+			return nil
+		})
+	}
+	return results, errGroup.Wait()
+}
+
+// verifyManifests recursively checks that the directory or chunk manifest of
+// art can be parsed and that all of its children or chunks are in the cache.
+func verifyManifests(
+	ch LocalCache,
+	art artifact.Artifact,
+	objects map[string]os.FileInfo,
+	damaged map[string]bool,
+	visited map[string]bool,
+	results *[]VerifyResult,
+) error {
+	if art.SkipCache || !(art.IsDir || art.IsChunked) || visited[art.Checksum] {
+		return nil
+	}
+	visited[art.Checksum] = true
+	// Missing top-level Artifacts are simply not fetched yet; only
+	// directory manifests dictate what must be in the cache. Damaged
+	// manifests are already reported.
+	if _, ok := objects[art.Checksum]; !ok || damaged[art.Checksum] {
+		return nil
+	}
+	cachePath, _, err := ch.findObject(art.Checksum)
+	if err != nil {
+		return err
+	}
+	if art.IsChunked {
+		verifyChunks(filepath.Join(ch.dir, cachePath), art, objects, results)
+		return nil
+	}
+	man, err := readDirManifest(filepath.Join(ch.dir, cachePath))
+	if err != nil {
+		*results = append(*results, VerifyResult{
+			Checksum: art.Checksum,
+			Problem:  ObjectInvalidManifest,
+			Detail:   err.Error(),
+		})
+		return nil
+	}
+	for _, childArt := range man.Contents {
+		if childArt.SkipCache {
+			continue
+		}
+		if _, ok := objects[childArt.Checksum]; !ok {
+			*results = append(*results, VerifyResult{
+				Checksum: childArt.Checksum,
+				Problem:  ObjectMissing,
+				Detail:   "referenced by " + filepath.Join(man.Path, childArt.Path),
+			})
+			continue
+		}
+		if err := verifyManifests(ch, *childArt, objects, damaged, visited, results); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyChunks checks that the chunk manifest of art, at path, can be parsed
+// and that all of its chunks are in the cache.
+func verifyChunks(
+	path string,
+	art artifact.Artifact,
+	objects map[string]os.FileInfo,
+	results *[]VerifyResult,
+) {
+	man, err := readChunkManifest(path)
+	if err != nil {
+		*results = append(*results, VerifyResult{
+			Checksum: art.Checksum,
+			Problem:  ObjectInvalidManifest,
+			Detail:   err.Error(),
+		})
+		return
+	}
+	for _, chunk := range man.Chunks {
+		if _, ok := objects[chunk.Checksum]; !ok {
+			*results = append(*results, VerifyResult{
+				Checksum: chunk.Checksum,
+				Problem:  ObjectMissing,
+				Detail:   "chunk of " + art.Path,
+			})
+		}
+	}
+}
+
+// decompressError is an error case where a compressed object could not be
+// decompressed, meaning the object is corrupt.
+type decompressError struct {
+	err error
+}
+
+func (err decompressError) Error() string {
+	return fmt.Sprintf("decompress: %v", err.err)
+}
+
+// checksumObject re-hashes the contents of the object with the given
+// checksum, using the hash algorithm that computed the checksum. If the object
+// is compressed and can't be decompressed, checksumObject returns
+// a decompressError.
+func (ch LocalCache) checksumObject(cksum string) (string, error) {
+	cachePath, _, err := ch.findObject(cksum)
+	if err != nil {
+		return "", err
+	}
+	algo, _, err := checksum.Parse(cksum)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(filepath.Join(ch.dir, cachePath))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if !isCompressedObject(cachePath) {
+		return algo.Checksum(file)
+	}
+	dec, err := newDecompressor(file)
+	if err != nil {
+		return "", decompressError{err}
+	}
+	defer dec.Close()
+	actual, err := algo.Checksum(dec)
+	if err != nil {
+		return "", decompressError{err}
+	}
+	return actual, nil
+}
+
+// quarantineObject moves the damaged object with the given checksum out of
+// the cache and into the quarantine directory, using the same relative path.
+// If another process sharing the cache has since removed the object or
+// replaced it with a healthy copy, quarantineObject leaves it alone and returns
+// false.
+func (ch LocalCache) quarantineObject(cksum string) (bool, error) {
+	unlock, err := ch.lockObject(cksum, true)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	actual, err := ch.checksumObject(cksum)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if _, ok := err.(decompressError); !ok && err != nil {
+		return false, err
+	}
+	if actual == cksum {
+		return false, nil
+	}
+	cachePath, _, err := ch.findObject(cksum)
+	if err != nil {
+		return false, err
+	}
+	dst := filepath.Join(ch.dir, quarantineDir, cachePath)
+	if err := ch.mkdirAll(filepath.Dir(dst)); err != nil {
+		return false, err
+	}
+	if err := os.Rename(filepath.Join(ch.dir, cachePath), dst); err != nil {
+		return false, err
+	}
+	return true, ch.removeLock(cksum)
+}
@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestVerifyIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	// Commit the test directory and return the checksums of a few of its
+	// files, keyed by file name.
+	setupVerifyTest := func(t *testing.T) (string, string, LocalCache, artifact.Artifact, map[string]string) {
+		dirs, art, ch := setupDirTest(t)
+		if err := ch.Commit(dirs.WorkDir, &art, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		man, err := readDirManifest(objectPath(t, ch, art.Checksum))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checksums := make(map[string]string)
+		for path, childArt := range man.Contents {
+			checksums[path] = childArt.Checksum
+		}
+		return dirs.CacheDir, dirs.WorkDir, ch, art, checksums
+	}
+
+	overwrite := func(t *testing.T, path string, contents string) {
+		if err := os.Chmod(path, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, cacheFilePerms); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("healthy cache", func(t *testing.T) {
+		cacheDir, workDir, ch, art, _ := setupVerifyTest(t)
+		defer os.RemoveAll(cacheDir)
+		defer os.RemoveAll(workDir)
+
+		results, err := ch.Verify([]*artifact.Artifact{&art}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 0 {
+			t.Fatalf("expected no problems, got %#v", results)
+		}
+	})
+
+	t.Run("detects damaged objects", func(t *testing.T) {
+		cacheDir, workDir, ch, art, checksums := setupVerifyTest(t)
+		defer os.RemoveAll(cacheDir)
+		defer os.RemoveAll(workDir)
+
+		overwrite(t, objectPath(t, ch, checksums["1.txt"]), "not 1")
+		overwrite(t, objectPath(t, ch, checksums["2.txt"]), "")
+		if err := os.Chmod(objectPath(t, ch, checksums["3.txt"]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(objectPath(t, ch, checksums["bar"])); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := ch.Verify([]*artifact.Artifact{&art}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		problems := make(map[string]ObjectProblem)
+		for _, result := range results {
+			problems[result.Checksum] = result.Problem
+			if result.Quarantined {
+				t.Fatalf("unexpected quarantine of %s", result.Checksum)
+			}
+		}
+		expected := map[string]ObjectProblem{
+			checksums["1.txt"]: ObjectCorrupt,
+			checksums["2.txt"]: ObjectTruncated,
+			checksums["3.txt"]: ObjectBadPermissions,
+			checksums["bar"]:   ObjectMissing,
+		}
+		if diff := cmp.Diff(expected, problems); diff != "" {
+			t.Fatalf("problems -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("detects invalid manifests", func(t *testing.T) {
+		cacheDir, workDir, ch, art, _ := setupVerifyTest(t)
+		defer os.RemoveAll(cacheDir)
+		defer os.RemoveAll(workDir)
+
+		// Masquerade a regular file as a directory. Its hash is valid, but it
+		// isn't a directory manifest.
+		fileArt := artifact.Artifact{Path: "foo/1.txt"}
+		if err := ch.Commit(workDir, &fileArt, strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		fileArt.IsDir = true
+
+		results, err := ch.Verify([]*artifact.Artifact{&art, &fileArt}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].Problem != ObjectInvalidManifest {
+			t.Fatalf("expected one invalid manifest, got %#v", results)
+		}
+	})
+
+	t.Run("quarantine damaged objects", func(t *testing.T) {
+		cacheDir, workDir, ch, art, checksums := setupVerifyTest(t)
+		defer os.RemoveAll(cacheDir)
+		defer os.RemoveAll(workDir)
+
+		corruptPath := objectPath(t, ch, checksums["1.txt"])
+		overwrite(t, corruptPath, "not 1")
+		badPermsPath := objectPath(t, ch, checksums["3.txt"])
+		if err := os.Chmod(badPermsPath, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := ch.Verify([]*artifact.Artifact{&art}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("expected 2 problems, got %#v", results)
+		}
+		for _, result := range results {
+			if result.Quarantined != (result.Problem == ObjectCorrupt) {
+				t.Fatalf("unexpected quarantine state: %#v", result)
+			}
+		}
+
+		if _, err := os.Stat(corruptPath); !os.IsNotExist(err) {
+			t.Fatalf("expected corrupt object to be removed from the cache, got %v", err)
+		}
+		cachePath, err := ch.PathForChecksum(checksums["1.txt"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(cacheDir, quarantineDir, cachePath)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(badPermsPath); err != nil {
+			t.Fatal(err)
+		}
+
+		// After quarantine, the missing object is reported instead.
+		results, err = ch.Verify([]*artifact.Artifact{&art}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("expected 2 problems, got %#v", results)
+		}
+		for _, result := range results {
+			if result.Checksum == checksums["1.txt"] && result.Problem != ObjectMissing {
+				t.Fatalf("expected quarantined object to be missing, got %#v", result)
+			}
+		}
+	})
+}
+
+func objectPath(t *testing.T, ch LocalCache, checksum string) string {
+	cachePath, err := ch.PathForChecksum(checksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(ch.dir, cachePath)
+}
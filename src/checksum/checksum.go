@@ -1,9 +1,14 @@
 package checksum
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
+	"fmt"
 	"hash"
 	"io"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/c2h5oh/datasize"
@@ -13,6 +18,11 @@ import (
 // DefaultBufferSize is the size of the default internal buffer used by Checksum.
 const DefaultBufferSize = 64 * datasize.KB
 
+// DefaultAlgorithm is the name of the hash algorithm used when no other is
+// specified. Checksums computed with the default algorithm are written as
+// bare hex strings, as they were before Dud supported other algorithms.
+const DefaultAlgorithm = "blake3"
+
 // Use pools to help the Go runtime save allocations and GCs. These pools drive
 // a significant reduction in memory allocations in the Checksum benchmarks and
 // result in an appreciable increase in throughput. On integration benchmarks,
@@ -28,34 +38,173 @@ var bufferPool = sync.Pool{
 	},
 }
 
-var hasherPool = sync.Pool{
-	New: func() interface{} {
-		return blake3.New()
-	},
+// An Algorithm computes checksums using a particular hash function.
+// Checksums are written as the name of the Algorithm, a colon, and the hex
+// digest (e.g. "sha256:e3b0..."), except for the DefaultAlgorithm, whose
+// checksums are bare hex digests.
+type Algorithm struct {
+	name       string
+	newHash    func() hash.Hash
+	hasherPool sync.Pool
+}
+
+var registry = make(map[string]*Algorithm)
+
+// Register makes a hash algorithm available under the given name. Names must
+// consist of lowercase letters and digits. Register panics if the name is
+// invalid or already registered; it is meant to be called from init
+// functions.
+func Register(name string, newHash func() hash.Hash) {
+	if name == "" || strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789") != "" {
+		panic(fmt.Sprintf("checksum: invalid algorithm name %#v", name))
+	}
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("checksum: algorithm %#v registered twice", name))
+	}
+	registry[name] = &Algorithm{
+		name:    name,
+		newHash: newHash,
+		hasherPool: sync.Pool{
+			New: func() interface{} {
+				return newHash()
+			},
+		},
+	}
 }
 
-func hashToHexString(h hash.Hash) string {
-	return hex.EncodeToString(h.Sum(nil))
+func init() {
+	Register(DefaultAlgorithm, func() hash.Hash { return blake3.New() })
+	Register("sha256", sha256.New)
+	Register("sha512", sha512.New)
 }
 
-// Checksum reads from reader and returns the hash of the bytes as a hex
-// string. Checksum buffers from reader internally.
-func Checksum(reader io.Reader) (string, error) {
+// Lookup returns the registered Algorithm with the given name. An empty name
+// selects the DefaultAlgorithm.
+func Lookup(name string) (*Algorithm, error) {
+	if name == "" {
+		name = DefaultAlgorithm
+	}
+	algo, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf(
+			"unknown hash algorithm %#v; expected one of: %s",
+			name,
+			strings.Join(Algorithms(), ", "),
+		)
+	}
+	return algo, nil
+}
+
+// Algorithms returns the names of all registered Algorithms, sorted.
+func Algorithms() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse splits a checksum into the Algorithm that computed it and its hex
+// digest. Checksums without an algorithm prefix were computed with the
+// DefaultAlgorithm. The DefaultAlgorithm's prefix is not allowed, so that
+// every checksum has exactly one spelling.
+func Parse(checksum string) (algo *Algorithm, digest string, err error) {
+	name, digest, hasPrefix := strings.Cut(checksum, ":")
+	if !hasPrefix {
+		return registry[DefaultAlgorithm], checksum, nil
+	}
+	if name == DefaultAlgorithm {
+		return nil, "", fmt.Errorf(
+			"invalid checksum %#v: %s checksums have no prefix",
+			checksum,
+			DefaultAlgorithm,
+		)
+	}
+	algo, err = Lookup(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid checksum %#v: %v", checksum, err)
+	}
+	return algo, digest, nil
+}
+
+// Name returns the name of the Algorithm.
+func (algo *Algorithm) Name() string {
+	return algo.name
+}
+
+// IsDefault returns true if the Algorithm is the DefaultAlgorithm.
+func (algo *Algorithm) IsDefault() bool {
+	return algo.name == DefaultAlgorithm
+}
+
+// NewHash returns a new hash.Hash computing the Algorithm's hash function. Use
+// FormatSum to convert the hash's sum to a checksum.
+func (algo *Algorithm) NewHash() hash.Hash {
+	return algo.newHash()
+}
+
+// FormatSum returns the checksum for a sum computed by the Algorithm's hash
+// function.
+func (algo *Algorithm) FormatSum(sum []byte) string {
+	return algo.format(hex.EncodeToString(sum))
+}
+
+// format returns the checksum for the given hex digest.
+func (algo *Algorithm) format(digest string) string {
+	if algo.IsDefault() {
+		return digest
+	}
+	return algo.name + ":" + digest
+}
+
+// Checksum reads from reader and returns the checksum of the bytes. Checksum
+// buffers from reader internally.
+func (algo *Algorithm) Checksum(reader io.Reader) (string, error) {
 	buffer := *bufferPool.Get().(*[]byte)
 	defer bufferPool.Put(&buffer)
-	return ChecksumBuffer(reader, buffer)
+	return algo.ChecksumBuffer(reader, buffer)
 }
 
-// ChecksumBuffer reads from reader and returns the hash of the bytes as a hex
-// string. ChecksumBuffer uses the buffer argument to buffer I/O from the
-// reader to the hasher. If the buffer passed is zero-length, this function
-// will panic.
-func ChecksumBuffer(reader io.Reader, buffer []byte) (string, error) {
-	h := hasherPool.Get().(*blake3.Hasher)
-	defer hasherPool.Put(h)
+// ChecksumBuffer reads from reader and returns the checksum of the bytes.
+// ChecksumBuffer uses the buffer argument to buffer I/O from the reader to
+// the hasher. If the buffer passed is zero-length, this function will panic.
+func (algo *Algorithm) ChecksumBuffer(reader io.Reader, buffer []byte) (string, error) {
+	h := algo.hasherPool.Get().(hash.Hash)
+	defer algo.hasherPool.Put(h)
 	h.Reset()
 	if _, err := io.CopyBuffer(h, reader, buffer); err != nil {
 		return "", err
 	}
-	return hashToHexString(h), nil
+	return algo.FormatSum(h.Sum(nil)), nil
+}
+
+// Checksum reads from reader and returns the hash of the bytes as a hex
+// string, using the DefaultAlgorithm. Checksum buffers from reader
+// internally.
+func Checksum(reader io.Reader) (string, error) {
+	return registry[DefaultAlgorithm].Checksum(reader)
+}
+
+// ChecksumBuffer reads from reader and returns the hash of the bytes as a hex
+// string, using the DefaultAlgorithm. ChecksumBuffer uses the buffer argument
+// to buffer I/O from the reader to the hasher. If the buffer passed is
+// zero-length, this function will panic.
+func ChecksumBuffer(reader io.Reader, buffer []byte) (string, error) {
+	return registry[DefaultAlgorithm].ChecksumBuffer(reader, buffer)
+}
+
+// Verify reads from reader and returns true if the bytes match the given
+// checksum, using the Algorithm that computed it. It also returns the
+// actual checksum of the bytes.
+func Verify(reader io.Reader, checksum string) (ok bool, actual string, err error) {
+	algo, _, err := Parse(checksum)
+	if err != nil {
+		return false, "", err
+	}
+	actual, err = algo.Checksum(reader)
+	if err != nil {
+		return false, "", err
+	}
+	return actual == checksum, actual, nil
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/c2h5oh/datasize"
@@ -50,3 +51,72 @@ func benchmarkChecksum(inputSize datasize.ByteSize, b *testing.B) {
 		}
 	}
 }
+
+func TestAlgorithms(t *testing.T) {
+	input := "Hello, World!"
+	tests := map[string]string{
+		"blake3": "288a86a79f20a3d6dccdca7713beaed178798296bdfa7913fa2a62d9727bf8f8",
+		"sha256": "sha256:dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f",
+	}
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			algo, err := Lookup(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := algo.Checksum(strings.NewReader(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Fatalf("Checksum(%#v) = %s, want %s", input, got, want)
+			}
+			ok, _, err := Verify(strings.NewReader(input), want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Fatalf("Verify(%#v, %s) = false, want true", input, want)
+			}
+		})
+	}
+
+	if _, err := Lookup("md5"); err == nil {
+		t.Fatal("expected error looking up unregistered algorithm")
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		checksum, algo, digest string
+		wantErr                bool
+	}{
+		{checksum: "abcdef", algo: "blake3", digest: "abcdef"},
+		{checksum: "sha256:abcdef", algo: "sha256", digest: "abcdef"},
+		{checksum: "blake3:abcdef", wantErr: true},
+		{checksum: "md5:abcdef", wantErr: true},
+	}
+	for _, test := range tests {
+		algo, digest, err := Parse(test.checksum)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%#v): expected error", test.checksum)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%#v): %v", test.checksum, err)
+			continue
+		}
+		if algo.Name() != test.algo || digest != test.digest {
+			t.Errorf(
+				"Parse(%#v) = %s, %s, want %s, %s",
+				test.checksum,
+				algo.Name(),
+				digest,
+				test.algo,
+				test.digest,
+			)
+		}
+	}
+}
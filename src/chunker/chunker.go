@@ -0,0 +1,128 @@
+// Package chunker splits streams of bytes into content-defined chunks.
+//
+// Chunk boundaries are chosen based on the bytes themselves, not their
+// offsets, so inserting or removing bytes in the middle of a stream only
+// changes the chunks near the edit. This allows large files that change
+// slightly between versions to share most of their chunks.
+//
+// The implementation is based on FastCDC: "FastCDC: a Fast and Efficient
+// Content-Defined Chunking Approach for Data Deduplication" (Xia et al., 2016).
+package chunker
+
+import (
+	"io"
+
+	"github.com/c2h5oh/datasize"
+)
+
+const (
+	// MinSize is the smallest chunk the Chunker produces, except for the last
+	// chunk of a stream.
+	MinSize = 256 * int(datasize.KB)
+	// AvgSize is the size the Chunker aims for.
+	AvgSize = 1 * int(datasize.MB)
+	// MaxSize is the largest chunk the Chunker produces.
+	MaxSize = 4 * int(datasize.MB)
+)
+
+// FastCDC's "normalized chunking" uses a harder-to-match mask before
+// AvgSize and an easier-to-match mask after it, which narrows the
+// distribution of chunk sizes around AvgSize. AvgSize is 2^20 bytes, so the
+// masks test 20+1 and 20-1 bits. Using the most significant bits of the gear
+// hash means the hash covers the last 64 bytes read.
+const (
+	maskSmall = ^uint64(1<<(64-21) - 1)
+	maskLarge = ^uint64(1<<(64-19) - 1)
+)
+
+// gear maps each byte to a pseudo-random number. The table must never change;
+// doing so would move the chunk boundaries of every file, defeating
+// deduplication with previously stored chunks.
+var gear = func() (table [256]uint64) {
+	// splitmix64, with a fixed seed.
+	state := uint64(0x6475642d63686e6b) // "dud-chnk"
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return
+}()
+
+// A Chunker splits the bytes read from an io.Reader into content-defined
+// chunks.
+type Chunker struct {
+	reader io.Reader
+	buf    []byte
+	// start and end delimit the buffered bytes not yet returned as chunks.
+	start, end int
+	eof        bool
+}
+
+// New creates a Chunker that reads from reader.
+func New(reader io.Reader) *Chunker {
+	return &Chunker{reader: reader, buf: make([]byte, MaxSize)}
+}
+
+// Next returns the next chunk. The chunk is only valid until the next call to
+// Next. After the last chunk, Next returns io.EOF. An empty stream has no
+// chunks.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	data := c.buf[c.start:c.end]
+	cut := cutPoint(data)
+	c.start += cut
+	return data[:cut], nil
+}
+
+// fill reads from the underlying reader until the buffer holds MaxSize bytes
+// or the reader is exhausted.
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start == len(c.buf) {
+		return nil
+	}
+	// Move the remaining bytes to the front of the buffer.
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+	n, err := io.ReadFull(c.reader, c.buf[c.end:])
+	c.end += n
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		c.eof = true
+		return nil
+	}
+	return err
+}
+
+// cutPoint returns the length of the first chunk in data.
+func cutPoint(data []byte) int {
+	n := len(data)
+	if n <= MinSize {
+		return n
+	}
+	normal := AvgSize
+	if n < normal {
+		normal = n
+	}
+	var hash uint64
+	i := MinSize
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&maskSmall == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&maskLarge == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,124 @@
+package chunker
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/c2h5oh/datasize"
+)
+
+// randomBytes returns n pseudo-random bytes. The same seed always yields the
+// same bytes.
+func randomBytes(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func chunks(t *testing.T, data []byte) [][]byte {
+	var out [][]byte
+	chunker := New(bytes.NewReader(data))
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Chunks are only valid until the next call to Next.
+		out = append(out, append([]byte(nil), chunk...))
+	}
+}
+
+func TestChunker(t *testing.T) {
+	t.Run("empty stream", func(t *testing.T) {
+		if got := chunks(t, nil); len(got) != 0 {
+			t.Fatalf("got %d chunks, want 0", len(got))
+		}
+	})
+
+	t.Run("small stream is one chunk", func(t *testing.T) {
+		data := []byte("hello, world")
+		got := chunks(t, data)
+		if len(got) != 1 || !bytes.Equal(got[0], data) {
+			t.Fatalf("got %d chunks, want 1", len(got))
+		}
+	})
+
+	data := randomBytes(1, 32*int(datasize.MB))
+	got := chunks(t, data)
+
+	t.Run("chunks reassemble the stream", func(t *testing.T) {
+		if !bytes.Equal(bytes.Join(got, nil), data) {
+			t.Fatal("chunks don't match input")
+		}
+	})
+
+	t.Run("chunk sizes are bounded", func(t *testing.T) {
+		for i, chunk := range got {
+			isLast := i == len(got)-1
+			if len(chunk) > MaxSize || (len(chunk) < MinSize && !isLast) {
+				t.Fatalf("chunk %d has size %d", i, len(chunk))
+			}
+		}
+		// With normalized chunking, the average should be near AvgSize.
+		avg := len(data) / len(got)
+		if avg < AvgSize/2 || avg > AvgSize*2 {
+			t.Fatalf("average chunk size %d is far from %d", avg, AvgSize)
+		}
+	})
+
+	t.Run("chunks are deterministic", func(t *testing.T) {
+		again := chunks(t, data)
+		if len(again) != len(got) {
+			t.Fatalf("got %d chunks, want %d", len(again), len(got))
+		}
+		for i := range got {
+			if !bytes.Equal(again[i], got[i]) {
+				t.Fatalf("chunk %d differs", i)
+			}
+		}
+	})
+
+	t.Run("edits only affect nearby chunks", func(t *testing.T) {
+		// Insert some bytes in the middle of the stream.
+		middle := len(data) / 2
+		edited := append([]byte(nil), data[:middle]...)
+		edited = append(edited, []byte("some inserted bytes")...)
+		edited = append(edited, data[middle:]...)
+
+		original := make(map[string]bool)
+		for _, chunk := range got {
+			original[string(chunk)] = true
+		}
+		editedChunks := chunks(t, edited)
+		changed := 0
+		for _, chunk := range editedChunks {
+			if !original[string(chunk)] {
+				changed++
+			}
+		}
+		if changed == 0 || changed > 3 {
+			t.Fatalf("%d of %d chunks changed, want 1 to 3", changed, len(editedChunks))
+		}
+	})
+}
+
+func BenchmarkChunker(b *testing.B) {
+	data := randomBytes(1, 64*int(datasize.MB))
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		chunker := New(bytes.NewReader(data))
+		for {
+			if _, err := chunker.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
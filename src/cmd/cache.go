@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Commands for maintaining the cache",
+	Long:  `Cache is a group of commands for inspecting and maintaining the cache.`,
+}
+
+var verifyQuarantine bool
+
+var verifyCacheCmd = &cobra.Command{
+	Use:   "verify [flags]",
+	Short: "Check the integrity of all objects in the cache",
+	Long: `Verify checks the integrity of all objects in the cache.
+
+Verify re-hashes every object in the cache to ensure its contents match its
+checksum, and ensures every object is read-only. Verify also walks the
+directory manifests of every stage output in the index to find files missing
+from the cache.
+
+With --quarantine, corrupt and truncated objects are moved from the cache to
+the cache's "quarantine" sub-directory. A subsequent 'dud fetch' will then
+replace them with healthy copies from the remote cache.
+
+Verify exits with a non-zero status if any problems are found.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		mode := sharedLock
+		if verifyQuarantine {
+			mode = exclusiveLock
+		}
+		_, ch, idx, err := prepare(nil, mode)
+		if err != nil {
+			fatal(err)
+		}
+
+		results, err := ch.Verify(allStageOutputs(idx), verifyQuarantine)
+		if err != nil {
+			fatal(err)
+		}
+		if len(results) == 0 {
+			logger.Info.Println("No problems found.")
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, result := range results {
+			fmt.Fprintf(writer, "%s\t%s\t%s", result.Checksum, result.Problem, result.Detail)
+			if result.Quarantined {
+				fmt.Fprint(writer, " (quarantined)")
+			}
+			fmt.Fprintln(writer)
+		}
+		writer.Flush()
+		fatal(fmt.Errorf("found %d problems in the cache", len(results)))
+	},
+}
+
+var pruneDryRun bool
+
+var pruneCacheCmd = &cobra.Command{
+	Use:   "prune [flags]",
+	Short: "Evict least recently used objects to limit the size of the cache",
+	Long: `Prune evicts the least recently used objects from the cache until the cache
+is no larger than the 'cache-max-size' set in the Dud config file (e.g. 50GB).
+
+Only objects already present on the remote cache are evicted, and objects
+referenced by the outputs of stages in the index are always kept. Evicted
+objects can be restored with 'dud fetch'. An object was last used when it was
+committed or fetched, or when an artifact containing it was last checked out or
+had its status checked. Prune may leave the cache larger than
+'cache-max-size' if not enough objects can be evicted.
+
+Use --dry-run to list what would be evicted without evicting it.`,
+	Example: "dud cache prune --dry-run",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		mode := exclusiveLock
+		if pruneDryRun {
+			mode = sharedLock
+		}
+		_, ch, idx, err := prepare(nil, mode)
+		if err != nil {
+			fatal(err)
+		}
+
+		maxSizeConfig := viper.GetString("cache-max-size")
+		if maxSizeConfig == "" {
+			fatal(errors.New("cache-max-size must be set in the config to prune the cache"))
+		}
+		var maxSize datasize.ByteSize
+		if err := maxSize.UnmarshalText([]byte(maxSizeConfig)); err != nil {
+			fatal(fmt.Errorf(
+				"invalid cache-max-size %#v in config; expected a size (e.g. 50GB)",
+				maxSizeConfig,
+			))
+		}
+
+		remote, err := newRemote()
+		if err != nil {
+			fatal(err)
+		}
+
+		result, err := ch.Prune(remote, allStageOutputs(idx), int64(maxSize.Bytes()), pruneDryRun)
+		if err != nil {
+			fatal(err)
+		}
+
+		for _, checksum := range result.Checksums {
+			logger.Debug.Println(checksum)
+		}
+		verb := "Evicted"
+		if pruneDryRun {
+			verb = "Would evict"
+		}
+		logger.Info.Printf(
+			"%s %d objects (%s); cache size is %s\n",
+			verb,
+			len(result.Checksums),
+			datasize.ByteSize(result.Bytes).HR(),
+			datasize.ByteSize(result.Size).HR(),
+		)
+	},
+}
+
+var statsJSON bool
+
+var statsCacheCmd = &cobra.Command{
+	Use:     "stats [flags] [stage_file]...",
+	Aliases: []string{"du"},
+	Short:   "Report how much space stage outputs take up in the cache",
+	Long: `Stats reports how much space the outputs of stages take up in the cache.
+
+For each stage, and each of its outputs, stats prints:
+
+  files    the number of files, including every file in directory outputs
+  logical  the size of the files when checked out
+  unique   the size of the cache objects referenced by nothing else, i.e. how
+           much space removing the stage or output from the cache would free
+  shared   the size of the cache objects also referenced by other stages (for
+           stages) or other outputs (for outputs)
+  missing  the number of referenced objects missing from the cache
+
+Stats also reports totals for the whole cache directory, including the size of
+objects not referenced by any stage in the index, which 'dud gc' would remove.
+If stage files are given, only those stages are listed, but sharing is still
+measured against all stages in the index.
+
+With --json, stats prints the same information as a JSON object.`,
+	Example: "dud cache stats --json",
+	Run: func(cmd *cobra.Command, paths []string) {
+		_, ch, idx, err := prepare(paths, sharedLock)
+		if err != nil {
+			fatal(err)
+		}
+
+		groups := make(map[string][]*artifact.Artifact, len(idx))
+		for path, stg := range idx {
+			for _, art := range stg.Outputs {
+				groups[path] = append(groups[path], art)
+			}
+		}
+		result, err := ch.DiskUsage(groups)
+		if err != nil {
+			fatal(err)
+		}
+
+		if len(paths) == 0 {
+			for path := range idx {
+				paths = append(paths, path)
+			}
+		}
+		sort.Strings(paths)
+
+		if statsJSON {
+			report := struct {
+				Stages map[string]cache.GroupDiskUsage `json:"stages"`
+				Cache  cache.DiskUsageResult           `json:"cache"`
+			}{
+				Stages: make(map[string]cache.GroupDiskUsage, len(paths)),
+				Cache:  result,
+			}
+			for _, path := range paths {
+				report.Stages[path] = result.Groups[path]
+			}
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				fatal(err)
+			}
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "\tfiles\tlogical\tunique\tshared\tmissing")
+		for _, path := range paths {
+			group := result.Groups[path]
+			writeDiskUsage(writer, path, group.DiskUsage)
+			artPaths := make([]string, 0, len(group.Artifacts))
+			for artPath := range group.Artifacts {
+				artPaths = append(artPaths, artPath)
+			}
+			sort.Strings(artPaths)
+			for _, artPath := range artPaths {
+				writeDiskUsage(writer, "  "+artPath, group.Artifacts[artPath])
+			}
+		}
+		writer.Flush()
+		fmt.Printf(
+			"\ncache: %s total, %s in %d objects, %s referenced, %s orphaned\n",
+			datasize.ByteSize(result.TotalBytes).HR(),
+			datasize.ByteSize(result.ObjectBytes).HR(),
+			result.Objects,
+			datasize.ByteSize(result.ReferencedBytes).HR(),
+			datasize.ByteSize(result.OrphanedBytes).HR(),
+		)
+	},
+}
+
+// writeDiskUsage writes a row of the 'dud cache stats' table.
+func writeDiskUsage(writer *tabwriter.Writer, name string, usage cache.DiskUsage) {
+	fmt.Fprintf(
+		writer,
+		"%s\t%d\t%s\t%s\t%s\t%d\n",
+		name,
+		usage.Files,
+		datasize.ByteSize(usage.LogicalBytes).HR(),
+		datasize.ByteSize(usage.UniqueBytes).HR(),
+		datasize.ByteSize(usage.SharedBytes).HR(),
+		usage.MissingObjects,
+	)
+}
+
+func init() {
+	statsCacheCmd.Flags().BoolVar(&statsJSON, "json", false, "print JSON instead of a table")
+	cacheCmd.AddCommand(statsCacheCmd)
+
+	pruneCacheCmd.Flags().BoolVarP(
+		&pruneDryRun,
+		"dry-run",
+		"n",
+		false,
+		"report objects to evict without evicting them",
+	)
+	cacheCmd.AddCommand(pruneCacheCmd)
+
+	verifyCacheCmd.Flags().BoolVarP(
+		&verifyQuarantine,
+		"quarantine",
+		"q",
+		false,
+		"move corrupt objects out of the cache",
+	)
+	cacheCmd.AddCommand(verifyCacheCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
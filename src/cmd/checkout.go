@@ -1,6 +1,10 @@
 package cmd
 
 import (
+	"fmt"
+
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/index"
 	"github.com/kevin-hanselman/dud/src/strategy"
 	"github.com/spf13/cobra"
 )
@@ -12,8 +16,9 @@ func init() {
 		"copy",
 		"c",
 		false,
-		"copy artifacts instead of linking",
+		"copy artifacts instead of linking (same as --strategy=copy)",
 	)
+	addStrategyFlag(checkoutCmd)
 	checkoutCmd.Flags().BoolVarP(
 		&disableRecursion,
 		"single-stage",
@@ -21,9 +26,37 @@ func init() {
 		false,
 		"disable recursive operation on upstream stages",
 	)
+	addIncludeFlag(checkoutCmd)
+	addJobsFlag(checkoutCmd, "process up to `N` artifacts at once")
 }
 
-var useCopyStrategy, disableRecursion bool
+var (
+	useCopyStrategy, disableRecursion bool
+	strategyName                      string
+)
+
+const strategyFlagUsage = "how to check out files from the cache: symlink, hardlink, reflink, or copy"
+
+// addStrategyFlag adds the --strategy flag to cmd. The --copy flag must be
+// added separately.
+func addStrategyFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&strategyName, "strategy", "symlink", strategyFlagUsage)
+}
+
+// checkoutStrategy returns the strategy selected by the --strategy and --copy
+// flags of cmd.
+func checkoutStrategy(cmd *cobra.Command) (strategy.CheckoutStrategy, error) {
+	if useCopyStrategy {
+		if cmd.Flags().Changed("strategy") && strategyName != "copy" {
+			return strategy.CopyStrategy, fmt.Errorf(
+				"--copy conflicts with --strategy=%s",
+				strategyName,
+			)
+		}
+		return strategy.CopyStrategy, nil
+	}
+	return strategy.FromName(strategyName)
+}
 
 var checkoutCmd = &cobra.Command{
 	Use:   "checkout [flags] [stage_file]...",
@@ -31,50 +64,98 @@ var checkoutCmd = &cobra.Command{
 	Long: `Checkout loads previously committed artifacts from the cache.
 
 For each stage file passed in, checkout makes the stage's output artifacts
-available in the workspace. By default, checkout creates symlinks to the cache,
-but copies of the cached artifacts can be checked out using --copy. If no
-stage files are passed in, checkout will act on all stages in the index. By
-default, checkout will act recursively on all stages upstream of the given
-stage(s).`,
+available in the workspace. If no stage files are passed in, checkout will act
+on all stages in the index. By default, checkout will act recursively on all
+stages upstream of the given stage(s).
+
+The --strategy flag controls how files are checked out from the cache:
+
+  symlink    read-only symbolic links to the cache (the default)
+  hardlink   read-only hard links to the cache; the cache and the workspace
+             must be on the same filesystem
+  reflink    copy-on-write clones of the cache on filesystems that support
+             them (e.g. Btrfs, XFS, and APFS), falling back to copying
+  copy       independent copies of the cache
+
+Hard links and reflinks suit tools that resolve or reject symlinks. Hard links
+and symlinks use no extra disk space, but the linked files are read-only.
+Reflinks use no extra disk space until the files are modified. Files committed
+with their executable bit set are always checked out as executable copies (or
+reflinks), as objects in the cache are never executable.
+
+With --jobs greater than one, checkout processes stages concurrently, with up
+to that many artifacts being checked out at once.
+
+` + sparseHelp,
 	Run: func(cmd *cobra.Command, paths []string) {
-		strat := strategy.LinkStrategy
-		if useCopyStrategy {
-			strat = strategy.CopyStrategy
+		strat, err := checkoutStrategy(cmd)
+		if err != nil {
+			fatal(err)
 		}
 
-		rootDir, ch, idx, err := prepare(paths)
+		rootDir, ch, idx, err := prepare(paths, exclusiveLock)
 		if err != nil {
 			fatal(err)
 		}
+		checkoutStages(rootDir, ch, idx, paths, strat)
+	},
+}
 
-		if len(idx) == 0 {
-			fatal(emptyIndexError{})
+// checkoutStages checks out the artifacts of the given Stages, or of all
+// Stages if none are given, with the given strategy.
+func checkoutStages(
+	rootDir string,
+	ch cache.LocalCache,
+	idx index.Index,
+	paths []string,
+	strat strategy.CheckoutStrategy,
+) {
+	if len(idx) == 0 {
+		fatal(emptyIndexError{})
+	}
+
+	if len(paths) == 0 {
+		// Ignore disableRecursion flag when no args passed.
+		disableRecursion = false
+		for path := range idx {
+			paths = append(paths, path)
 		}
+	}
+
+	if err := checkJobs(); err != nil {
+		fatal(err)
+	}
 
-		if len(paths) == 0 {
-			// Ignore disableRecursion flag when no args passed.
-			disableRecursion = false
-			for path := range idx {
-				paths = append(paths, path)
-			}
+	if numJobs > 1 {
+		if err := idx.CheckoutConcurrently(
+			paths,
+			ch,
+			rootDir,
+			strat,
+			!disableRecursion,
+			numJobs,
+			logger,
+		); err != nil {
+			fatal(err)
 		}
+		return
+	}
 
-		checkedOut := make(map[string]bool)
-		for _, path := range paths {
-			inProgress := make(map[string]bool)
-			if err := idx.Checkout(
-				path,
-				ch,
-				rootDir,
-				strat,
-				!disableRecursion,
-				checkedOut,
-				inProgress,
-				logger,
-			); err != nil {
-				fatal(err)
-			}
-			logger.Info.Println()
+	checkedOut := make(map[string]bool)
+	for _, path := range paths {
+		inProgress := make(map[string]bool)
+		if err := idx.Checkout(
+			path,
+			ch,
+			rootDir,
+			strat,
+			!disableRecursion,
+			checkedOut,
+			inProgress,
+			logger,
+		); err != nil {
+			fatal(err)
 		}
-	},
+		logger.Info.Println()
+	}
 }
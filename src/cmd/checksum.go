@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"os"
+	"strings"
 
 	"github.com/kevin-hanselman/dud/src/checksum"
 	"github.com/spf13/cobra"
@@ -10,9 +11,19 @@ import (
 func init() {
 	rootCmd.AddCommand(checksumCmd)
 	checksumCmd.Flags().IntVarP(&bufSize, "bufsize", "b", 0, "internal buffer size in bytes")
+	checksumCmd.Flags().StringVarP(
+		&checksumAlgo,
+		"algo",
+		"a",
+		checksum.DefaultAlgorithm,
+		"hash algorithm ("+strings.Join(checksum.Algorithms(), ", ")+")",
+	)
 }
 
-var bufSize int
+var (
+	bufSize      int
+	checksumAlgo string
+)
 
 var checksumCmd = &cobra.Command{
 	Use:   "checksum [flags] [file]...",
@@ -20,21 +31,27 @@ var checksumCmd = &cobra.Command{
 	Long: `Checksum reads files (or bytes from STDIN) and prints their checksums.
 
 The CLI is intended to be compatible with the *sum family of command-line tools
-(although this version is currently incomplete).`,
+(although this version is currently incomplete). Checksums are printed as Dud
+records them: checksums computed by any algorithm other than blake3 are
+prefixed with the name of the algorithm (e.g. sha256:...).`,
 	Run: func(cmd *cobra.Command, args []string) {
 		var (
 			buffer []byte = nil
 			cksum  string
 			err    error
 		)
+		algo, err := checksum.Lookup(checksumAlgo)
+		if err != nil {
+			fatal(err)
+		}
 		if bufSize > 0 {
 			buffer = make([]byte, bufSize)
 		}
 		if len(args) == 0 {
 			if buffer == nil {
-				cksum, err = checksum.Checksum(os.Stdin)
+				cksum, err = algo.Checksum(os.Stdin)
 			} else {
-				cksum, err = checksum.ChecksumBuffer(os.Stdin, buffer)
+				cksum, err = algo.ChecksumBuffer(os.Stdin, buffer)
 			}
 			if err != nil {
 				fatal(err)
@@ -49,9 +66,9 @@ The CLI is intended to be compatible with the *sum family of command-line tools
 				fatal(err)
 			}
 			if buffer == nil {
-				cksum, err = checksum.Checksum(file)
+				cksum, err = algo.Checksum(file)
 			} else {
-				cksum, err = checksum.ChecksumBuffer(file, buffer)
+				cksum, err = algo.ChecksumBuffer(file, buffer)
 			}
 			if err != nil {
 				fatal(err)
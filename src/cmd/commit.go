@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"github.com/kevin-hanselman/dud/src/strategy"
 	"github.com/spf13/cobra"
 )
 
@@ -14,6 +13,8 @@ func init() {
 		false,
 		"On checkout, copy the file instead of linking.",
 	)
+	addStrategyFlag(commitCmd) // defined in cmd/checkout.go
+	addJobsFlag(commitCmd, "process up to `N` artifacts at once")
 }
 
 var commitCmd = &cobra.Command{
@@ -24,14 +25,28 @@ var commitCmd = &cobra.Command{
 For each stage file passed in, commit saves all output artifacts in the cache
 and records their checksums in the stage file. If no stage files are passed
 in, commit will act on all stages in the index. By default, commit will act
-recursively on all stages upstream of the given stage(s).`,
+recursively on all stages upstream of the given stage(s).
+
+Symbolic links and empty directories inside directory artifacts are committed
+as-is and restored on checkout. Symbolic links must use relative targets that
+stay inside the directory artifact.
+
+After committing, commit checks out the committed files using --strategy. See
+'dud checkout --help' for the available strategies.
+
+With --jobs greater than one, commit processes independent stages
+concurrently, with up to that many artifacts being committed at once.`,
 	Run: func(cmd *cobra.Command, paths []string) {
-		strat := strategy.LinkStrategy
-		if useCopyStrategy {
-			strat = strategy.CopyStrategy
+		strat, err := checkoutStrategy(cmd)
+		if err != nil {
+			fatal(err)
 		}
 
-		rootDir, ch, idx, err := prepare(paths)
+		if err := checkJobs(); err != nil {
+			fatal(err)
+		}
+
+		rootDir, ch, idx, err := prepare(paths, exclusiveLock)
 		if err != nil {
 			fatal(err)
 		}
@@ -47,6 +62,19 @@ recursively on all stages upstream of the given stage(s).`,
 		}
 
 		committed := make(map[string]bool)
+		if numJobs > 1 {
+			err := idx.CommitConcurrently(paths, ch, rootDir, strat, numJobs, committed, logger)
+			// Save the Stages that were committed, even if others failed.
+			for path := range committed {
+				if err := idx[path].ToFile(path); err != nil {
+					fatal(err)
+				}
+			}
+			if err != nil {
+				fatal(err)
+			}
+			return
+		}
 		written := make(map[string]bool)
 		for _, path := range paths {
 			inProgress := make(map[string]bool)
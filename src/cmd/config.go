@@ -10,7 +10,18 @@ import (
 )
 
 var (
-	validFields      = []string{"cache", "remote"}
+	validFields = []string{
+		"cache",
+		"cache-group",
+		"cache-max-size",
+		"chunk-threshold",
+		"compression",
+		"encryption-key-env",
+		"encryption-key-file",
+		"hash",
+		"remote",
+		"run-log-retention",
+	}
 	targetUserConfig bool
 )
 
@@ -33,7 +44,7 @@ func init() {
 				if err != nil {
 					fatal(err)
 				}
-				if err := lockProject(rootDir); err != nil {
+				if err := lockProject(rootDir, sharedLock); err != nil {
 					fatal(err)
 				}
 				if err := readConfig(rootDir); err != nil {
@@ -76,9 +87,14 @@ func init() {
 				if err != nil {
 					fatal(err)
 				}
-				if err := lockProject(rootDir); err != nil {
+				if err := lockProject(rootDir, exclusiveLock); err != nil {
 					fatal(err)
 				}
+				for _, field := range userOnlyFields {
+					if args[0] == field {
+						fatal(fmt.Errorf("%s may only be set in the user config (use --user)", field))
+					}
+				}
 				_, err = readProjectConfig(rootDir)
 			}
 
@@ -1,6 +1,15 @@
 package cmd
 
 import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/index"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -14,14 +23,109 @@ func init() {
 		false,
 		"don't operate recursively over Stage inputs",
 	)
+	addIncludeFlag(fetchCmd)
 }
 
+// remoteHelp describes the supported remotes, for the help of commands that
+// use them.
+const remoteHelp = `Remotes may be given as URLs or as rclone remote paths. The following URL
+schemes are handled natively:
+
+  file:///mnt/shared/dud   a directory on a local or network filesystem
+  s3://bucket/prefix       an AWS S3 (or S3-compatible) bucket
+  https://host:port        a cache served by 'dud serve'
+
+S3 remotes read credentials, the region, and the endpoint URL from the standard
+AWS environment variables (e.g. AWS_ACCESS_KEY_ID, AWS_REGION, and
+AWS_ENDPOINT_URL) and config files (e.g. ~/.aws/credentials). HTTP remotes
+authenticate pushes with the token in $DUD_HTTP_TOKEN. Any other remote
+(e.g. "gdrive:dud") is accessed through rclone, which must be installed on your
+machine. Visit https://rclone.org/ for more information and
+installation instructions.`
+
+// encryptionHelp describes remote encryption (see encryptionKey).
+const encryptionHelp = `To encrypt objects on the remote, set 'encryption-key-file' (a file holding
+the key) or 'encryption-key-env' (an environment variable holding the key) in
+your user config, e.g. with 'dud config set --user'. These fields aren't allowed
+in the project config. Keys are 32 random bytes, hex-encoded, e.g. from
+'openssl rand -hex 32'. Objects are encrypted before they're pushed, and are
+decrypted and verified against their checksums when fetched. Encrypted objects
+are named by a keyed hash of their checksums, so everyone sharing an encrypted
+remote must use the same key. HTTP remotes can't be encrypted, as 'dud serve'
+verifies objects against their checksums.`
+
 type noRemoteError struct{}
 
 func (e noRemoteError) Error() string {
 	return "no remote specified in the config"
 }
 
+// newRemote creates a cache.Remote from the remote in the Dud config. If an
+// encryption key is configured, objects are encrypted on the remote.
+func newRemote() (cache.Remote, error) {
+	remoteConfig := viper.GetString("remote")
+	if remoteConfig == "" {
+		return nil, noRemoteError{}
+	}
+	remote, err := cache.NewRemote(remoteConfig)
+	if err != nil {
+		return nil, err
+	}
+	key, err := encryptionKey()
+	if err != nil || key == nil {
+		return remote, err
+	}
+	return cache.NewEncryptedRemote(remote, key)
+}
+
+// encryptionKey returns the remote encryption key set in the Dud config, or
+// nil if none is set. The key is read from the file at encryption-key-file,
+// or from the environment variable named by encryption-key-env, and must be
+// hex-encoded.
+func encryptionKey() ([]byte, error) {
+	keyFile := viper.GetString("encryption-key-file")
+	keyEnv := viper.GetString("encryption-key-env")
+	var (
+		source  string
+		keyText string
+	)
+	switch {
+	case keyFile != "" && keyEnv != "":
+		return nil, errors.New(
+			"only one of encryption-key-file and encryption-key-env may be set in the config",
+		)
+	case keyFile != "":
+		path, err := homedir.Expand(keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "encryption-key-file")
+		}
+		keyBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "encryption-key-file")
+		}
+		source, keyText = path, string(keyBytes)
+	case keyEnv != "":
+		var ok bool
+		keyText, ok = os.LookupEnv(keyEnv)
+		if !ok {
+			return nil, fmt.Errorf("encryption-key-env: environment variable %s is not set", keyEnv)
+		}
+		source = "environment variable " + keyEnv
+	default:
+		return nil, nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(keyText))
+	if err != nil || len(key) != cache.EncryptionKeySize {
+		return nil, fmt.Errorf(
+			"encryption key in %s must be %d hex-encoded bytes (e.g. from 'openssl rand -hex %d')",
+			source,
+			cache.EncryptionKeySize,
+			cache.EncryptionKeySize,
+		)
+	}
+	return key, nil
+}
+
 var fetchCmd = &cobra.Command{
 	Use:   "fetch [flags] [stage_file]...",
 	Short: "Fetch committed artifacts from the remote cache",
@@ -32,43 +136,51 @@ remote cache specified in the Dud config file. If no stage files are passed
 in, fetch will act on all stages in the index. By default, fetch will act
 recursively on all stages upstream of the given stage(s).
 
-This command requires rclone to be installed on your machine. Visit
-https://rclone.org/ for more information and installation instructions.`,
+` + remoteHelp + `
+
+` + encryptionHelp + `
+
+` + sparseHelp,
 	Run: func(cmd *cobra.Command, paths []string) {
-		rootDir, ch, idx, err := prepare(paths)
+		rootDir, ch, idx, err := prepare(paths, exclusiveLock)
 		if err != nil {
 			fatal(err)
 		}
+		fetchStages(rootDir, ch, idx, paths)
+	},
+}
 
-		remote := viper.GetString("remote")
-		if remote == "" {
-			fatal(noRemoteError{})
-		}
+// fetchStages fetches the artifacts of the given Stages, or of all Stages if
+// none are given, from the configured remote.
+func fetchStages(rootDir string, ch cache.LocalCache, idx index.Index, paths []string) {
+	remote, err := newRemote()
+	if err != nil {
+		fatal(err)
+	}
 
-		if len(paths) == 0 {
-			// Ignore disableRecursion flag when no args passed.
-			disableRecursion = false
-			for path := range idx {
-				paths = append(paths, path)
-			}
+	if len(paths) == 0 {
+		// Ignore disableRecursion flag when no args passed.
+		disableRecursion = false
+		for path := range idx {
+			paths = append(paths, path)
 		}
+	}
 
-		fetched := make(map[string]bool)
-		for _, path := range paths {
-			inProgress := make(map[string]bool)
-			if err := idx.Fetch(
-				path,
-				ch,
-				rootDir,
-				!disableRecursion,
-				remote,
-				fetched,
-				inProgress,
-				logger,
-			); err != nil {
-				fatal(err)
-			}
-			logger.Info.Println()
+	fetched := make(map[string]bool)
+	for _, path := range paths {
+		inProgress := make(map[string]bool)
+		if err := idx.Fetch(
+			path,
+			ch,
+			rootDir,
+			!disableRecursion,
+			remote,
+			fetched,
+			inProgress,
+			logger,
+		); err != nil {
+			fatal(err)
 		}
-	},
+		logger.Info.Println()
+	}
 }
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/index"
+	"github.com/kevin-hanselman/dud/src/stage"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+	gcCmd.Flags().BoolVarP(
+		&gcDryRun,
+		"dry-run",
+		"n",
+		false,
+		"report unreferenced objects without removing them",
+	)
+	gcCmd.Flags().StringSliceVarP(
+		&gcGitRevs,
+		"git-rev",
+		"r",
+		[]string{},
+		"also keep objects referenced by stages at these git revisions",
+	)
+}
+
+var (
+	gcDryRun  bool
+	gcGitRevs []string
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc [flags]",
+	Short: "Remove unreferenced objects from the cache",
+	Long: `GC removes all objects from the cache that are not referenced by any stage.
+
+GC walks the outputs of every stage in the index, including all files in
+directory artifacts, and removes every other object from the cache. Use
+--dry-run to list what would be removed without removing it.
+
+By default, only the stages as they exist in the workspace are considered, so
+objects committed on other branches or in past revisions will be removed. Pass
+one or more --git-rev flags (anything 'git show' understands, e.g. a branch
+name, tag, or commit hash) to keep the objects referenced by the index and stage
+files at those revisions as well.`,
+	Example: "dud gc --dry-run --git-rev main --git-rev HEAD~1",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		mode := exclusiveLock
+		if gcDryRun {
+			mode = sharedLock
+		}
+		_, ch, idx, err := prepare(nil, mode)
+		if err != nil {
+			fatal(err)
+		}
+
+		arts := allStageOutputs(idx)
+		for _, rev := range gcGitRevs {
+			revIdx, err := indexAtGitRev(rev)
+			if err != nil {
+				fatal(err)
+			}
+			arts = append(arts, allStageOutputs(revIdx)...)
+		}
+
+		result, err := ch.GarbageCollect(arts, gcDryRun)
+		if err != nil {
+			fatal(err)
+		}
+
+		for _, checksum := range result.Checksums {
+			logger.Debug.Println(checksum)
+		}
+		verb := "Removed"
+		if gcDryRun {
+			verb = "Would remove"
+		}
+		logger.Info.Printf(
+			"%s %d objects (%s)\n",
+			verb,
+			len(result.Checksums),
+			datasize.ByteSize(result.Bytes).HR(),
+		)
+	},
+}
+
+// allStageOutputs collects the outputs of all Stages in the Index.
+func allStageOutputs(idx index.Index) (arts []*artifact.Artifact) {
+	for _, stg := range idx {
+		for _, art := range stg.Outputs {
+			arts = append(arts, art)
+		}
+	}
+	return
+}
+
+// indexAtGitRev loads the Index and all of its Stages as they were at the given
+// git revision. Stages are read straight from git, so the workspace is left
+// untouched.
+func indexAtGitRev(rev string) (index.Index, error) {
+	errPrefix := "load index at git revision " + rev
+	indexBytes, err := gitShow(rev, indexPath)
+	if err != nil {
+		return nil, errors.Wrap(err, errPrefix)
+	}
+	idx := make(index.Index)
+	scanner := bufio.NewScanner(bytes.NewReader(indexBytes))
+	for scanner.Scan() {
+		stagePath := strings.TrimSpace(scanner.Text())
+		if stagePath == "" {
+			continue
+		}
+		stageBytes, err := gitShow(rev, stagePath)
+		if err != nil {
+			return nil, errors.Wrap(err, errPrefix)
+		}
+		stg, err := stage.FromReader(bytes.NewReader(stageBytes), stagePath)
+		if err != nil {
+			return nil, errors.Wrap(err, errPrefix)
+		}
+		if err := idx.AddStage(stg, stagePath); err != nil {
+			return nil, errors.Wrap(err, errPrefix)
+		}
+	}
+	return idx, errors.Wrap(scanner.Err(), errPrefix)
+}
+
+// gitShow returns the contents of the file at path (relative to the current
+// directory) at the given git revision.
+func gitShow(rev, path string) ([]byte, error) {
+	// The "./" prefix tells git that path is relative to the current
+	// directory, not the root of the git repository.
+	out, err := exec.Command("git", "show", rev+":./"+path).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return nil, errors.Errorf("git show: %s", bytes.TrimSpace(exitErr.Stderr))
+	}
+	return out, err
+}
@@ -26,6 +26,34 @@ func init() {
 # config to override.
 # cache: .dud/cache
 
+# If the cache is shared by several users (e.g. on a shared filesystem),
+# uncomment and set this to a group they all belong to. New directories in the
+# cache will be owned by the group and be group-writable.
+# cache-group: dud
+
+# Uncomment to store new objects in the cache zstd-compressed. Compressed
+# objects can't be linked into the workspace, so they're always copied.
+# Existing objects are left as they are.
+# compression: zstd
+
+# Uncomment to store files of at least this size in content-defined chunks, so
+# that new versions of large files only add the chunks that changed.
+# chunk-threshold: 256MB
+
+# Uncomment to checksum new files with a different hash algorithm (blake3,
+# sha256, or sha512). Checksums other than blake3 are written with the name of
+# the algorithm as a prefix (e.g. sha256:...). Files committed before changing
+# this keep their checksums until they are modified.
+# hash: sha256
+
+# Uncomment to let 'dud cache prune' evict the least recently used objects until
+# the cache is at most this size. Only objects on the remote are evicted.
+# cache-max-size: 50GB
+
+# Uncomment to change how many logs of 'dud run' are kept for each stage in
+# .dud/logs. Set to 0 to disable logging.
+# run-log-retention: 10
+
 # To enable push and fetch, set 'remote' to a valid rclone remote path. For
 # example, if you have a remote called "s3" in your .dud/rclone.conf, and you
 # want your remote cache to live in a bucket called 'dud', you would write:
@@ -44,7 +72,7 @@ func init() {
 				fatal(err)
 			}
 
-			if err := os.WriteFile(".dud/.gitignore", []byte("/cache/\n/lock\n"), 0o644); err != nil {
+			if err := os.WriteFile(".dud/.gitignore", []byte("/cache/\n/lock\n/logs/\n/sparse\n/stat-cache\n"), 0o644); err != nil {
 				fatal(err)
 			}
 
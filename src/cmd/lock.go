@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kevin-hanselman/dud/src/fsutil"
+)
+
+// lockMode is the kind of project lock a command needs. Any number of
+// commands can hold a shared lock at once, but an exclusive lock excludes all
+// other locks.
+type lockMode int
+
+const (
+	// sharedLock is for commands that only read the project, e.g. status.
+	sharedLock lockMode = iota
+	// exclusiveLock is for commands that modify the project, e.g. commit.
+	exclusiveLock
+)
+
+// How often to retry taking the project lock while waiting for it.
+const lockPollInterval = 100 * time.Millisecond
+
+var (
+	// projectLock is the open lock file, or nil if the project isn't locked.
+	projectLock     *os.File
+	projectLockMode lockMode
+	// lockTimeout is how long to wait for other Dud commands to release the
+	// project lock.
+	lockTimeout time.Duration
+)
+
+type projectLockedError struct{}
+
+func (e projectLockedError) Error() string {
+	return "another Dud command is using this project; use --lock-timeout to wait for it"
+}
+
+// lockProject takes an advisory lock on the project's lock file, creating the
+// file if needed. If another process holds a conflicting lock, lockProject
+// retries until lockTimeout elapses, then returns a projectLockedError. The
+// lock is released by unlockProject, or by the OS when the process exits, so
+// a crashed Dud command never leaves the project locked.
+//
+// Calling lockProject again while holding the lock is a no-op, unless
+// upgrading from a shared to an exclusive lock. Upgrading is not atomic:
+// the shared lock is released before the exclusive lock is taken.
+func lockProject(rootDir string, mode lockMode) error {
+	if projectLock != nil {
+		if projectLockMode >= mode {
+			return nil
+		}
+		if err := unlockProject(); err != nil {
+			return err
+		}
+	}
+	// If we're already in the project root, we technically can use lockPath
+	// directly, but this approach explicitly requires we know the project
+	// root.
+	lockFile, err := os.OpenFile(filepath.Join(rootDir, lockPath), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err = fsutil.TryLock(lockFile, mode == exclusiveLock)
+		if err == nil {
+			projectLock = lockFile
+			projectLockMode = mode
+			return nil
+		}
+		if err != fsutil.ErrLocked || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(lockPollInterval)
+	}
+	lockFile.Close()
+	if err == fsutil.ErrLocked {
+		return projectLockedError{}
+	}
+	return err
+}
+
+// unlockProject releases the project lock, if held. The lock file itself is
+// left in place.
+func unlockProject() error {
+	if projectLock == nil {
+		return nil
+	}
+	// Closing the file releases the lock. Whether or not Close succeeds, we
+	// shouldn't try unlocking again.
+	lockFile := projectLock
+	projectLock = nil
+	return lockFile.Close()
+}
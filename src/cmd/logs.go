@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/kevin-hanselman/dud/src/runlog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+	logsCmd.Flags().BoolVarP(
+		&listLogs,
+		"list",
+		"l",
+		false,
+		"list the logged runs of the stage, oldest first",
+	)
+	logsCmd.Flags().StringVarP(
+		&logsRun,
+		"run",
+		"r",
+		"",
+		"print the log of this run (as listed by --list) instead of the latest",
+	)
+}
+
+var (
+	listLogs bool
+	logsRun  string
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [flags] <stage_file>",
+	Short: "Print the output of a stage's past runs",
+	Long: `Logs prints the output of a stage's past runs.
+
+'dud run' saves the output of each stage command in ` + logsPath + `. By
+default, logs prints the log of the stage's latest run. Use --list to see the
+logged runs, and --run to print the log of one of them.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, paths []string) {
+		// Logs are kept for stages that are no longer in the index, so the
+		// index isn't consulted.
+		rootDir, _, _, err := prepare(paths, sharedLock)
+		if err != nil {
+			fatal(err)
+		}
+		stagePath := paths[0]
+
+		logs := runlog.Store{Dir: filepath.Join(rootDir, logsPath)}
+		runs, err := logs.List(stagePath)
+		if err != nil {
+			fatal(err)
+		}
+		if listLogs {
+			for _, run := range runs {
+				fmt.Println(run)
+			}
+			return
+		}
+		if len(runs) == 0 {
+			fatal(fmt.Errorf("no logs for stage %s", stagePath))
+		}
+		run := runs[len(runs)-1]
+		if logsRun != "" {
+			run = ""
+			for _, loggedRun := range runs {
+				if loggedRun == logsRun {
+					run = loggedRun
+				}
+			}
+			if run == "" {
+				fatal(fmt.Errorf("no log of run %s for stage %s", logsRun, stagePath))
+			}
+		}
+		logFile, err := os.Open(logs.Path(stagePath, run))
+		if err != nil {
+			fatal(err)
+		}
+		defer logFile.Close()
+		if _, err := io.Copy(os.Stdout, logFile); err != nil {
+			fatal(err)
+		}
+	},
+}
@@ -11,8 +11,9 @@ func init() {
 		"copy",
 		"c",
 		false,
-		"copy artifacts instead of linking",
+		"copy artifacts instead of linking (same as --strategy=copy)",
 	)
+	addStrategyFlag(pullCmd)
 	pullCmd.Flags().BoolVarP(
 		&disableRecursion,
 		"single-stage",
@@ -20,6 +21,7 @@ func init() {
 		false,
 		"don't operate recursively over Stage inputs",
 	)
+	addIncludeFlag(pullCmd)
 }
 
 var pullCmd = &cobra.Command{
@@ -27,17 +29,24 @@ var pullCmd = &cobra.Command{
 	Short: "Fetch artifacts from the remote and checkout",
 	Long: `Pull runs fetch followed by checkout.
 
-This command requires rclone to be installed on your machine. Visit
-https://rclone.org/ for more information and installation instructions.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fetchCmd.Run(cmd, args)
-		// After fetch completes, remove its lock file so checkout can take
-		// control.
-		// TODO: Removing the lock file between operations is awkward and
-		// probably buggy. We definitely should revisit this.
-		if err := unlockProject(); err != nil {
+` + remoteHelp + `
+
+` + encryptionHelp + `
+
+` + sparseHelp,
+	Run: func(cmd *cobra.Command, paths []string) {
+		strat, err := checkoutStrategy(cmd)
+		if err != nil {
+			fatal(err)
+		}
+
+		// The project stays locked, and the stat cache loaded, between the
+		// fetch and the checkout.
+		rootDir, ch, idx, err := prepare(paths, exclusiveLock)
+		if err != nil {
 			fatal(err)
 		}
-		checkoutCmd.Run(cmd, args)
+		fetchStages(rootDir, ch, idx, paths)
+		checkoutStages(rootDir, ch, idx, paths, strat)
 	},
 }
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 func init() {
@@ -26,17 +25,18 @@ remote cache specified in the Dud config file. If no stage files are passed
 in, push will act on all stages in the index. By default, push will
 act recursively on all stages upstream of the given stage(s).
 
-This command requires rclone to be installed on your machine. Visit
-https://rclone.org/ for more information and installation instructions.`,
+` + remoteHelp + `
+
+` + encryptionHelp,
 	Run: func(cmd *cobra.Command, paths []string) {
-		rootDir, ch, idx, err := prepare(paths)
+		rootDir, ch, idx, err := prepare(paths, sharedLock)
 		if err != nil {
 			fatal(err)
 		}
 
-		remote := viper.GetString("remote")
-		if remote == "" {
-			fatal(noRemoteError{})
+		remote, err := newRemote()
+		if err != nil {
+			fatal(err)
 		}
 
 		if len(idx) == 0 {
@@ -5,15 +5,20 @@ import (
 	"io"
 	"log"
 	"os"
+	"os/user"
 	"path/filepath"
 	"runtime/trace"
+	"strconv"
 	"strings"
 
+	"github.com/c2h5oh/datasize"
 	"github.com/felixge/fgprof"
 	"github.com/kevin-hanselman/dud/src/agglog"
 	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/checksum"
 	"github.com/kevin-hanselman/dud/src/fsutil"
 	"github.com/kevin-hanselman/dud/src/index"
+	"github.com/kevin-hanselman/dud/src/runlog"
 	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -22,8 +27,12 @@ import (
 )
 
 const (
-	indexPath = ".dud/index"
-	lockPath  = ".dud/lock"
+	indexPath     = ".dud/index"
+	lockPath      = ".dud/lock"
+	statCachePath = ".dud/stat-cache"
+	logsPath      = ".dud/logs"
+
+	defaultRunLogRetention = 10
 )
 
 type emptyIndexError struct{}
@@ -32,12 +41,6 @@ func (e emptyIndexError) Error() string {
 	return "index is empty"
 }
 
-type projectLockedError struct{}
-
-func (e projectLockedError) Error() string {
-	return fmt.Sprintf("project lock file '%s' exists", lockPath)
-}
-
 var (
 	// Version is the version of the app.
 	Version string
@@ -83,15 +86,31 @@ building data pipelines.`,
 	// This is the Logger for the entire application.
 	logger *agglog.AggLogger
 
-	doProfile, doTrace, verbose, projectLocked bool
-	debugOutput                                *os.File
-	stopProfiling                              func() error
+	doProfile, doTrace, verbose bool
+	debugOutput                 *os.File
+	stopProfiling               func() error
+
+	// statCache is saved when the command succeeds. It's nil when disabled.
+	statCache   *cache.StatCache
+	noStatCache bool
 )
 
 func init() {
 	rootCmd.PersistentFlags().BoolVar(&doProfile, "profile", false, "enable profiling")
 	rootCmd.PersistentFlags().BoolVar(&doTrace, "trace", false, "enable tracing")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "increase output verbosity")
+	rootCmd.PersistentFlags().DurationVar(
+		&lockTimeout,
+		"lock-timeout",
+		0,
+		"wait up to this long (e.g. 30s) for other Dud commands to finish",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&noStatCache,
+		"no-stat-cache",
+		false,
+		"read all files instead of trusting the checksums of unchanged files in "+statCachePath,
+	)
 
 	rootCmd.AddCommand(&cobra.Command{
 		Use:    "gen-docs",
@@ -138,6 +157,11 @@ func Main() {
 	if err := rootCmd.Execute(); err != nil {
 		fatal(err)
 	}
+	// The stat cache is only a cache, so failing to save it (e.g. in
+	// a read-only project) isn't fatal.
+	if err := statCache.Save(); err != nil {
+		logger.Debug.Println(errors.Wrap(err, "save stat cache"))
+	}
 	if err := unlockProject(); err != nil {
 		fatal(err)
 	}
@@ -148,10 +172,8 @@ func Main() {
 
 // fatal ensures we gracefully stop profiling or tracing before exiting.
 func fatal(err error) {
-	if !errors.Is(err, projectLockedError{}) {
-		if err := unlockProject(); err != nil {
-			logger.Error.Println(err)
-		}
+	if err := unlockProject(); err != nil {
+		logger.Error.Println(err)
 	}
 	if err := stopDebugging(); err != nil {
 		logger.Error.Println(err)
@@ -201,7 +223,7 @@ func readProjectConfig(rootDir string) (path string, err error) {
 }
 
 // Read the project and user config files and merge them. Project config files
-// take precedence over user config files.
+// take precedence over user config files, but may not set userOnlyFields.
 func readConfig(rootDir string) (err error) {
 	viper.SetDefault("cache", ".dud/cache")
 
@@ -212,8 +234,106 @@ func readConfig(rootDir string) (err error) {
 			return err
 		}
 	}
-	_, err = readProjectConfig(rootDir)
-	return
+	path, err := readProjectConfig(rootDir)
+	if err != nil {
+		return err
+	}
+	return checkProjectConfig(path)
+}
+
+// userOnlyFields are the config fields that may only be set in the user
+// config, as they tell Dud which secrets to use. A project config is shared
+// with everyone working on the project, so it could otherwise have Dud read
+// arbitrary files or use a key chosen by someone else.
+var userOnlyFields = []string{
+	"encryption-key-env",
+	"encryption-key-file",
+}
+
+// checkProjectConfig returns an error if the project config file at path sets
+// any userOnlyFields.
+func checkProjectConfig(path string) error {
+	projectConfig := viper.New()
+	projectConfig.SetConfigFile(path)
+	if err := projectConfig.ReadInConfig(); err != nil {
+		return err
+	}
+	for _, field := range userOnlyFields {
+		if projectConfig.IsSet(field) {
+			return fmt.Errorf(
+				"%s may only be set in the user config, but it's set in %s",
+				field,
+				path,
+			)
+		}
+	}
+	return nil
+}
+
+// cacheOptions returns the LocalCache options set in the Dud config.
+func cacheOptions() (opts []cache.LocalCacheOption, err error) {
+	switch compression := viper.GetString("compression"); compression {
+	case "", "none":
+	case "zstd":
+		opts = append(opts, cache.WithCompression())
+	default:
+		return nil, fmt.Errorf(
+			"unknown compression %#v in config; expected zstd or none",
+			compression,
+		)
+	}
+	if threshold := viper.GetString("chunk-threshold"); threshold != "" {
+		var size datasize.ByteSize
+		if err := size.UnmarshalText([]byte(threshold)); err != nil {
+			return nil, fmt.Errorf(
+				"invalid chunk-threshold %#v in config; expected a size (e.g. 256MB)",
+				threshold,
+			)
+		}
+		opts = append(opts, cache.WithChunking(int64(size.Bytes())))
+	}
+	if groupName := viper.GetString("cache-group"); groupName != "" {
+		group, err := user.LookupGroup(groupName)
+		if err != nil {
+			return nil, errors.Wrap(err, "cache-group")
+		}
+		gid, err := strconv.Atoi(group.Gid)
+		if err != nil {
+			return nil, errors.Wrap(err, "cache-group")
+		}
+		opts = append(opts, cache.WithSharedGroup(gid))
+	}
+	if hashName := viper.GetString("hash"); hashName != "" {
+		algo, err := checksum.Lookup(hashName)
+		if err != nil {
+			return nil, errors.Wrap(err, "hash")
+		}
+		opts = append(opts, cache.WithHash(algo))
+	}
+	return opts, nil
+}
+
+// runLogs returns the Store for logs of 'dud run' in the project at rootDir,
+// as set in the Dud config. It returns nil if logging is disabled.
+func runLogs(rootDir string) (*runlog.Store, error) {
+	retention := defaultRunLogRetention
+	if value := viper.GetString("run-log-retention"); value != "" {
+		var err error
+		retention, err = strconv.Atoi(value)
+		if err != nil || retention < 0 {
+			return nil, fmt.Errorf(
+				"invalid run-log-retention %#v in config; expected a non-negative integer",
+				value,
+			)
+		}
+	}
+	if retention == 0 {
+		return nil, nil
+	}
+	return &runlog.Store{
+		Dir:       filepath.Join(rootDir, logsPath),
+		Retention: retention,
+	}, nil
 }
 
 func getProjectRootDir() (string, error) {
@@ -250,48 +370,15 @@ func pathAbsThenRel(base string, path string) (string, error) {
 	return filepath.Rel(base, absPath)
 }
 
-// Prevent concurrent invocations of Dud in the current project using a simple
-// lock file. This isn't a comprehensive solution for concurrent user errors,
-// but it should prevent the most common problems.
-func lockProject(rootDir string) error {
-	// If we're already in the project root, we technically can use lockPath
-	// directly, but this approach explicitly requires we know the project
-	// root.
-	lockFile, err := os.OpenFile(
-		filepath.Join(rootDir, lockPath),
-		// O_EXCL is key. If the file already exists, someone else has already
-		// claimed the file.
-		os.O_CREATE|os.O_RDWR|os.O_EXCL,
-		0o600,
-	)
-	if err == nil {
-		projectLocked = true
-		return lockFile.Close()
-	}
-	if os.IsExist(err) {
-		logger.Error.Println(`Another invocation of Dud may be running, or Dud may have exited
-unexpectedly and orphaned the lock file. If you are certain Dud is not already
-running and your project is healthy, you may remove the lock file and try
-running Dud again.`)
-		return projectLockedError{}
-	}
-	return err
-}
-
-func unlockProject() error {
-	if projectLocked {
-		// If os.Remove succeeds, we're unlocked. If it fails, we should be calling
-		// fatal(), and we don't want try unlocking again.
-		projectLocked = false
-		return os.Remove(lockPath)
-	}
-	return nil
-}
-
 // Do a bunch of bookkeeping to prepare for usual execution of Dud operations.
 // The paths argument is updated in-place so each path is relative to the
-// project root directory.
-func prepare(paths []string) (rootDir string, ch cache.LocalCache, idx index.Index, err error) {
+// project root directory. The project is locked according to mode.
+func prepare(paths []string, mode lockMode) (
+	rootDir string,
+	ch cache.LocalCache,
+	idx index.Index,
+	err error,
+) {
 	// The order of operations here is important. Before we cd to the project
 	// root directory, we need to adjust the paths, which are relative to the
 	// working directory (or absolute paths already).
@@ -311,7 +398,7 @@ func prepare(paths []string) (rootDir string, ch cache.LocalCache, idx index.Ind
 		return
 	}
 
-	if err = lockProject(rootDir); err != nil {
+	if err = lockProject(rootDir, mode); err != nil {
 		return
 	}
 
@@ -319,10 +406,36 @@ func prepare(paths []string) (rootDir string, ch cache.LocalCache, idx index.Ind
 		return
 	}
 
-	ch, err = cache.NewLocalCache(viper.GetString("cache"))
+	opts, err := cacheOptions()
 	if err != nil {
 		return
 	}
+	sparse, err := sparsePatterns()
+	if err != nil {
+		return
+	}
+	if sparse != nil {
+		opts = append(opts, cache.WithSparse(sparse))
+	}
+	if !noStatCache {
+		statCache, err = cache.OpenStatCache(filepath.Join(rootDir, statCachePath))
+		if err != nil {
+			return
+		}
+		opts = append(opts, cache.WithStatCache(statCache))
+	}
+	ch, err = cache.NewLocalCache(viper.GetString("cache"), opts...)
+	if err != nil {
+		return
+	}
+
+	// Only commands that modify the project are expected to have write
+	// access to the cache.
+	if mode == exclusiveLock {
+		if err = ch.CleanStaging(); err != nil {
+			return
+		}
+	}
 
 	idx, err = index.FromFile(indexPath)
 	return
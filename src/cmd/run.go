@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"github.com/kevin-hanselman/dud/src/runlog"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -13,9 +15,39 @@ func init() {
 		false,
 		"disable recursive operation on upstream stages",
 	)
+	addJobsFlag(runCmd, "run up to `N` independent stages at once")
+	runCmd.Flags().BoolVar(
+		&runNoLog,
+		"no-log",
+		false,
+		"don't save the output of stage commands in "+logsPath,
+	)
+	runCmd.Flags().BoolVarP(
+		&runKeepGoing,
+		"keep-going",
+		"k",
+		false,
+		"keep running stages not downstream of a failed stage",
+	)
 }
 
-var runSingleStage bool
+var (
+	runSingleStage, runKeepGoing, runNoLog bool
+	numJobs                                int
+)
+
+// addJobsFlag adds the --jobs flag to cmd.
+func addJobsFlag(cmd *cobra.Command, usage string) {
+	cmd.Flags().IntVarP(&numJobs, "jobs", "j", 1, usage)
+}
+
+// checkJobs checks the value of the --jobs flag.
+func checkJobs() error {
+	if numJobs < 1 {
+		return errors.New("--jobs must be at least 1")
+	}
+	return nil
+}
 
 var runCmd = &cobra.Command{
 	Use:   "run [flags] [stage_file]...",
@@ -26,9 +58,23 @@ For each stage passed in, run executes a stage's command if it is out-of-date.
 If no stage files are passed in, run will act on all stages in the index. By
 default, run will act recursively on all stages upstream of the given stage,
 and thus run will execute a stage's command if any upstream stages are
-out-of-date.`,
+out-of-date.
+
+With --jobs greater than one, run executes up to that many stages at once. A
+stage is started as soon as all stages upstream of it are done, and each line
+of a stage's output is prefixed with the stage's path. By default, run stops
+starting stages once a stage fails; with --keep-going, run continues with all
+stages that don't depend on the failed stage.
+
+The output of each stage command is also saved in ` + logsPath + `. Use 'dud logs'
+to view it. By default, the last 10 logs of each stage are kept; set
+run-log-retention in the config to change this, or to 0 to disable logging.
+To save their output, stage commands write to a pipe rather than the terminal,
+so commands that check for a terminal may disable colors, progress bars, or
+prompts. Pass --no-log to give them the terminal (unless --jobs is greater than
+one or --keep-going is set, as these prefix each line of output).`,
 	Run: func(cmd *cobra.Command, paths []string) {
-		rootDir, ch, idx, err := prepare(paths)
+		rootDir, ch, idx, err := prepare(paths, exclusiveLock)
 		if err != nil {
 			fatal(err)
 		}
@@ -43,10 +89,39 @@ out-of-date.`,
 			}
 		}
 
+		var logs *runlog.Store
+		if !runNoLog {
+			logs, err = runLogs(rootDir)
+			if err != nil {
+				fatal(err)
+			}
+		}
+
+		if err := checkJobs(); err != nil {
+			fatal(err)
+		}
+
+		if numJobs > 1 || runKeepGoing {
+			err := idx.RunConcurrently(
+				paths,
+				ch,
+				rootDir,
+				!runSingleStage,
+				numJobs,
+				runKeepGoing,
+				logs,
+				logger,
+			)
+			if err != nil {
+				fatal(err)
+			}
+			return
+		}
+
 		ran := make(map[string]bool)
 		for _, path := range paths {
 			inProgress := make(map[string]bool)
-			err := idx.Run(path, ch, rootDir, !runSingleStage, ran, inProgress, logger)
+			err := idx.Run(path, ch, rootDir, !runSingleStage, ran, inProgress, logs, logger)
 			if err != nil {
 				fatal(err)
 			}
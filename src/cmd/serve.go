@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	serveAddr, serveUpstream, serveTLSCert, serveTLSKey string
+	serveAllowPush                                      bool
+)
+
+func init() {
+	serveCmd.Flags().StringVarP(
+		&serveAddr,
+		"addr",
+		"a",
+		":8080",
+		"the address to listen on",
+	)
+	serveCmd.Flags().StringVarP(
+		&serveUpstream,
+		"upstream",
+		"u",
+		"",
+		"fetch objects missing from the cache from this remote",
+	)
+	serveCmd.Flags().BoolVar(
+		&serveAllowPush,
+		"allow-push",
+		false,
+		fmt.Sprintf("allow clients with the token in $%s to push", cache.HTTPTokenEnvVar),
+	)
+	serveCmd.Flags().StringVar(
+		&serveTLSCert,
+		"tls-cert",
+		"",
+		"serve HTTPS using this certificate file",
+	)
+	serveCmd.Flags().StringVar(
+		&serveTLSKey,
+		"tls-key",
+		"",
+		"serve HTTPS using this private key file",
+	)
+	rootCmd.AddCommand(serveCmd)
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [flags] [cache_dir]",
+	Short: "Serve a cache over HTTP",
+	Long: `Serve makes a cache available to other projects over HTTP.
+
+Serve serves the given cache directory, or the current project's cache if no
+directory is given. Other projects can fetch from (and optionally push to) the
+cache by setting their remote to the URL of the server, e.g.
+"http://cache-box:8080".
+
+By default, the cache is read-only. With --allow-push, clients may also push
+to the cache. Clients must authenticate pushes with the token in the
+$DUD_HTTP_TOKEN environment variable, which must be set for both the server and
+its clients. The server verifies the checksum of every object pushed to it.
+
+With --upstream, objects missing from the cache are fetched on demand from the
+given remote, which may be any remote supported by 'dud fetch'. This allows the
+server to act as a caching proxy in front of a slower or more expensive remote
+(e.g. for CI runners).
+
+If the project config sets 'compression', objects pushed to the server are
+stored compressed. Clients always receive uncompressed objects.
+
+Serve doesn't lock the project, so it can run alongside other Dud commands.
+Use --tls-cert and --tls-key to serve HTTPS.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cacheDir, err := serveCacheDir(args)
+		if err != nil {
+			fatal(err)
+		}
+		opts, err := cacheOptions()
+		if err != nil {
+			fatal(err)
+		}
+		ch, err := cache.NewLocalCache(cacheDir, opts...)
+		if err != nil {
+			fatal(err)
+		}
+		if err := ch.CleanStaging(); err != nil {
+			fatal(err)
+		}
+
+		var upstream cache.Remote
+		if serveUpstream != "" {
+			if upstream, err = cache.NewRemote(serveUpstream); err != nil {
+				fatal(err)
+			}
+		}
+
+		token := ""
+		if serveAllowPush {
+			token = os.Getenv(cache.HTTPTokenEnvVar)
+			if token == "" {
+				fatal(fmt.Errorf("--allow-push requires $%s to be set", cache.HTTPTokenEnvVar))
+			}
+		}
+
+		if (serveTLSCert == "") != (serveTLSKey == "") {
+			fatal(errors.New("--tls-cert and --tls-key must be used together"))
+		}
+
+		// Objects may be large, so there's no limit on the time to read
+		// a request body or write a response, but clients that are slow to
+		// send headers or leave connections idle are cut off.
+		server := &http.Server{
+			Addr:              serveAddr,
+			Handler:           cache.NewServer(ch, upstream, token, logger),
+			ErrorLog:          logger.Error,
+			ReadHeaderTimeout: 30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+		}
+		logger.Info.Printf("Serving %s on %s\n", cacheDir, serveAddr)
+		if serveTLSCert != "" {
+			err = server.ListenAndServeTLS(serveTLSCert, serveTLSKey)
+		} else {
+			err = server.ListenAndServe()
+		}
+		fatal(err)
+	},
+}
+
+// serveCacheDir returns the cache directory given on the command line, or the
+// cache directory of the current project. Unlike prepare, this doesn't lock
+// the project; serve runs indefinitely, and it only ever adds objects to the
+// cache.
+func serveCacheDir(args []string) (string, error) {
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	rootDir, err := getProjectRootDir()
+	if err != nil {
+		return "", err
+	}
+	if err := readConfig(rootDir); err != nil {
+		return "", err
+	}
+	cacheDir := viper.GetString("cache")
+	if !filepath.IsAbs(cacheDir) {
+		cacheDir = filepath.Join(rootDir, cacheDir)
+	}
+	return cacheDir, nil
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kevin-hanselman/dud/src/glob"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const sparsePath = ".dud/sparse"
+
+var (
+	includePatterns []string
+	noSparse        bool
+)
+
+const sparseHelp = `The --include flag makes the checkout sparse: only files matching the given
+glob patterns are fetched and checked out, including files inside directory
+artifacts. Patterns are relative to the project root. "*" matches within a
+path element, "**" matches any number of path elements, and a pattern naming
+a directory matches everything inside it. The patterns are saved in
+.dud/sparse and apply to all later commands, which print a notice to that
+effect, until they are replaced with another --include. Pass --no-sparse to
+remove the saved patterns and operate on everything again. Status reports
+excluded files as sparse, and commit keeps them as they are.`
+
+// addIncludeFlag adds the --include and --no-sparse flags to cmd.
+func addIncludeFlag(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(
+		&includePatterns,
+		"include",
+		nil,
+		"only operate on paths matching this glob (repeatable; saved in "+sparsePath+")",
+	)
+	cmd.Flags().BoolVar(
+		&noSparse,
+		"no-sparse",
+		false,
+		"remove the patterns saved in "+sparsePath+" and operate on all paths",
+	)
+	cmd.MarkFlagsMutuallyExclusive("include", "no-sparse")
+}
+
+// sparsePatterns returns the glob patterns of the project's sparse checkout,
+// or nil if the checkout isn't sparse. Patterns passed with --include replace
+// those saved in sparsePath, and --no-sparse removes them. As the patterns
+// persist between commands, a notice is printed whenever they are used.
+func sparsePatterns() ([]glob.Pattern, error) {
+	if noSparse {
+		if err := os.Remove(sparsePath); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if len(includePatterns) > 0 {
+		patterns, err := glob.CompileAll(includePatterns)
+		if err != nil {
+			return nil, errors.Wrap(err, "--include")
+		}
+		text := strings.Join(includePatterns, "\n") + "\n"
+		if err := os.WriteFile(sparsePath, []byte(text), 0o644); err != nil {
+			return nil, err
+		}
+		printSparseNotice()
+		return patterns, nil
+	}
+	file, err := os.Open(sparsePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, sparsePath)
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	patterns, err := glob.CompileAll(lines)
+	if err != nil {
+		return nil, errors.Wrap(err, sparsePath)
+	}
+	printSparseNotice()
+	return patterns, nil
+}
+
+// printSparseNotice tells the user that the checkout is sparse. The notice
+// goes to stderr to keep it out of output meant for other programs (e.g.
+// dud graph).
+func printSparseNotice() {
+	fmt.Fprintf(
+		os.Stderr,
+		"Only operating on paths matching the patterns in %s (see --no-sparse).\n",
+		sparsePath,
+	)
+}
@@ -29,10 +29,25 @@ Below is a fully-annotated Stage YAML file for reference.
 # a user. The checksum does not include Artifact checksums.
 checksum: abcdefghijklmnopqrstuvwxyz1234567890
 
-# The shell command to run when 'dud run' is called. '/bin/sh' is used to
-# run the command. (Stage commands are optional.)
+# The shell command to run when 'dud run' is called. (Stage commands are
+# optional.)
 command: python train.py
 
+# The shell that runs 'command', followed by any arguments. These are split on
+# whitespace; quotes are not supported, so put anything more involved (e.g.
+# bash -c "set -e") in 'command' itself. The command is passed to the shell
+# after '-c'. Defaults to 'sh' when omitted.
+shell: bash -eu
+
+# Environment variables to set for 'command'. Values are used as-is, without
+# expansion. Changing these marks the Stage as modified.
+env:
+  PYTHONHASHSEED: "0"
+
+# If true, 'command' doesn't inherit Dud's environment, except for HOME, PATH,
+# TMPDIR, and USER (and SYSTEMROOT on Windows). Defaults to false when omitted.
+clear-env: true
+
 # The directory in which the Stage's command is executed. Like all paths in
 # a Stage definition, it must be a directory path relative to the project's root
 # directory. An empty or omitted value means the command is executed in the
@@ -66,6 +81,24 @@ outputs:
     # Artifacts.
     disable-recursion: true
 
+    # 'exclude' lists gitignore-style patterns of files and sub-directories to
+    # leave out of this directory Artifact, relative to the directory. Patterns
+    # in a '.dudignore' file at the root of the directory are also excluded.
+    # Changing these patterns marks the Stage as modified. Not applicable for
+    # file Artifacts.
+    exclude:
+      - .DS_Store
+      - __pycache__/
+      - '*.tmp'
+
+  # An Artifact path may also be a glob pattern. A glob Artifact is a directory
+  # Artifact holding the files that match the pattern, so 'is-dir' is implied
+  # and adding or removing matches marks it as modified. '*' matches within a
+  # path element and '**' matches any number of path elements. The pattern must
+  # start with a directory name, and other Stages may depend on individual files
+  # it matches.
+  reports/*.html:
+
   metrics.json:
     # 'skip-cache' tells Dud not to commit this Artifact to the cache. Dud will
     # still write a checksum for this Artifact during 'dud commit', and it will
@@ -134,7 +167,7 @@ checks if it conflicts with any stages already in the index, then adds the
 stage to the index file.`,
 	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, paths []string) {
-		rootDir, _, idx, err := prepare(paths)
+		rootDir, _, idx, err := prepare(paths, exclusiveLock)
 		if err != nil {
 			fatal(err)
 		}
@@ -163,7 +196,7 @@ var removeStageCmd = &cobra.Command{
 	Aliases: []string{"rm"},
 	Args:    cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, paths []string) {
-		rootDir, _, idx, err := prepare(paths)
+		rootDir, _, idx, err := prepare(paths, exclusiveLock)
 		if err != nil {
 			fatal(err)
 		}
@@ -15,6 +15,7 @@ import (
 
 func init() {
 	statusCmd.Flags().BoolVar(&debugStatus, "debug", false, "print verbose JSON instead of regular output")
+	addJobsFlag(statusCmd, "check up to `N` artifacts at once")
 	rootCmd.AddCommand(statusCmd)
 }
 
@@ -28,8 +29,13 @@ func writeStageStatus(writer io.Writer, stagePath string, status stage.Status) e
 		stageFileStatus = "not checksummed"
 	}
 	fmt.Fprintf(writer, "%s\tstage definition %s\n", stagePath, stageFileStatus)
-	for path, artStatus := range status.ArtifactStatus {
-		fmt.Fprintf(writer, "  %s\t%s\n", path, artStatus)
+	paths := make([]string, 0, len(status.ArtifactStatus))
+	for path := range status.ArtifactStatus {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(writer, "  %s\t%s\n", path, status.ArtifactStatus[path])
 	}
 	return nil
 }
@@ -46,9 +52,12 @@ var (
 For each stage file passed in, status will print the current state of the
 stage. If no stage files are passed in, status will act on all stages in the
 index. By default, status will act recursively on all stages upstream of the
-given stage(s).`,
+given stage(s).
+
+With --jobs greater than one, status checks stages concurrently, with up to
+that many artifacts being checked at once.`,
 		Run: func(_ *cobra.Command, paths []string) {
-			rootDir, ch, idx, err := prepare(paths)
+			rootDir, ch, idx, err := prepare(paths, sharedLock)
 			if err != nil {
 				fatal(err)
 			}
@@ -63,15 +72,26 @@ given stage(s).`,
 				}
 			}
 
+			if err := checkJobs(); err != nil {
+				fatal(err)
+			}
+
 			sort.Strings(paths)
 
 			indexStatus := make(index.Status)
-			for _, path := range paths {
-				inProgress := make(map[string]bool)
-				err := idx.Status(path, ch, rootDir, indexStatus, inProgress)
+			if numJobs > 1 {
+				err = idx.StatusConcurrently(paths, ch, rootDir, numJobs, indexStatus)
 				if err != nil {
 					fatal(err)
 				}
+			} else {
+				for _, path := range paths {
+					inProgress := make(map[string]bool)
+					err := idx.Status(path, ch, rootDir, indexStatus, inProgress)
+					if err != nil {
+						fatal(err)
+					}
+				}
 			}
 
 			if debugStatus {
@@ -83,8 +103,13 @@ given stage(s).`,
 			}
 
 			writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-			for path, stageStatus := range indexStatus {
-				if err := writeStageStatus(writer, path, stageStatus); err != nil {
+			statusPaths := make([]string, 0, len(indexStatus))
+			for path := range indexStatus {
+				statusPaths = append(statusPaths, path)
+			}
+			sort.Strings(statusPaths)
+			for _, path := range statusPaths {
+				if err := writeStageStatus(writer, path, indexStatus[path]); err != nil {
 					fatal(err)
 				}
 				fmt.Fprintln(writer)
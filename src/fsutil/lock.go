@@ -0,0 +1,24 @@
+package fsutil
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrLocked is returned by TryLock when another open file holds a conflicting
+// lock.
+var ErrLocked = errors.New("file is locked")
+
+// Lock takes an advisory lock on file, waiting for any conflicting locks to
+// be released. Any number of shared locks can be held at once, but an
+// exclusive lock conflicts with all other locks, including those held by
+// other open files in the same process. Closing file releases the lock, as
+// does the exit of the process.
+func Lock(file *os.File, exclusive bool) error {
+	return lockFile(file.Fd(), exclusive, true)
+}
+
+// TryLock is like Lock, but returns ErrLocked instead of waiting.
+func TryLock(file *os.File, exclusive bool) error {
+	return lockFile(file.Fd(), exclusive, false)
+}
@@ -0,0 +1,54 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	path := filepath.Join(t.TempDir(), "lock")
+	// Locks held by separate open files conflict, even in the same process.
+	openLockFile := func(t *testing.T) *os.File {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { file.Close() })
+		return file
+	}
+
+	t.Run("shared locks coexist", func(t *testing.T) {
+		first, second := openLockFile(t), openLockFile(t)
+		if err := Lock(first, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := TryLock(second, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := TryLock(openLockFile(t), true); err != ErrLocked {
+			t.Fatalf("TryLock() = %v, want ErrLocked", err)
+		}
+	})
+
+	t.Run("exclusive lock excludes others until closed", func(t *testing.T) {
+		first := openLockFile(t)
+		if err := Lock(first, true); err != nil {
+			t.Fatal(err)
+		}
+		second := openLockFile(t)
+		if err := TryLock(second, false); err != ErrLocked {
+			t.Fatalf("TryLock() = %v, want ErrLocked", err)
+		}
+		if err := first.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := TryLock(second, true); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
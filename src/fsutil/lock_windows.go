@@ -0,0 +1,20 @@
+package fsutil
+
+import "golang.org/x/sys/windows"
+
+// lockFile locks the first byte of the file, which is enough to exclude
+// other lockers.
+func lockFile(fd uintptr, exclusive, wait bool) error {
+	var flags uint32
+	if exclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	if !wait {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	err := windows.LockFileEx(windows.Handle(fd), flags, 0, 1, 0, &windows.Overlapped{})
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return ErrLocked
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+// Package glob matches slash-separated paths against glob patterns.
+//
+// Patterns use the syntax of path.Match within each path element, plus "**",
+// which matches any number of path elements (including none). A pattern
+// matches a path if it matches the whole path or any of the path's parent
+// directories, so "data/images" matches everything inside that directory,
+// same as "data/images/**".
+package glob
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// A Pattern is a compiled glob pattern.
+type Pattern struct {
+	raw      string
+	elements []string
+}
+
+// Compile parses a glob pattern. Patterns must be relative paths. Leading
+// "./" and trailing slashes are ignored.
+func Compile(pattern string) (Pattern, error) {
+	cleaned := path.Clean(strings.TrimSpace(pattern))
+	if cleaned == "." || cleaned == "" {
+		return Pattern{}, fmt.Errorf("invalid glob %#v: pattern is empty", pattern)
+	}
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return Pattern{}, fmt.Errorf("invalid glob %#v: pattern must be a relative path", pattern)
+	}
+	elements := strings.Split(cleaned, "/")
+	for _, element := range elements {
+		// path.Match only reports malformed patterns when it gets far enough
+		// to notice them, and an empty name ensures it checks the whole
+		// pattern.
+		if _, err := path.Match(element, ""); err != nil {
+			return Pattern{}, fmt.Errorf("invalid glob %#v: %v", pattern, err)
+		}
+	}
+	return Pattern{raw: pattern, elements: elements}, nil
+}
+
+// CompileAll compiles each of the given patterns (see Compile).
+func CompileAll(patterns []string) ([]Pattern, error) {
+	compiled := make([]Pattern, len(patterns))
+	for i, pattern := range patterns {
+		var err error
+		if compiled[i], err = Compile(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
+
+// String returns the pattern as it was passed to Compile.
+func (pattern Pattern) String() string {
+	return pattern.raw
+}
+
+// Match returns true if the pattern matches the path or one of its parent
+// directories.
+func (pattern Pattern) Match(name string) bool {
+	return matchElements(pattern.elements, splitPath(name), false)
+}
+
+// MatchPrefix returns true if the pattern could match a path inside the
+// directory dir, i.e. if dir must be searched for matches.
+func (pattern Pattern) MatchPrefix(dir string) bool {
+	return matchElements(pattern.elements, splitPath(dir), true)
+}
+
+func splitPath(name string) []string {
+	name = path.Clean(name)
+	if name == "." {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+// matchElements matches pattern elements against path elements. If the path
+// runs out before the pattern, the result is prefix.
+func matchElements(pattern, name []string, prefix bool) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchElements(pattern[1:], name[i:], prefix) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return prefix
+		}
+		// The pattern was validated by Compile, so Match can't fail.
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	// Anything left in name is inside a matched directory.
+	return true
+}
+
+// HasMeta returns true if name contains any glob metacharacters.
+func HasMeta(name string) bool {
+	return strings.ContainsAny(name, `*?[`)
+}
+
+// Split splits a glob pattern into its leading path elements without
+// metacharacters and a Pattern for the rest of the path, which is relative
+// to base. base is empty if the first path element has metacharacters.
+func Split(pattern string) (base string, rest Pattern, err error) {
+	full, err := Compile(pattern)
+	if err != nil {
+		return "", Pattern{}, err
+	}
+	i := 0
+	for i < len(full.elements) && !HasMeta(full.elements[i]) {
+		i++
+	}
+	if i == len(full.elements) {
+		return "", Pattern{}, fmt.Errorf("invalid glob %#v: pattern has no wildcards", pattern)
+	}
+	base = strings.Join(full.elements[:i], "/")
+	restElements := full.elements[i:]
+	return base, Pattern{raw: strings.Join(restElements, "/"), elements: restElements}, nil
+}
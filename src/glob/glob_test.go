@@ -0,0 +1,90 @@
+package glob
+
+import "testing"
+
+func TestCompile(t *testing.T) {
+	for _, pattern := range []string{"data/*.txt", "./data/", "**", "a/**/b", "[ab]?"} {
+		if _, err := Compile(pattern); err != nil {
+			t.Fatalf("Compile(%#v) returned error: %v", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"", ".", "/data", "../data", "data/[", "a/../.."} {
+		if _, err := Compile(pattern); err == nil {
+			t.Fatalf("expected error for Compile(%#v)", pattern)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	type testCase struct {
+		pattern, path   string
+		match, isPrefix bool
+	}
+	tests := []testCase{
+		{"data/images", "data/images", true, true},
+		{"data/images", "data/images/cat.jpg", true, true},
+		{"data/images", "data", false, true},
+		{"data/images", "data/labels", false, false},
+		{"data/images", "other/images", false, false},
+		{"./data/images/", "data/images/cat.jpg", true, true},
+
+		{"data/*/cats", "data/train/cats/1.jpg", true, true},
+		{"data/*/cats", "data/train", false, true},
+		{"data/*/cats", "data/train/dogs", false, false},
+		{"data/*.txt", "data/a.txt", true, true},
+		{"data/*.txt", "data/sub/a.txt", false, false},
+
+		{"data/**/cats/**", "data/cats/1.jpg", true, true},
+		{"data/**/cats/**", "data/a/b/cats/1.jpg", true, true},
+		{"data/**/cats/**", "data/a/b", false, true},
+		{"data/**/cats/**", "other", false, false},
+		{"**/*.jpg", "a/b/c.jpg", true, true},
+		{"**/*.jpg", "a/b/c.png", false, true},
+		{"**", "anything/at/all", true, true},
+	}
+	for _, test := range tests {
+		pattern, err := Compile(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pattern.Match(test.path); got != test.match {
+			t.Errorf("%#v.Match(%#v) = %v, want %v", test.pattern, test.path, got, test.match)
+		}
+		if got := pattern.MatchPrefix(test.path); got != test.isPrefix {
+			t.Errorf("%#v.MatchPrefix(%#v) = %v, want %v", test.pattern, test.path, got, test.isPrefix)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	type testCase struct {
+		pattern, base, rest string
+	}
+	tests := []testCase{
+		{"reports/*.html", "reports", "*.html"},
+		{"./data/a/**/b.txt", "data/a", "**/b.txt"},
+		{"*.csv", "", "*.csv"},
+		{"runs/[0-9]/events", "runs", "[0-9]/events"},
+	}
+	for _, test := range tests {
+		base, rest, err := Split(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if base != test.base || rest.String() != test.rest {
+			t.Errorf(
+				"Split(%#v) = %#v, %#v, want %#v, %#v",
+				test.pattern,
+				base,
+				rest.String(),
+				test.base,
+				test.rest,
+			)
+		}
+	}
+	for _, pattern := range []string{"reports/index.html", "../*.html"} {
+		if _, _, err := Split(pattern); err == nil {
+			t.Fatalf("expected error for Split(%#v)", pattern)
+		}
+	}
+}
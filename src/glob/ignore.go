@@ -0,0 +1,87 @@
+package glob
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// An IgnoreList is a list of gitignore-style patterns. A pattern without a
+// slash (other than a trailing one) matches a name at any depth, and any
+// other pattern is relative to the root of the list. A trailing slash only
+// matches directories, and a leading "!" re-includes paths matched by
+// earlier patterns. The last matching pattern wins.
+type IgnoreList struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	pattern Pattern
+	negate  bool
+	dirOnly bool
+}
+
+// CompileIgnore parses the given gitignore-style patterns. Blank lines and
+// lines starting with "#" are skipped.
+func CompileIgnore(lines []string) (IgnoreList, error) {
+	var list IgnoreList
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		raw := line
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			return IgnoreList{}, fmt.Errorf("invalid ignore pattern %#v: pattern is empty", raw)
+		}
+		if strings.HasPrefix(line, "/") {
+			line = line[1:]
+		} else if !strings.Contains(line, "/") {
+			line = "**/" + line
+		}
+		pattern, err := Compile(line)
+		if err != nil {
+			return IgnoreList{}, fmt.Errorf("invalid ignore pattern %#v: %v", raw, err)
+		}
+		pattern.raw = raw
+		rule.pattern = pattern
+		list.rules = append(list.rules, rule)
+	}
+	return list, nil
+}
+
+// Empty returns true if the list has no patterns.
+func (list IgnoreList) Empty() bool {
+	return len(list.rules) == 0
+}
+
+// Ignored returns true if the slash-separated path name, relative to the
+// root of the list, is ignored. isDir reports whether name is a directory.
+func (list IgnoreList) Ignored(name string, isDir bool) bool {
+	ignored := false
+	for _, rule := range list.rules {
+		if rule.match(name, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func (rule ignoreRule) match(name string, isDir bool) bool {
+	if !rule.dirOnly || isDir {
+		return rule.pattern.Match(name)
+	}
+	// A file only matches a directory pattern through its parents, which
+	// are directories.
+	parent := path.Dir(path.Clean(name))
+	return parent != "." && rule.pattern.Match(parent)
+}
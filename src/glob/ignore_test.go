@@ -0,0 +1,62 @@
+package glob
+
+import "testing"
+
+func TestIgnoreList(t *testing.T) {
+	list, err := CompileIgnore([]string{
+		"# comment",
+		"",
+		"*.tmp",
+		"!keep.tmp",
+		"__pycache__/",
+		"/logs",
+		"runs/*/events",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		path          string
+		isDir, ignore bool
+	}
+	tests := []testCase{
+		{"a.tmp", false, true},
+		{"sub/dir/a.tmp", false, true},
+		{"keep.tmp", false, false},
+		{"sub/keep.tmp", false, false},
+		{"a.txt", false, false},
+
+		{"__pycache__", true, true},
+		{"src/__pycache__", true, true},
+		{"src/__pycache__/mod.pyc", false, true},
+		{"__pycache__", false, false},
+
+		{"logs", true, true},
+		{"logs/today.txt", false, true},
+		{"sub/logs", true, false},
+
+		{"runs/1/events", false, true},
+		{"runs/1/other", false, false},
+	}
+	for _, test := range tests {
+		if got := list.Ignored(test.path, test.isDir); got != test.ignore {
+			t.Errorf("Ignored(%#v, %v) = %v, want %v", test.path, test.isDir, got, test.ignore)
+		}
+	}
+}
+
+func TestCompileIgnoreErrors(t *testing.T) {
+	for _, pattern := range []string{"../data", "data/[", "/"} {
+		if _, err := CompileIgnore([]string{pattern}); err == nil {
+			t.Fatalf("expected error for CompileIgnore(%#v)", pattern)
+		}
+	}
+	list, err := CompileIgnore([]string{"# only a comment", "  "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.Empty() {
+		t.Fatal("expected empty list")
+	}
+}
@@ -1,7 +1,10 @@
 package index
 
 import (
+	"fmt"
+
 	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
 	"github.com/kevin-hanselman/dud/src/cache"
 	"github.com/kevin-hanselman/dud/src/strategy"
 	"github.com/pkg/errors"
@@ -61,3 +64,37 @@ func (idx Index) Checkout(
 	delete(inProgress, stagePath)
 	return nil
 }
+
+// CheckoutConcurrently checks out the given Stages and, if recursive is true,
+// all upstream Stages, like Checkout. Up to jobs Artifacts are checked out at
+// once across all Stages. Stages are announced in the same order regardless
+// of jobs.
+func (idx Index) CheckoutConcurrently(
+	stagePaths []string,
+	ch cache.Cache,
+	rootDir string,
+	strat strategy.CheckoutStrategy,
+	recursive bool,
+	jobs int,
+	logger *agglog.AggLogger,
+) error {
+	deps, err := idx.stageDeps(stagePaths, recursive)
+	if err != nil {
+		return err
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	out := newOrderedOutput(deps, func(msg string) { logger.Info.Print(msg) })
+	workers := make(chan struct{}, jobs)
+	return scheduleStages(independentStages(deps), jobs, false, func(stagePath string) error {
+		out.Print(stagePath, fmt.Sprintf("checking out stage %s\n", stagePath))
+		return forEachArtifact(
+			idx[stagePath].Outputs,
+			workers,
+			func(_ string, art *artifact.Artifact) error {
+				return ch.Checkout(rootDir, *art, strat, nil)
+			},
+		)
+	})
+}
@@ -1,6 +1,8 @@
 package index
 
 import (
+	"log"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -353,3 +355,65 @@ func TestCheckout(t *testing.T) {
 		}
 	})
 }
+
+func TestCheckoutConcurrently(t *testing.T) {
+	strat := strategy.LinkStrategy
+	rootDir := "project/root"
+
+	stgA := stage.Stage{
+		Outputs: map[string]*artifact.Artifact{
+			"foo.bin": {Path: "foo.bin"},
+		},
+	}
+	stgB := stage.Stage{
+		Inputs: map[string]*artifact.Artifact{
+			"foo.bin": {Path: "foo.bin"},
+		},
+		Outputs: map[string]*artifact.Artifact{
+			"bar.bin":  {Path: "bar.bin"},
+			"bish.bin": {Path: "bish.bin"},
+		},
+	}
+	idx := Index{
+		"foo.yaml": &stgA,
+		"bar.yaml": &stgB,
+	}
+
+	for _, recursive := range []bool{true, false} {
+		name := "recursive"
+		if !recursive {
+			name = "single stage"
+		}
+		t.Run(name, func(t *testing.T) {
+			var infoLog strings.Builder
+			logger := agglog.NewNullLogger()
+			logger.Info = log.New(&infoLog, "", 0)
+
+			mockCache := mocks.Cache{}
+			wantLog := "checking out stage bar.yaml\n"
+			if recursive {
+				expectOutputsCheckedOut(&stgA, &mockCache, rootDir, strat)
+				wantLog = "checking out stage foo.yaml\n" + wantLog
+			}
+			expectOutputsCheckedOut(&stgB, &mockCache, rootDir, strat)
+
+			if err := idx.CheckoutConcurrently(
+				[]string{"bar.yaml"},
+				&mockCache,
+				rootDir,
+				strat,
+				recursive,
+				4,
+				logger,
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			mockCache.AssertExpectations(t)
+
+			if diff := cmp.Diff(wantLog, infoLog.String()); diff != "" {
+				t.Fatalf("log -want +got:\n%s", diff)
+			}
+		})
+	}
+}
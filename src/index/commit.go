@@ -1,6 +1,9 @@
 package index
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/kevin-hanselman/dud/src/agglog"
 	"github.com/kevin-hanselman/dud/src/artifact"
 	"github.com/kevin-hanselman/dud/src/cache"
@@ -90,3 +93,62 @@ func (idx Index) Commit(
 	delete(inProgress, stagePath)
 	return nil
 }
+
+// CommitConcurrently commits the given Stages and all upstream Stages, like
+// Commit. Stages are committed once their upstream Stages are, and up to jobs
+// Artifacts are committed at once across all Stages. Stages are announced in
+// the same order regardless of jobs. Each committed Stage is recorded in
+// committed, even if another Stage fails.
+func (idx Index) CommitConcurrently(
+	stagePaths []string,
+	ch cache.Cache,
+	rootDir string,
+	strat strategy.CheckoutStrategy,
+	jobs int,
+	committed map[string]bool,
+	logger *agglog.AggLogger,
+) error {
+	deps, err := idx.stageDeps(stagePaths, true)
+	if err != nil {
+		return err
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	out := newOrderedOutput(deps, func(msg string) { logger.Info.Print(msg) })
+	workers := make(chan struct{}, jobs)
+	var mutex sync.Mutex
+	return scheduleStages(deps, jobs, false, func(stagePath string) error {
+		stg := idx[stagePath]
+		nonStageInputs := make(map[string]*artifact.Artifact)
+		for artPath, art := range stg.Inputs {
+			ownerPath, upstreamArt := idx.findOwner(artPath)
+			if ownerPath == "" {
+				// See Commit.
+				art.SkipCache = true
+				nonStageInputs[artPath] = art
+			} else {
+				art.Checksum = upstreamArt.Checksum
+			}
+		}
+		out.Print(stagePath, fmt.Sprintf("committing stage %s\n", stagePath))
+		commitArtifact := func(_ string, art *artifact.Artifact) error {
+			return ch.Commit(rootDir, art, strat, logger)
+		}
+		if err := forEachArtifact(nonStageInputs, workers, commitArtifact); err != nil {
+			return err
+		}
+		if err := forEachArtifact(stg.Outputs, workers, commitArtifact); err != nil {
+			return err
+		}
+		var err error
+		stg.Checksum, err = stg.CalculateChecksum()
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		committed[stagePath] = true
+		mutex.Unlock()
+		return nil
+	})
+}
@@ -1,6 +1,11 @@
 package index
 
 import (
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -313,3 +318,147 @@ func TestCommit(t *testing.T) {
 		}
 	})
 }
+
+// concurrentCommitCache commits Artifacts like mockCommit. Unlike mocks.Cache,
+// it doesn't read the Artifacts passed to Commit, which would race with
+// concurrent commits.
+type concurrentCommitCache struct {
+	mocks.Cache
+	mutex     sync.Mutex
+	committed []string
+	fail      map[string]bool
+}
+
+func (ch *concurrentCommitCache) Commit(
+	_ string,
+	art *artifact.Artifact,
+	_ strategy.CheckoutStrategy,
+	_ *agglog.AggLogger,
+) error {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+	if ch.fail[art.Path] {
+		return errors.New("disk full")
+	}
+	ch.committed = append(ch.committed, art.Path)
+	art.Checksum = "committed"
+	return nil
+}
+
+func TestCommitConcurrently(t *testing.T) {
+	strat := strategy.LinkStrategy
+	rootDir := "project/root"
+
+	t.Run("upstream stages are committed first", func(t *testing.T) {
+		var infoLog strings.Builder
+		logger := agglog.NewNullLogger()
+		logger.Info = log.New(&infoLog, "", 0)
+
+		// stgA <-- stgC
+		// stgB <---|
+		linkedA := artifact.Artifact{Path: "a.bin"}
+		linkedB := artifact.Artifact{Path: "b.bin"}
+		stgA := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"a.bin": {Path: "a.bin"},
+			},
+		}
+		stgB := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"b.bin": {Path: "b.bin"},
+			},
+		}
+		stgC := stage.Stage{
+			Inputs: map[string]*artifact.Artifact{
+				"a.bin": &linkedA,
+				"b.bin": &linkedB,
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"c1.bin": {Path: "c1.bin"},
+				"c2.bin": {Path: "c2.bin"},
+			},
+		}
+		idx := Index{
+			"a.yaml": &stgA,
+			"b.yaml": &stgB,
+			"c.yaml": &stgC,
+		}
+
+		ch := concurrentCommitCache{}
+		committed := make(map[string]bool)
+		if err := idx.CommitConcurrently(
+			[]string{"c.yaml"},
+			&ch,
+			rootDir,
+			strat,
+			4,
+			committed,
+			logger,
+		); err != nil {
+			t.Fatal(err)
+		}
+
+		sort.Strings(ch.committed)
+		wantCommitted := []string{"a.bin", "b.bin", "c1.bin", "c2.bin"}
+		if diff := cmp.Diff(wantCommitted, ch.committed); diff != "" {
+			t.Fatalf("committed Artifacts -want +got:\n%s", diff)
+		}
+
+		if linkedA.Checksum != "committed" || linkedB.Checksum != "committed" {
+			t.Fatalf("expected linked inputs to have upstream checksums, got %#v and %#v",
+				linkedA.Checksum, linkedB.Checksum)
+		}
+		if stgC.Checksum == "" {
+			t.Fatal("expected stgC to have Checksum set")
+		}
+
+		expectedCommitSet := map[string]bool{
+			"a.yaml": true,
+			"b.yaml": true,
+			"c.yaml": true,
+		}
+		if diff := cmp.Diff(expectedCommitSet, committed); diff != "" {
+			t.Fatalf("committed -want +got:\n%s", diff)
+		}
+
+		wantLog := "committing stage a.yaml\n" +
+			"committing stage b.yaml\n" +
+			"committing stage c.yaml\n"
+		if diff := cmp.Diff(wantLog, infoLog.String()); diff != "" {
+			t.Fatalf("log -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("failed stages aren't recorded", func(t *testing.T) {
+		logger := agglog.NewNullLogger()
+		stgA := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"a.bin": {Path: "a.bin"},
+			},
+		}
+		stgB := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"b.bin": {Path: "b.bin"},
+			},
+		}
+		idx := Index{"a.yaml": &stgA, "b.yaml": &stgB}
+
+		ch := concurrentCommitCache{fail: map[string]bool{"b.bin": true}}
+		committed := make(map[string]bool)
+		err := idx.CommitConcurrently(
+			[]string{"a.yaml", "b.yaml"},
+			&ch,
+			rootDir,
+			strat,
+			1,
+			committed,
+			logger,
+		)
+		if err == nil || err.Error() != "disk full" {
+			t.Fatalf("expected commit error, got %v", err)
+		}
+		if diff := cmp.Diff(map[string]bool{"a.yaml": true}, committed); diff != "" {
+			t.Fatalf("committed -want +got:\n%s", diff)
+		}
+	})
+}
@@ -12,7 +12,7 @@ func (idx Index) Fetch(
 	ch cache.Cache,
 	rootDir string,
 	recursive bool,
-	remote string,
+	remote cache.Remote,
 	fetched map[string]bool,
 	inProgress map[string]bool,
 	logger *agglog.AggLogger,
@@ -51,7 +51,7 @@ func (idx Index) Fetch(
 		}
 	}
 	logger.Info.Printf("fetching stage %s\n", stagePath)
-	// Call Fetch on all Outputs at once to minimize the number of rclone calls.
+	// Call Fetch on all Outputs at once so the remote can batch transfers.
 	if err := ch.Fetch(remote, stg.Outputs); err != nil {
 		return err
 	}
@@ -127,4 +127,31 @@ func TestFindOwner(t *testing.T) {
 			t.Fatalf("artifact -want +got:\n%s", diff)
 		}
 	})
+
+	t.Run("file matched by glob artifact", func(t *testing.T) {
+		targetArt := artifact.Artifact{Path: "reports/*.html", IsDir: true}
+		idx := Index{
+			"foo.yaml": &stage.Stage{
+				Outputs: map[string]*artifact.Artifact{
+					"reports/*.html": &targetArt,
+				},
+			},
+		}
+
+		owner, foundArt := idx.findOwner("reports/index.html")
+
+		if owner != "foo.yaml" {
+			t.Fatalf("got owner = %#v, want foo.yaml", owner)
+		}
+
+		if diff := cmp.Diff(&targetArt, foundArt); diff != "" {
+			t.Fatalf("artifact -want +got:\n%s", diff)
+		}
+
+		owner, _ = idx.findOwner("reports/index.css")
+
+		if owner != "" {
+			t.Fatalf("got owner = %#v, want empty string", owner)
+		}
+	})
 }
@@ -12,7 +12,7 @@ func (idx Index) Push(
 	ch cache.Cache,
 	rootDir string,
 	recursive bool,
-	remote string,
+	remote cache.Remote,
 	pushed map[string]bool,
 	inProgress map[string]bool,
 	logger *agglog.AggLogger,
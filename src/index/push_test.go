@@ -6,6 +6,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/kevin-hanselman/dud/src/agglog"
 	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/cache"
 	"github.com/kevin-hanselman/dud/src/mocks"
 	"github.com/kevin-hanselman/dud/src/stage"
 )
@@ -13,15 +14,18 @@ import (
 func expectOutputsPushed(
 	stg *stage.Stage,
 	mockCache *mocks.Cache,
-	rootDir,
-	remote string,
+	rootDir string,
+	remote cache.Remote,
 ) {
 	mockCache.On("Push", remote, stg.Outputs).Return(nil).Once()
 }
 
 func TestPush(t *testing.T) {
 	rootDir := "project/root"
-	remote := "my_remote:my_bucket"
+	remote, err := cache.NewRemote("my_remote:my_bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// TODO: Consider checking the logs instead of throwing them away.
 	logger := agglog.NewNullLogger()
@@ -1,10 +1,16 @@
 package index
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"os/exec"
+	"sync"
+	"time"
 
 	"github.com/kevin-hanselman/dud/src/agglog"
 	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/runlog"
 	"github.com/pkg/errors"
 )
 
@@ -13,7 +19,8 @@ var runCommand = func(cmd *exec.Cmd) error {
 	return cmd.Run()
 }
 
-// Run runs a Stage and all upstream Stages.
+// Run runs a Stage and all upstream Stages. If logs is non-nil, the output
+// of Stage commands is also saved in logs.
 func (idx Index) Run(
 	stagePath string,
 	ch cache.Cache,
@@ -21,6 +28,7 @@ func (idx Index) Run(
 	recursive bool,
 	ran map[string]bool,
 	inProgress map[string]bool,
+	logs *runlog.Store,
 	logger *agglog.AggLogger,
 ) error {
 	if _, ok := ran[stagePath]; ok {
@@ -37,20 +45,58 @@ func (idx Index) Run(
 		return unknownStageError{stagePath}
 	}
 
+	// Always check all upstream stages.
+	if recursive {
+		for artPath := range stg.Inputs {
+			ownerPath, _ := idx.findOwner(artPath)
+			if ownerPath == "" {
+				continue
+			}
+			if err := idx.Run(ownerPath, ch, rootDir, recursive, ran, inProgress, logs, logger); err != nil {
+				return err
+			}
+		}
+	}
+
+	doRun, runReason, err := idx.checkStage(
+		stagePath,
+		ch,
+		rootDir,
+		recursive,
+		func(ownerPath string) bool { return ran[ownerPath] },
+	)
+	if err != nil {
+		return err
+	}
+	if err := idx.executeStage(stagePath, doRun, runReason, nil, logs, logger); err != nil {
+		return err
+	}
+	ran[stagePath] = doRun
+	delete(inProgress, stagePath)
+	return nil
+}
+
+// checkStage determines whether a Stage needs to run, and why. If recursive is
+// true, upstreamRan must report whether the Stage owning an input ran.
+func (idx Index) checkStage(
+	stagePath string,
+	ch cache.Cache,
+	rootDir string,
+	recursive bool,
+	upstreamRan func(ownerPath string) bool,
+) (doRun bool, runReason string, err error) {
+	stg := idx[stagePath]
 	hasCommand := stg.Command != ""
 	checksumUpToDate := false
 
 	if stg.Checksum != "" {
 		realChecksum, err := stg.CalculateChecksum()
 		if err != nil {
-			return err
+			return false, "", err
 		}
 		checksumUpToDate = realChecksum == stg.Checksum
 	}
 
-	doRun := false
-	var runReason string
-
 	// Run if we have a command and no inputs.
 	if hasCommand && (len(stg.Inputs) == 0) {
 		doRun = true
@@ -63,26 +109,20 @@ func (idx Index) Run(
 		runReason = "definition modified"
 	}
 
-	// Always check all upstream stages.
 	for artPath, art := range stg.Inputs {
 		ownerPath, _ := idx.findOwner(artPath)
 		if ownerPath == "" {
 			artStatus, err := ch.Status(rootDir, *art, true)
 			if err != nil {
-				return err
+				return false, "", err
 			}
 			if !artStatus.ContentsMatch {
 				doRun = true
 				runReason = "input out-of-date"
 			}
-		} else if recursive {
-			if err := idx.Run(ownerPath, ch, rootDir, recursive, ran, inProgress, logger); err != nil {
-				return err
-			}
-			if ran[ownerPath] {
-				doRun = true
-				runReason = "upstream stage out-of-date"
-			}
+		} else if recursive && upstreamRan(ownerPath) {
+			doRun = true
+			runReason = "upstream stage out-of-date"
 		}
 	}
 
@@ -90,7 +130,7 @@ func (idx Index) Run(
 		for _, art := range stg.Outputs {
 			artStatus, err := ch.Status(rootDir, *art, true)
 			if err != nil {
-				return err
+				return false, "", err
 			}
 			if !artStatus.ContentsMatch {
 				doRun = true
@@ -99,22 +139,147 @@ func (idx Index) Run(
 			}
 		}
 	}
-	if doRun {
-		if hasCommand {
-			logger.Info.Printf("running stage %s (%s)\n", stagePath, runReason)
-			cmd := stg.CreateCommand()
-			// Avoid cmd.Command here because it will include "sh -c ...".
-			logger.Debug.Printf("(in %s) %s\n", cmd.Dir, stg.Command)
-			if err := runCommand(cmd); err != nil {
-				return err
-			}
-		} else {
-			logger.Info.Printf("nothing to do for stage %s (%s, but no command)\n", stagePath, runReason)
-		}
-	} else {
+	return doRun, runReason, nil
+}
+
+// executeStage runs a Stage's command if doRun is true, and logs what it did.
+// If outputMutex is non-nil, the command's output is prefixed with the
+// Stage's path, line by line, and lines are written while holding
+// outputMutex. If logs is non-nil, the command's output is also saved in a
+// new log.
+func (idx Index) executeStage(
+	stagePath string,
+	doRun bool,
+	runReason string,
+	outputMutex *sync.Mutex,
+	logs *runlog.Store,
+	logger *agglog.AggLogger,
+) error {
+	stg := idx[stagePath]
+	if !doRun {
 		logger.Info.Printf("nothing to do for stage %s (up-to-date)\n", stagePath)
+		return nil
 	}
-	ran[stagePath] = doRun
-	delete(inProgress, stagePath)
-	return nil
+	if stg.Command == "" {
+		logger.Info.Printf("nothing to do for stage %s (%s, but no command)\n", stagePath, runReason)
+		return nil
+	}
+	logger.Info.Printf("running stage %s (%s)\n", stagePath, runReason)
+	cmd := stg.CreateCommand()
+	// Avoid cmd.Command here because it will include "sh -c ...".
+	logger.Debug.Printf("(in %s) %s\n", cmd.Dir, stg.Command)
+	var stdout, stderr *prefixWriter
+	if outputMutex != nil {
+		prefix := fmt.Sprintf("[%s] ", stagePath)
+		stdout = &prefixWriter{out: cmd.Stdout, prefix: prefix, mutex: outputMutex}
+		stderr = &prefixWriter{out: cmd.Stderr, prefix: prefix, mutex: outputMutex}
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+	}
+	if logs != nil {
+		logFile, err := logs.Create(stagePath, time.Now())
+		if err != nil {
+			return errors.Wrapf(err, "stage %s", stagePath)
+		}
+		defer logFile.Close()
+		logger.Debug.Printf("saving output of stage %s in %s\n", stagePath, logFile.Name())
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, logFile)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, logFile)
+	}
+	err := runCommand(cmd)
+	if outputMutex == nil {
+		return err
+	}
+	stdout.Flush()
+	stderr.Flush()
+	return errors.Wrapf(err, "stage %s", stagePath)
+}
+
+// RunConcurrently runs the given Stages and, if recursive is true, all Stages
+// upstream of them. Up to jobs Stages run at once; a Stage is started once
+// all of its upstream Stages are done. Unless keepGoing is true, no more
+// Stages are started after a Stage fails. Stages downstream of a failed Stage
+// are never run. If logs is non-nil, the output of Stage commands is also
+// saved in logs.
+func (idx Index) RunConcurrently(
+	stagePaths []string,
+	ch cache.Cache,
+	rootDir string,
+	recursive bool,
+	jobs int,
+	keepGoing bool,
+	logs *runlog.Store,
+	logger *agglog.AggLogger,
+) error {
+	deps, err := idx.stageDeps(stagePaths, recursive)
+	if err != nil {
+		return err
+	}
+	var ranMutex, outputMutex sync.Mutex
+	ran := make(map[string]bool, len(deps))
+	upstreamRan := func(ownerPath string) bool {
+		ranMutex.Lock()
+		defer ranMutex.Unlock()
+		return ran[ownerPath]
+	}
+	return scheduleStages(deps, jobs, keepGoing, func(stagePath string) error {
+		doRun, runReason, err := idx.checkStage(stagePath, ch, rootDir, recursive, upstreamRan)
+		if err != nil {
+			return errors.Wrapf(err, "stage %s", stagePath)
+		}
+		if err := idx.executeStage(stagePath, doRun, runReason, &outputMutex, logs, logger); err != nil {
+			return err
+		}
+		ranMutex.Lock()
+		ran[stagePath] = doRun
+		ranMutex.Unlock()
+		return nil
+	})
+}
+
+// prefixWriter writes the lines written to it to out, each line preceded by
+// prefix. Partial lines are held until they're completed or Flush is called.
+type prefixWriter struct {
+	out    io.Writer
+	prefix string
+	mutex  *sync.Mutex
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	end := bytes.LastIndexByte(w.buf, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+	lines := w.buf[:end+1]
+	if err := w.writeLines(lines); err != nil {
+		return 0, err
+	}
+	w.buf = append(w.buf[:0], w.buf[end+1:]...)
+	return len(p), nil
+}
+
+// Flush writes any partial line held by the prefixWriter.
+func (w *prefixWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.writeLines(append(w.buf, '\n'))
+	w.buf = nil
+	return err
+}
+
+func (w *prefixWriter) writeLines(lines []byte) error {
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(lines, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		out.WriteString(w.prefix)
+		out.Write(line)
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, err := w.out.Write(out.Bytes())
+	return err
 }
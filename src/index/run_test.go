@@ -1,10 +1,14 @@
 package index
 
 import (
+	"errors"
+	"io"
 	"log"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -12,6 +16,7 @@ import (
 	"github.com/kevin-hanselman/dud/src/artifact"
 	"github.com/kevin-hanselman/dud/src/fsutil"
 	"github.com/kevin-hanselman/dud/src/mocks"
+	"github.com/kevin-hanselman/dud/src/runlog"
 	"github.com/kevin-hanselman/dud/src/stage"
 )
 
@@ -93,7 +98,7 @@ func TestRun(t *testing.T) {
 
 		ran := make(map[string]bool)
 		inProgress := make(map[string]bool)
-		if err := idx.Run("foo.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("foo.yaml", &mockCache, rootDir, true, ran, inProgress, nil, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -132,7 +137,7 @@ func TestRun(t *testing.T) {
 
 		ran := make(map[string]bool)
 		inProgress := make(map[string]bool)
-		if err := idx.Run("foo.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("foo.yaml", &mockCache, rootDir, true, ran, inProgress, nil, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -173,7 +178,7 @@ func TestRun(t *testing.T) {
 
 		ran := make(map[string]bool)
 		inProgress := make(map[string]bool)
-		if err := idx.Run("foo.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("foo.yaml", &mockCache, rootDir, true, ran, inProgress, nil, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -214,7 +219,7 @@ func TestRun(t *testing.T) {
 
 		ran := make(map[string]bool)
 		inProgress := make(map[string]bool)
-		if err := idx.Run("foo.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("foo.yaml", &mockCache, rootDir, true, ran, inProgress, nil, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -267,7 +272,7 @@ func TestRun(t *testing.T) {
 
 		ran := make(map[string]bool)
 		inProgress := make(map[string]bool)
-		if err := idx.Run("bar.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("bar.yaml", &mockCache, rootDir, true, ran, inProgress, nil, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -323,7 +328,7 @@ func TestRun(t *testing.T) {
 
 		ran := make(map[string]bool)
 		inProgress := make(map[string]bool)
-		if err := idx.Run("bar.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("bar.yaml", &mockCache, rootDir, true, ran, inProgress, nil, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -380,7 +385,7 @@ func TestRun(t *testing.T) {
 
 		ran := make(map[string]bool)
 		inProgress := make(map[string]bool)
-		if err := idx.Run("bar.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("bar.yaml", &mockCache, rootDir, true, ran, inProgress, nil, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -445,7 +450,7 @@ func TestRun(t *testing.T) {
 
 		ran := make(map[string]bool)
 		inProgress := make(map[string]bool)
-		if err := idx.Run("bosh.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("bosh.yaml", &mockCache, rootDir, true, ran, inProgress, nil, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -531,7 +536,7 @@ func TestRun(t *testing.T) {
 
 		ran := make(map[string]bool)
 		inProgress := make(map[string]bool)
-		err := idx.Run("c.yaml", &mockCache, rootDir, true, ran, inProgress, logger)
+		err := idx.Run("c.yaml", &mockCache, rootDir, true, ran, inProgress, nil, logger)
 		if err == nil {
 			t.Fatal("expected error")
 		}
@@ -580,7 +585,7 @@ func TestRun(t *testing.T) {
 
 		ran := make(map[string]bool)
 		inProgress := make(map[string]bool)
-		if err := idx.Run("bosh.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("bosh.yaml", &mockCache, rootDir, true, ran, inProgress, nil, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -633,7 +638,7 @@ func TestRun(t *testing.T) {
 
 		ran := make(map[string]bool)
 		inProgress := make(map[string]bool)
-		if err := idx.Run("bar.yaml", &mockCache, rootDir, false, ran, inProgress, logger); err != nil {
+		if err := idx.Run("bar.yaml", &mockCache, rootDir, false, ran, inProgress, nil, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -688,7 +693,7 @@ func TestRun(t *testing.T) {
 
 		ran := make(map[string]bool)
 		inProgress := make(map[string]bool)
-		if err := idx.Run("bar.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("bar.yaml", &mockCache, rootDir, true, ran, inProgress, nil, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -713,3 +718,176 @@ func TestRun(t *testing.T) {
 		}
 	})
 }
+
+func TestRunConcurrently(t *testing.T) {
+	rootDir := "project/root"
+
+	var (
+		mutex    sync.Mutex
+		commands []string
+	)
+	failCommand := "exit 1"
+	runCommandOrig := runCommand
+	runCommand = func(cmd *exec.Cmd) error {
+		lastArg := cmd.Args[len(cmd.Args)-1]
+		mutex.Lock()
+		commands = append(commands, lastArg)
+		mutex.Unlock()
+		if lastArg == failCommand {
+			return errors.New("exit status 1")
+		}
+		return nil
+	}
+	defer func() { runCommand = runCommandOrig }()
+
+	var infoLog strings.Builder
+	logger := agglog.NewNullLogger()
+
+	resetTestHarness := func() {
+		commands = nil
+		infoLog = strings.Builder{}
+		logger.Info = log.New(&infoLog, "", 0)
+	}
+
+	// newIndex returns a Stage A with no inputs and a Stage B downstream of
+	// it. Both Stages always run.
+	newIndex := func(commandA string, t *testing.T) Index {
+		stgA := stage.Stage{
+			Command: commandA,
+			Outputs: map[string]*artifact.Artifact{
+				"a.bin": {Path: "a.bin"},
+			},
+		}
+		stgB := stage.Stage{
+			Command: "echo b",
+			Inputs: map[string]*artifact.Artifact{
+				"a.bin": {Path: "a.bin"},
+			},
+		}
+		idx := Index{"a.yaml": &stgA, "b.yaml": &stgB}
+		for _, stg := range idx {
+			var err error
+			stg.Checksum, err = stg.CalculateChecksum()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return idx
+	}
+
+	t.Run("upstream stages run first", func(t *testing.T) {
+		resetTestHarness()
+		idx := newIndex("echo a", t)
+		mockCache := mocks.Cache{}
+		err := idx.RunConcurrently([]string{"b.yaml"}, &mockCache, rootDir, true, 4, false, nil, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mockCache.AssertExpectations(t)
+
+		if diff := cmp.Diff([]string{"echo a", "echo b"}, commands); diff != "" {
+			t.Fatalf("commands -want +got:\n%s", diff)
+		}
+		wantLog := "running stage a.yaml (has command and no inputs)\n" +
+			"running stage b.yaml (upstream stage out-of-date)\n"
+		if diff := cmp.Diff(wantLog, infoLog.String()); diff != "" {
+			t.Fatalf("log -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("downstream stages don't run after failure", func(t *testing.T) {
+		resetTestHarness()
+		idx := newIndex(failCommand, t)
+		mockCache := mocks.Cache{}
+		err := idx.RunConcurrently([]string{"b.yaml"}, &mockCache, rootDir, true, 4, true, nil, logger)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if diff := cmp.Diff("stage a.yaml: exit status 1", err.Error()); diff != "" {
+			t.Fatalf("error -want +got:\n%s", diff)
+		}
+		if diff := cmp.Diff([]string{failCommand}, commands); diff != "" {
+			t.Fatalf("commands -want +got:\n%s", diff)
+		}
+	})
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out strings.Builder
+	w := &prefixWriter{out: &out, prefix: "[a.yaml] ", mutex: &sync.Mutex{}}
+	for _, chunk := range []string{"one\ntw", "o\n", "\nthr", "ee"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if diff := cmp.Diff("[a.yaml] one\n[a.yaml] two\n[a.yaml] \n", out.String()); diff != "" {
+		t.Fatalf("output before Flush -want +got:\n%s", diff)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "[a.yaml] one\n[a.yaml] two\n[a.yaml] \n[a.yaml] three\n"
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Fatalf("output -want +got:\n%s", diff)
+	}
+}
+
+func TestRunLogs(t *testing.T) {
+	runCommandOrig := runCommand
+	runCommand = func(cmd *exec.Cmd) error {
+		if _, err := io.WriteString(cmd.Stdout, "to stdout\n"); err != nil {
+			return err
+		}
+		_, err := io.WriteString(cmd.Stderr, "to stderr\n")
+		return err
+	}
+	defer func() { runCommand = runCommandOrig }()
+
+	stg := stage.Stage{Command: "echo hello"}
+	var err error
+	stg.Checksum, err = stg.CalculateChecksum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := Index{"foo.yaml": &stg}
+	logger := agglog.NewNullLogger()
+
+	assertLogged := func(t *testing.T, logs *runlog.Store, wantRuns int) {
+		runs, err := logs.List("foo.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) != wantRuns {
+			t.Fatalf("got %d logs, want %d", len(runs), wantRuns)
+		}
+		data, err := os.ReadFile(logs.Path("foo.yaml", runs[len(runs)-1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff("to stdout\nto stderr\n", string(data)); diff != "" {
+			t.Fatalf("log -want +got:\n%s", diff)
+		}
+	}
+
+	t.Run("sequential", func(t *testing.T) {
+		logs := &runlog.Store{Dir: t.TempDir()}
+		ran := make(map[string]bool)
+		inProgress := make(map[string]bool)
+		mockCache := mocks.Cache{}
+		if err := idx.Run("foo.yaml", &mockCache, "root", true, ran, inProgress, logs, logger); err != nil {
+			t.Fatal(err)
+		}
+		assertLogged(t, logs, 1)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		logs := &runlog.Store{Dir: t.TempDir()}
+		mockCache := mocks.Cache{}
+		err := idx.RunConcurrently([]string{"foo.yaml"}, &mockCache, "root", true, 2, false, logs, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Logs don't include the prefixes added for the terminal.
+		assertLogged(t, logs, 1)
+	})
+}
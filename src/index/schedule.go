@@ -0,0 +1,243 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/pkg/errors"
+)
+
+// stageDeps returns the given Stages mapped to the paths of the Stages they
+// depend on, i.e. the owners of their inputs. If recursive is true, all
+// Stages upstream of the given Stages are included as well; otherwise, only
+// the given Stages are included, and they have no dependencies.
+func (idx Index) stageDeps(stagePaths []string, recursive bool) (map[string][]string, error) {
+	deps := make(map[string][]string)
+	inProgress := make(map[string]bool)
+	var visit func(stagePath string) error
+	visit = func(stagePath string) error {
+		if _, ok := deps[stagePath]; ok {
+			return nil
+		}
+		if inProgress[stagePath] {
+			return errors.New("cycle detected")
+		}
+		inProgress[stagePath] = true
+		stg, ok := idx[stagePath]
+		if !ok {
+			return unknownStageError{stagePath}
+		}
+		upstream := []string{}
+		if recursive {
+			owners := make(map[string]bool)
+			for artPath := range stg.Inputs {
+				ownerPath, _ := idx.findOwner(artPath)
+				if ownerPath == "" || owners[ownerPath] {
+					continue
+				}
+				owners[ownerPath] = true
+				if err := visit(ownerPath); err != nil {
+					return err
+				}
+				upstream = append(upstream, ownerPath)
+			}
+			sort.Strings(upstream)
+		}
+		deps[stagePath] = upstream
+		delete(inProgress, stagePath)
+		return nil
+	}
+	for _, stagePath := range stagePaths {
+		if err := visit(stagePath); err != nil {
+			return nil, err
+		}
+	}
+	return deps, nil
+}
+
+// stageErrors collects the errors of several Stages.
+type stageErrors []error
+
+func (errs stageErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	sort.Strings(msgs)
+	return fmt.Sprintf("%d stages failed:\n  %s", len(errs), strings.Join(msgs, "\n  "))
+}
+
+// scheduleStages calls visit for each Stage in deps (see stageDeps) once
+// visit has returned for all of the Stages it depends on. Up to jobs calls to
+// visit run concurrently. Stages that become ready at the same time are
+// started in order of their paths.
+//
+// Stages downstream of a failed Stage are skipped. Unless keepGoing is true,
+// no more Stages are started after the first failure, but Stages already
+// running are waited for. scheduleStages returns the error of the failed
+// Stage, or a stageErrors if several Stages failed.
+func scheduleStages(
+	deps map[string][]string,
+	jobs int,
+	keepGoing bool,
+	visit func(stagePath string) error,
+) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+	waiting := make(map[string]int, len(deps))
+	dependents := make(map[string][]string)
+	ready := []string{}
+	for stagePath, upstream := range deps {
+		waiting[stagePath] = len(upstream)
+		for _, upstreamPath := range upstream {
+			dependents[upstreamPath] = append(dependents[upstreamPath], stagePath)
+		}
+		if len(upstream) == 0 {
+			ready = append(ready, stagePath)
+		}
+	}
+	sort.Strings(ready)
+
+	type result struct {
+		stagePath string
+		err       error
+	}
+	results := make(chan result)
+	running := 0
+	var errs stageErrors
+	for {
+		for len(ready) > 0 && running < jobs && (keepGoing || len(errs) == 0) {
+			stagePath := ready[0]
+			ready = ready[1:]
+			running++
+			go func() {
+				results <- result{stagePath, visit(stagePath)}
+			}()
+		}
+		if running == 0 {
+			break
+		}
+		res := <-results
+		running--
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		newlyReady := []string{}
+		for _, stagePath := range dependents[res.stagePath] {
+			waiting[stagePath]--
+			if waiting[stagePath] == 0 {
+				newlyReady = append(newlyReady, stagePath)
+			}
+		}
+		sort.Strings(newlyReady)
+		ready = append(ready, newlyReady...)
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return errs
+	}
+}
+
+// independentStages returns deps with all dependencies removed, for
+// operations that needn't wait on upstream Stages.
+func independentStages(deps map[string][]string) map[string][]string {
+	independent := make(map[string][]string, len(deps))
+	for stagePath := range deps {
+		independent[stagePath] = nil
+	}
+	return independent
+}
+
+// orderedOutput prints one message per Stage in a fixed order, regardless of
+// the order in which concurrently processed Stages produce their messages. A
+// message is held until the messages of all Stages before it are printed.
+type orderedOutput struct {
+	mutex    sync.Mutex
+	order    []string
+	next     int
+	messages map[string]string
+	write    func(string)
+}
+
+// newOrderedOutput returns an orderedOutput that prints messages in the order
+// scheduleStages visits the Stages in deps when running one job at a time.
+func newOrderedOutput(deps map[string][]string, write func(string)) *orderedOutput {
+	order := make([]string, 0, len(deps))
+	// With one job, visit is never called concurrently, and it never fails.
+	_ = scheduleStages(deps, 1, false, func(stagePath string) error {
+		order = append(order, stagePath)
+		return nil
+	})
+	return &orderedOutput{
+		order:    order,
+		messages: make(map[string]string, len(deps)),
+		write:    write,
+	}
+}
+
+// Print records the message for the Stage at stagePath and prints all
+// messages that are no longer held.
+func (out *orderedOutput) Print(stagePath, message string) {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+	out.messages[stagePath] = message
+	for out.next < len(out.order) {
+		message, ok := out.messages[out.order[out.next]]
+		if !ok {
+			break
+		}
+		out.write(message)
+		out.next++
+	}
+}
+
+// forEachArtifact calls fn for each of the given Artifacts concurrently. Each
+// call holds a slot in workers, which bounds the number of calls running at
+// once across all Stages. If any calls fail, forEachArtifact returns the
+// error for the first failed Artifact in order of their paths.
+func forEachArtifact(
+	arts map[string]*artifact.Artifact,
+	workers chan struct{},
+	fn func(artPath string, art *artifact.Artifact) error,
+) error {
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+	)
+	errs := make(map[string]error)
+	for artPath, art := range arts {
+		artPath, art := artPath, art
+		workers <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			if err := fn(artPath, art); err != nil {
+				mutex.Lock()
+				errs[artPath] = err
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	failed := make([]string, 0, len(errs))
+	for artPath := range errs {
+		failed = append(failed, artPath)
+	}
+	sort.Strings(failed)
+	if len(failed) > 0 {
+		return errs[failed[0]]
+	}
+	return nil
+}
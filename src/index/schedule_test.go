@@ -0,0 +1,225 @@
+package index
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/artifact"
+)
+
+func TestScheduleStages(t *testing.T) {
+	// a <-- b <-- d
+	// ^---- c <---|
+	// e
+	deps := map[string][]string{
+		"a": {},
+		"b": {"a"},
+		"c": {"a"},
+		"d": {"b", "c"},
+		"e": {},
+	}
+
+	// recorder records the order Stages finish in and checks that each Stage
+	// only starts after its dependencies.
+	type recorder struct {
+		mutex      sync.Mutex
+		done       []string
+		running    int
+		maxRunning int
+	}
+	visitor := func(t *testing.T, rec *recorder, fail map[string]bool) func(string) error {
+		return func(stagePath string) error {
+			rec.mutex.Lock()
+			for _, upstream := range deps[stagePath] {
+				found := false
+				for _, done := range rec.done {
+					found = found || done == upstream
+				}
+				if !found {
+					t.Errorf("%s started before %s finished", stagePath, upstream)
+				}
+			}
+			rec.running++
+			if rec.running > rec.maxRunning {
+				rec.maxRunning = rec.running
+			}
+			rec.mutex.Unlock()
+
+			defer func() {
+				rec.mutex.Lock()
+				rec.running--
+				rec.done = append(rec.done, stagePath)
+				rec.mutex.Unlock()
+			}()
+			if fail[stagePath] {
+				return errors.New(stagePath + " failed")
+			}
+			return nil
+		}
+	}
+
+	t.Run("one job visits in dependency order", func(t *testing.T) {
+		rec := &recorder{}
+		if err := scheduleStages(deps, 1, false, visitor(t, rec, nil)); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"a", "e", "b", "c", "d"}
+		if diff := cmp.Diff(want, rec.done); diff != "" {
+			t.Fatalf("done -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("several jobs visit all stages", func(t *testing.T) {
+		rec := &recorder{}
+		if err := scheduleStages(deps, 2, false, visitor(t, rec, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if len(rec.done) != len(deps) {
+			t.Fatalf("visited %v, want all of %v", rec.done, deps)
+		}
+		if rec.maxRunning > 2 {
+			t.Fatalf("%d stages ran at once, want at most 2", rec.maxRunning)
+		}
+	})
+
+	t.Run("failure stops scheduling", func(t *testing.T) {
+		rec := &recorder{}
+		err := scheduleStages(deps, 1, false, visitor(t, rec, map[string]bool{"a": true}))
+		if err == nil || err.Error() != "a failed" {
+			t.Fatalf("expected error from a, got %v", err)
+		}
+		if diff := cmp.Diff([]string{"a"}, rec.done); diff != "" {
+			t.Fatalf("done -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("keep going skips downstream stages", func(t *testing.T) {
+		rec := &recorder{}
+		err := scheduleStages(deps, 1, true, visitor(t, rec, map[string]bool{"b": true}))
+		if err == nil || err.Error() != "b failed" {
+			t.Fatalf("expected error from b, got %v", err)
+		}
+		want := []string{"a", "e", "b", "c"}
+		if diff := cmp.Diff(want, rec.done); diff != "" {
+			t.Fatalf("done -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("keep going reports all failures", func(t *testing.T) {
+		rec := &recorder{}
+		err := scheduleStages(deps, 1, true, visitor(t, rec, map[string]bool{"b": true, "e": true}))
+		want := "2 stages failed:\n  b failed\n  e failed"
+		if err == nil || err.Error() != want {
+			t.Fatalf("error = %v, want %#v", err, want)
+		}
+	})
+}
+
+func TestStageDeps(t *testing.T) {
+	idx := Index{
+		"a.yaml": {Outputs: newArts("a.bin")},
+		"b.yaml": {Inputs: newArts("a.bin"), Outputs: newArts("b.bin")},
+		"c.yaml": {Inputs: newArts("a.bin", "b.bin", "orphan.bin")},
+	}
+
+	t.Run("recursive", func(t *testing.T) {
+		deps, err := idx.stageDeps([]string{"c.yaml"}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string][]string{
+			"a.yaml": {},
+			"b.yaml": {"a.yaml"},
+			"c.yaml": {"a.yaml", "b.yaml"},
+		}
+		if diff := cmp.Diff(want, deps); diff != "" {
+			t.Fatalf("deps -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("not recursive", func(t *testing.T) {
+		deps, err := idx.stageDeps([]string{"c.yaml"}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string][]string{"c.yaml": {}}
+		if diff := cmp.Diff(want, deps); diff != "" {
+			t.Fatalf("deps -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("cycles are prevented", func(t *testing.T) {
+		idx := Index{
+			"a.yaml": {Inputs: newArts("b.bin"), Outputs: newArts("a.bin")},
+			"b.yaml": {Inputs: newArts("a.bin"), Outputs: newArts("b.bin")},
+		}
+		_, err := idx.stageDeps([]string{"a.yaml"}, true)
+		if err == nil || err.Error() != "cycle detected" {
+			t.Fatalf("expected cycle error, got %v", err)
+		}
+	})
+}
+
+func newArts(paths ...string) map[string]*artifact.Artifact {
+	arts := make(map[string]*artifact.Artifact, len(paths))
+	for _, path := range paths {
+		arts[path] = &artifact.Artifact{Path: path}
+	}
+	return arts
+}
+
+func TestOrderedOutput(t *testing.T) {
+	deps := map[string][]string{
+		"a": {},
+		"b": {"a"},
+		"c": {},
+	}
+	var printed []string
+	out := newOrderedOutput(deps, func(msg string) { printed = append(printed, msg) })
+
+	out.Print("c", "c")
+	out.Print("b", "b")
+	if len(printed) != 0 {
+		t.Fatalf("expected messages to be held for a, got %v", printed)
+	}
+	out.Print("a", "a")
+	if diff := cmp.Diff([]string{"a", "c", "b"}, printed); diff != "" {
+		t.Fatalf("printed -want +got:\n%s", diff)
+	}
+}
+
+func TestForEachArtifact(t *testing.T) {
+	arts := newArts("a.bin", "b.bin", "c.bin", "d.bin")
+	workers := make(chan struct{}, 2)
+
+	var (
+		mutex      sync.Mutex
+		running    int
+		maxRunning int
+	)
+	err := forEachArtifact(arts, workers, func(artPath string, _ *artifact.Artifact) error {
+		mutex.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+		defer func() {
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+		}()
+		if artPath == "c.bin" || artPath == "b.bin" {
+			return errors.New(artPath + " failed")
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "b.bin failed" {
+		t.Fatalf("expected error for b.bin, got %v", err)
+	}
+	if maxRunning > cap(workers) {
+		t.Fatalf("%d calls ran at once, want at most %d", maxRunning, cap(workers))
+	}
+}
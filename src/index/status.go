@@ -1,6 +1,9 @@
 package index
 
 import (
+	"sync"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
 	"github.com/kevin-hanselman/dud/src/cache"
 	"github.com/kevin-hanselman/dud/src/stage"
 	"github.com/pkg/errors"
@@ -70,3 +73,72 @@ func (idx Index) Status(
 	delete(inProgress, stagePath)
 	return nil
 }
+
+// StatusConcurrently records the status of the given Stages and all upstream
+// Stages in out, like Status. Up to jobs Artifacts are checked at once across
+// all Stages.
+func (idx Index) StatusConcurrently(
+	stagePaths []string,
+	ch cache.Cache,
+	rootDir string,
+	jobs int,
+	out Status,
+) error {
+	deps, err := idx.stageDeps(stagePaths, true)
+	if err != nil {
+		return err
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	workers := make(chan struct{}, jobs)
+	var mutex sync.Mutex
+	return scheduleStages(independentStages(deps), jobs, false, func(stagePath string) error {
+		stg := idx[stagePath]
+		stageStatus := stage.NewStatus()
+		if stg.Checksum != "" {
+			stageStatus.HasChecksum = true
+			realChecksum, err := stg.CalculateChecksum()
+			if err != nil {
+				return err
+			}
+			stageStatus.ChecksumMatches = realChecksum == stg.Checksum
+		}
+
+		var statusMutex sync.Mutex
+		recordStatus := func(artPath string, art *artifact.Artifact) error {
+			artStatus, err := ch.Status(rootDir, *art, false)
+			if err != nil {
+				return err
+			}
+			statusMutex.Lock()
+			stageStatus.ArtifactStatus[artPath] = artStatus
+			statusMutex.Unlock()
+			return nil
+		}
+
+		nonStageInputs := make(map[string]*artifact.Artifact)
+		for artPath, art := range stg.Inputs {
+			if ownerPath, _ := idx.findOwner(artPath); ownerPath == "" {
+				nonStageInputs[artPath] = art
+			}
+		}
+		if err := forEachArtifact(nonStageInputs, workers, recordStatus); err != nil {
+			return err
+		}
+		err := forEachArtifact(
+			stg.Outputs,
+			workers,
+			func(artPath string, art *artifact.Artifact) error {
+				return errors.Wrapf(recordStatus(artPath, art), "status: %s", art.Path)
+			},
+		)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		out[stagePath] = stageStatus
+		mutex.Unlock()
+		return nil
+	})
+}
@@ -332,3 +332,59 @@ func TestStatus(t *testing.T) {
 		}
 	})
 }
+
+func TestStatusConcurrently(t *testing.T) {
+	upToDate := artifact.Status{
+		WorkspaceFileStatus: fsutil.StatusLink,
+		HasChecksum:         true,
+		ChecksumInCache:     true,
+		ContentsMatch:       true,
+	}
+
+	rootDir := "project/root"
+
+	orphanArt := artifact.Artifact{Path: "bish.bin"}
+	stgA := stage.Stage{
+		Inputs: map[string]*artifact.Artifact{
+			"bish.bin": &orphanArt,
+		},
+		Outputs: map[string]*artifact.Artifact{
+			"foo.bin": {Path: "foo.bin"},
+		},
+	}
+	stgB := stage.Stage{
+		Inputs: map[string]*artifact.Artifact{
+			"foo.bin": {Path: "foo.bin"},
+		},
+		Outputs: map[string]*artifact.Artifact{
+			"bar.bin":  {Path: "bar.bin"},
+			"bash.bin": {Path: "bash.bin"},
+		},
+	}
+	idx := Index{
+		"foo.yaml": &stgA,
+		"bar.yaml": &stgB,
+	}
+
+	mockCache := mocks.Cache{}
+
+	expectedStatus := Status{
+		"foo.yaml": expectStageStatusCalled(&stgA, &mockCache, rootDir, upToDate, false),
+		"bar.yaml": expectStageStatusCalled(&stgB, &mockCache, rootDir, upToDate, false),
+	}
+	orphanArtStatus := upToDate
+	orphanArtStatus.Artifact = orphanArt
+	expectedStatus["foo.yaml"].ArtifactStatus["bish.bin"] = orphanArtStatus
+	mockCache.On("Status", rootDir, orphanArt, false).Return(orphanArtStatus, nil).Once()
+
+	outputStatus := make(Status)
+	err := idx.StatusConcurrently([]string{"bar.yaml"}, &mockCache, rootDir, 4, outputStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockCache.AssertExpectations(t)
+	if diff := cmp.Diff(expectedStatus, outputStatus); diff != "" {
+		t.Fatalf("Stage -want +got:\n%s", diff)
+	}
+}
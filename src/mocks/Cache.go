@@ -6,6 +6,8 @@ import (
 	agglog "github.com/kevin-hanselman/dud/src/agglog"
 	artifact "github.com/kevin-hanselman/dud/src/artifact"
 
+	cache "github.com/kevin-hanselman/dud/src/cache"
+
 	mock "github.com/stretchr/testify/mock"
 
 	pb "github.com/cheggaaa/pb/v3"
@@ -46,13 +48,13 @@ func (_m *Cache) Commit(workDir string, art *artifact.Artifact, s strategy.Check
 	return r0
 }
 
-// Fetch provides a mock function with given fields: remoteSrc, arts
-func (_m *Cache) Fetch(remoteSrc string, arts map[string]*artifact.Artifact) error {
-	ret := _m.Called(remoteSrc, arts)
+// Fetch provides a mock function with given fields: remote, arts
+func (_m *Cache) Fetch(remote cache.Remote, arts map[string]*artifact.Artifact) error {
+	ret := _m.Called(remote, arts)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, map[string]*artifact.Artifact) error); ok {
-		r0 = rf(remoteSrc, arts)
+	if rf, ok := ret.Get(0).(func(cache.Remote, map[string]*artifact.Artifact) error); ok {
+		r0 = rf(remote, arts)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -60,13 +62,13 @@ func (_m *Cache) Fetch(remoteSrc string, arts map[string]*artifact.Artifact) err
 	return r0
 }
 
-// Push provides a mock function with given fields: remoteDst, arts
-func (_m *Cache) Push(remoteDst string, arts map[string]*artifact.Artifact) error {
-	ret := _m.Called(remoteDst, arts)
+// Push provides a mock function with given fields: remote, arts
+func (_m *Cache) Push(remote cache.Remote, arts map[string]*artifact.Artifact) error {
+	ret := _m.Called(remote, arts)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, map[string]*artifact.Artifact) error); ok {
-		r0 = rf(remoteDst, arts)
+	if rf, ok := ret.Get(0).(func(cache.Remote, map[string]*artifact.Artifact) error); ok {
+		r0 = rf(remote, arts)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -0,0 +1,93 @@
+// Package runlog keeps logs of the output of Stage commands.
+package runlog
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// timeFormat is the format of log file names, without the extension. Names
+// sort in the order the logs were created.
+const timeFormat = "20060102T150405.000Z"
+
+const logExt = ".log"
+
+// A Store keeps logs of Stage commands in a directory per Stage under Dir.
+type Store struct {
+	Dir string
+	// Retention is the number of logs kept per Stage. If it's less than one,
+	// all logs are kept.
+	Retention int
+}
+
+// stageDir returns the directory containing the logs of the Stage at
+// stagePath.
+func (store Store) stageDir(stagePath string) string {
+	return filepath.Join(store.Dir, stagePath)
+}
+
+// Create creates a log file for a run of the Stage at stagePath started at
+// now. The Stage's oldest logs are removed to make room for it.
+func (store Store) Create(stagePath string, now time.Time) (*os.File, error) {
+	dir := store.stageDir(stagePath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "create log")
+	}
+	if err := store.prune(stagePath, 1); err != nil {
+		return nil, err
+	}
+	logPath := filepath.Join(dir, now.UTC().Format(timeFormat)+logExt)
+	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	return file, errors.Wrap(err, "create log")
+}
+
+// prune removes the oldest logs of the Stage at stagePath, so that reserve
+// more logs can be created without exceeding store.Retention.
+func (store Store) prune(stagePath string, reserve int) error {
+	if store.Retention < 1 {
+		return nil
+	}
+	runs, err := store.List(stagePath)
+	if err != nil {
+		return err
+	}
+	for len(runs) > 0 && len(runs)+reserve > store.Retention {
+		if err := os.Remove(store.Path(stagePath, runs[0])); err != nil {
+			return errors.Wrap(err, "remove old log")
+		}
+		runs = runs[1:]
+	}
+	return nil
+}
+
+// List returns the names of the logged runs of the Stage at stagePath,
+// oldest first.
+func (store Store) List(stagePath string) ([]string, error) {
+	entries, err := os.ReadDir(store.stageDir(stagePath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "list logs")
+	}
+	runs := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasSuffix(name, logExt) {
+			runs = append(runs, strings.TrimSuffix(name, logExt))
+		}
+	}
+	sort.Strings(runs)
+	return runs, nil
+}
+
+// Path returns the path of the log of the given run (as returned by List) of
+// the Stage at stagePath.
+func (store Store) Path(stagePath, run string) string {
+	return filepath.Join(store.stageDir(stagePath), run+logExt)
+}
@@ -0,0 +1,84 @@
+package runlog
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStore(t *testing.T) {
+	start := time.Date(2021, 3, 4, 5, 6, 7, 8_000_000, time.UTC)
+
+	createLogs := func(t *testing.T, store Store, stagePath string, count int) {
+		for i := 0; i < count; i++ {
+			file, err := store.Create(stagePath, start.Add(time.Duration(i)*time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := file.WriteString("output\n"); err != nil {
+				t.Fatal(err)
+			}
+			if err := file.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("list runs oldest first", func(t *testing.T) {
+		store := Store{Dir: t.TempDir()}
+		createLogs(t, store, "sub/foo.yaml", 3)
+		runs, err := store.List("sub/foo.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			"20210304T050607.008Z",
+			"20210304T050707.008Z",
+			"20210304T050807.008Z",
+		}
+		if diff := cmp.Diff(want, runs); diff != "" {
+			t.Fatalf("List -want +got:\n%s", diff)
+		}
+		data, err := os.ReadFile(store.Path("sub/foo.yaml", runs[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "output\n" {
+			t.Fatalf("log contents = %#v, want %#v", string(data), "output\n")
+		}
+	})
+
+	t.Run("no logs", func(t *testing.T) {
+		store := Store{Dir: t.TempDir()}
+		runs, err := store.List("foo.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) != 0 {
+			t.Fatalf("expected no runs, got %v", runs)
+		}
+	})
+
+	t.Run("oldest logs are removed", func(t *testing.T) {
+		store := Store{Dir: t.TempDir(), Retention: 2}
+		createLogs(t, store, "foo.yaml", 4)
+		createLogs(t, store, "bar.yaml", 1)
+		runs, err := store.List("foo.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"20210304T050807.008Z", "20210304T050907.008Z"}
+		if diff := cmp.Diff(want, runs); diff != "" {
+			t.Fatalf("List -want +got:\n%s", diff)
+		}
+		runs, err = store.List("bar.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) != 1 {
+			t.Fatalf("expected other Stages' logs to be kept, got %v", runs)
+		}
+	})
+}
@@ -133,6 +133,44 @@ func TestCalculateChecksum(t *testing.T) {
 		}
 	})
 
+	t.Run("artifact exclude patterns should affect checksum", func(t *testing.T) {
+		stg := newStage()
+		originalChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stg.Inputs["b"].Exclude = []string{"*.tmp"}
+
+		newChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if originalChecksum == newChecksum {
+			t.Fatal("changing artifact exclude patterns should have affected checksum")
+		}
+	})
+
+	t.Run("artifact executable bits and chunking should not affect checksum", func(t *testing.T) {
+		stg := newStage()
+		originalChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stg.Outputs["foo.txt"].Executable = true
+		stg.Outputs["foo.txt"].IsChunked = true
+
+		newChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(originalChecksum, newChecksum); diff != "" {
+			t.Fatalf("CalculateChecksum -want +got:\n%s", diff)
+		}
+	})
+
 	t.Run("artifact flags should affect checksum", func(t *testing.T) {
 		stg := newStage()
 		originalChecksum, err := stg.CalculateChecksum()
@@ -150,4 +188,56 @@ func TestCalculateChecksum(t *testing.T) {
 			t.Fatal("changing stage.Inputs should have affected checksum")
 		}
 	})
+
+	t.Run("stage shell and environment should affect checksum", func(t *testing.T) {
+		changes := map[string]func(*Stage){
+			"shell":     func(stg *Stage) { stg.Shell = "bash" },
+			"env":       func(stg *Stage) { stg.Env = map[string]string{"SEED": "1"} },
+			"env value": func(stg *Stage) { stg.Env = map[string]string{"SEED": "2"} },
+			"clear-env": func(stg *Stage) { stg.ClearEnv = true },
+		}
+		checksums := make(map[string]string)
+		for name, change := range changes {
+			stg := newStage()
+			change(&stg)
+			newChecksum, err := stg.CalculateChecksum()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for otherName, otherChecksum := range checksums {
+				if newChecksum == otherChecksum {
+					t.Fatalf("changing %s and %s resulted in the same checksum", name, otherName)
+				}
+			}
+			checksums[name] = newChecksum
+		}
+		stg := newStage()
+		originalChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, newChecksum := range checksums {
+			if newChecksum == originalChecksum {
+				t.Fatalf("changing %s should have affected checksum", name)
+			}
+		}
+	})
+
+	t.Run("stages without shell or environment keep their checksums", func(t *testing.T) {
+		stg := Stage{
+			Command:    "echo hi",
+			WorkingDir: "dir",
+			Outputs: map[string]*artifact.Artifact{
+				"foo.txt": {Path: "foo.txt"},
+			},
+		}
+		checksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "65703e9da22d30fc112e66ff2a0e060764b66c533373e0b47c149ce274a79c66"
+		if diff := cmp.Diff(want, checksum); diff != "" {
+			t.Fatalf("CalculateChecksum -want +got:\n%s", diff)
+		}
+	})
 }
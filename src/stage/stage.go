@@ -8,10 +8,13 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/kevin-hanselman/dud/src/artifact"
 	"github.com/kevin-hanselman/dud/src/checksum"
+	"github.com/kevin-hanselman/dud/src/glob"
 	"github.com/pkg/errors"
 
 	"gopkg.in/yaml.v2"
@@ -32,6 +35,17 @@ type Stage struct {
 	// directory. WorkingDir only affects the Stage's command; all inputs and
 	// outputs of the Stage should have paths relative to the project root.
 	WorkingDir string `yaml:"working-dir,omitempty"`
+	// Shell is the shell that runs Command, followed by any arguments, as in
+	// "bash -eu". The shell and its arguments are split on whitespace, without
+	// any quoting. Command is passed to the shell after a "-c" argument. An
+	// empty value means "sh".
+	Shell string `yaml:",omitempty" json:",omitempty"`
+	// Env holds environment variables set for Command, in addition to the
+	// variables inherited from Dud. Values are used as-is, without expansion.
+	Env map[string]string `yaml:",omitempty" json:",omitempty"`
+	// ClearEnv, if true, keeps Command from inheriting Dud's environment,
+	// except for the variables in minimalEnv.
+	ClearEnv bool `yaml:"clear-env,omitempty" json:",omitempty"`
 	// Inputs is a set of Artifacts which the Stage's Command needs to
 	// operate. The Artifacts are keyed by their Path for faster lookup.
 	Inputs map[string]*artifact.Artifact `yaml:",omitempty"`
@@ -40,6 +54,17 @@ type Stage struct {
 	Outputs map[string]*artifact.Artifact
 }
 
+// minimalEnv lists the environment variables Stage commands inherit when
+// their Stage's ClearEnv field is true. Without them, few commands would run.
+var minimalEnv = []string{"HOME", "PATH", "TMPDIR", "USER"}
+
+func init() {
+	// Many Windows programs fail without SYSTEMROOT.
+	if runtime.GOOS == "windows" {
+		minimalEnv = append(minimalEnv, "SYSTEMROOT")
+	}
+}
+
 // Status holds everything necessary to qualify the state of a Stage.
 type Status struct {
 	// HasChecksum is true if the Stage had a non-empty Checksum field.
@@ -61,15 +86,22 @@ func (stg Stage) toFileFormat() (out Stage) {
 	out.Checksum = stg.Checksum
 	out.Command = stg.Command
 	out.WorkingDir = stg.WorkingDir
+	out.Shell = stg.Shell
+	out.Env = stg.Env
+	out.ClearEnv = stg.ClearEnv
 
 	if len(stg.Inputs) > 0 {
 		out.Inputs = make(map[string]*artifact.Artifact, len(stg.Inputs))
-		for _, art := range stg.Inputs {
+		for _, inputArt := range stg.Inputs {
+			// Copy the Artifact so the Stage itself isn't modified.
+			art := new(artifact.Artifact)
+			*art = *inputArt
 			// SkipCache is implicitly true for all inputs. It's
 			// redundant and noisy to write it to the Stage file, so we hide
 			// it (making use of the 'omitempty' YAML directive) and set
 			// SkipCache to true when loading the file (see FromFile).
 			art.SkipCache = false
+			hideGlobIsDir(art)
 			path := art.Path
 			art.Path = ""
 			out.Inputs[path] = art
@@ -78,7 +110,10 @@ func (stg Stage) toFileFormat() (out Stage) {
 
 	if len(stg.Outputs) > 0 {
 		out.Outputs = make(map[string]*artifact.Artifact, len(stg.Outputs))
-		for _, art := range stg.Outputs {
+		for _, outputArt := range stg.Outputs {
+			art := new(artifact.Artifact)
+			*art = *outputArt
+			hideGlobIsDir(art)
 			path := art.Path
 			art.Path = ""
 			out.Outputs[path] = art
@@ -87,16 +122,28 @@ func (stg Stage) toFileFormat() (out Stage) {
 	return
 }
 
+// hideGlobIsDir hides IsDir for glob Artifacts, which are always directory
+// Artifacts. Like SkipCache for inputs, it's set when loading the file (see
+// FromFile).
+func hideGlobIsDir(art *artifact.Artifact) {
+	if art.IsGlob() {
+		art.IsDir = false
+	}
+}
+
 var fromYamlFile = func(path string, stg *Stage) error {
 	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	decoder := yaml.NewDecoder(file)
+	return fromYaml(file, path, stg)
+}
+
+func fromYaml(reader io.Reader, path string, stg *Stage) error {
+	decoder := yaml.NewDecoder(reader)
 	decoder.SetStrict(true)
-	err = decoder.Decode(stg)
-	if err != nil {
+	if err := decoder.Decode(stg); err != nil {
 		return errors.Wrap(err, path)
 	}
 	return nil
@@ -108,8 +155,29 @@ func FromFile(stagePath string) (stg Stage, err error) {
 	if err = fromYamlFile(stagePath, &tempStage); err != nil {
 		return
 	}
+	return fromFileFormat(tempStage, stagePath)
+}
+
+// FromReader loads a Stage from YAML read from reader. Because the Stage
+// does not necessarily come from a file on disk (e.g. it may be read from
+// a past revision in source control), stagePath is only used to validate the
+// Stage and to annotate errors.
+func FromReader(reader io.Reader, stagePath string) (stg Stage, err error) {
+	var tempStage Stage
+	if err = fromYaml(reader, stagePath, &tempStage); err != nil {
+		return
+	}
+	return fromFileFormat(tempStage, stagePath)
+}
+
+// fromFileFormat is the inverse of toFileFormat. It cleans and validates
+// a Stage freshly decoded from YAML.
+func fromFileFormat(tempStage Stage, stagePath string) (stg Stage, err error) {
 	stg.Checksum = tempStage.Checksum
 	stg.Command = strings.TrimSpace(tempStage.Command)
+	stg.Shell = strings.TrimSpace(tempStage.Shell)
+	stg.Env = tempStage.Env
+	stg.ClearEnv = tempStage.ClearEnv
 	stg.Inputs = make(map[string]*artifact.Artifact, len(stg.Inputs))
 	stg.Outputs = make(map[string]*artifact.Artifact, len(stg.Outputs))
 
@@ -126,6 +194,7 @@ func FromFile(stagePath string) (stg Stage, err error) {
 			art = new(artifact.Artifact)
 		}
 		art.Path = filepath.Clean(path)
+		art.IsDir = art.IsDir || art.IsGlob()
 		// Inputs are only committed-to/checked-out-of the Cache if they
 		// are an output of (i.e. owned by) another Stage, in which case said
 		// owner Stage is responsible for interacting with the Cache.
@@ -137,6 +206,7 @@ func FromFile(stagePath string) (stg Stage, err error) {
 			art = new(artifact.Artifact)
 		}
 		art.Path = filepath.Clean(path)
+		art.IsDir = art.IsDir || art.IsGlob()
 		stg.Outputs[art.Path] = art
 	}
 
@@ -158,6 +228,11 @@ func (stg Stage) Validate(stagePath string) error {
 	if len(stg.Inputs)+len(stg.Outputs) == 0 {
 		return errors.New("declared no inputs and no outputs")
 	}
+	for name := range stg.Env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("invalid environment variable name %#v", name)
+		}
+	}
 	if len(stg.Outputs)+len(stg.Command) == 0 {
 		return errors.New("declared no outputs and no command")
 	}
@@ -194,6 +269,15 @@ func (stg Stage) Validate(stagePath string) error {
 		if filepath.IsAbs(artPath) {
 			return fmt.Errorf("artifact %s is an absolute path", artPath)
 		}
+		if glob.HasMeta(artPath) {
+			base, _, err := glob.Split(filepath.ToSlash(artPath))
+			if err != nil {
+				return errors.Wrapf(err, "artifact %s", artPath)
+			}
+			if base == "" {
+				return fmt.Errorf("glob artifact %s must start with a directory", artPath)
+			}
+		}
 		parentArt, ok := FindDirArtifactOwnerForPath(artPath, allArtifacts)
 		if ok {
 			return fmt.Errorf(
@@ -228,22 +312,32 @@ func (stg *Stage) ToFile(path string) error {
 }
 
 // CalculateChecksum returns the checksum of the Stage as it would be set in
-// the Checksum field.
+// the Checksum field. Like Artifact checksums, Artifacts' executable bits are
+// excluded; changes to them are reported by the Artifacts' statuses. Whether
+// Artifacts are chunked is excluded as well, as it's decided at commit time
+// (see the chunk-threshold config field).
 func (stg Stage) CalculateChecksum() (string, error) {
 	cleanStage := Stage{
 		Command:    stg.Command,
 		WorkingDir: stg.WorkingDir,
+		Shell:      stg.Shell,
+		Env:        stg.Env,
+		ClearEnv:   stg.ClearEnv,
 	}
 	cleanStage.Inputs = make(map[string]*artifact.Artifact, len(stg.Inputs))
 	for _, art := range stg.Inputs {
 		newArt := *art
 		newArt.Checksum = ""
+		newArt.Executable = false
+		newArt.IsChunked = false
 		cleanStage.Inputs[art.Path] = &newArt
 	}
 	cleanStage.Outputs = make(map[string]*artifact.Artifact, len(stg.Outputs))
 	for _, art := range stg.Outputs {
 		newArt := *art
 		newArt.Checksum = ""
+		newArt.Executable = false
+		newArt.IsChunked = false
 		cleanStage.Outputs[art.Path] = &newArt
 	}
 	// We can't use encoding/gob here because maps aren't serialized in
@@ -258,20 +352,63 @@ func (stg Stage) CalculateChecksum() (string, error) {
 
 // CreateCommand return an exec.Cmd for the Stage.
 func (stg Stage) CreateCommand() *exec.Cmd {
-	cmd := exec.Command("sh", "-c", stg.Command)
+	shell := strings.Fields(stg.Shell)
+	if len(shell) == 0 {
+		shell = []string{"sh"}
+	}
+	cmd := exec.Command(shell[0], append(shell[1:], "-c", stg.Command)...)
 	cmd.Dir = filepath.Clean(stg.WorkingDir)
+	cmd.Env = stg.environ()
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd
 }
 
+// environ returns the environment for the Stage's command, or nil if the
+// command simply inherits Dud's environment.
+func (stg Stage) environ() []string {
+	if !stg.ClearEnv && len(stg.Env) == 0 {
+		return nil
+	}
+	var env []string
+	if stg.ClearEnv {
+		for _, name := range minimalEnv {
+			if value, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+value)
+			}
+		}
+	} else {
+		env = os.Environ()
+	}
+	names := make([]string, 0, len(stg.Env))
+	for name := range stg.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// Later values override earlier ones; see exec.Cmd.Env.
+	for _, name := range names {
+		env = append(env, name+"="+stg.Env[name])
+	}
+	return env
+}
+
 // FindDirArtifactOwnerForPath searches the given map for a directory Artifact
 // that should own relPath. relPath should share a base with the Artifacts in
-// the map (hence the name).
+// the map (hence the name). Glob Artifacts own the paths their patterns match.
 func FindDirArtifactOwnerForPath(
 	relPath string,
 	artifacts map[string]*artifact.Artifact,
 ) (*artifact.Artifact, bool) {
+	for artPath, owner := range artifacts {
+		if artPath == relPath || !glob.HasMeta(artPath) {
+			continue
+		}
+		// Invalid patterns are caught by Stage.Validate.
+		pattern, err := glob.Compile(filepath.ToSlash(artPath))
+		if err == nil && pattern.Match(filepath.ToSlash(relPath)) {
+			return owner, true
+		}
+	}
 	// Search for an Artifact whose Path is any directory in the input's
 	// lineage. For example: given "bish/bash/bosh/file.txt", look for "bish",
 	// then "bish/bash", then "bish/bash/bosh".
@@ -2,6 +2,7 @@ package stage
 
 import (
 	"os"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -415,4 +416,172 @@ func TestFromFile(t *testing.T) {
 			t.Fatalf("error -want +got:\n%s", diff)
 		}
 	})
+
+	t.Run("glob artifacts", func(t *testing.T) {
+		defer resetFromYamlFileMock()
+
+		load := func(stageFile Stage) (Stage, error) {
+			fromYamlFile = func(path string, output *Stage) error {
+				*output = stageFile
+				return nil
+			}
+			stg, err := FromFile("stage.yaml")
+			return stg, errors.Cause(err)
+		}
+
+		t.Run("are directory artifacts", func(t *testing.T) {
+			stg, err := load(Stage{
+				Command: "make reports",
+				Outputs: map[string]*artifact.Artifact{
+					"./reports/*.html": {},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]*artifact.Artifact{
+				"reports/*.html": {Path: "reports/*.html", IsDir: true},
+			}
+			if diff := cmp.Diff(want, stg.Outputs); diff != "" {
+				t.Fatalf("Outputs -want +got:\n%s", diff)
+			}
+		})
+
+		t.Run("own the files they match", func(t *testing.T) {
+			_, err := load(Stage{
+				Inputs: map[string]*artifact.Artifact{
+					"reports/index.html": {},
+				},
+				Outputs: map[string]*artifact.Artifact{
+					"reports/*.html": {},
+				},
+			})
+			want := "conflicts with artifact reports/*.html"
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Fatalf("error want: %v got: %v", want, err)
+			}
+		})
+
+		t.Run("must start with a directory", func(t *testing.T) {
+			_, err := load(Stage{
+				Outputs: map[string]*artifact.Artifact{
+					"*.html": {},
+				},
+			})
+			want := "glob artifact *.html must start with a directory"
+			if err == nil || err.Error() != want {
+				t.Fatalf("error want: %v got: %v", want, err)
+			}
+		})
+	})
+
+	t.Run("shell and environment", func(t *testing.T) {
+		defer resetFromYamlFileMock()
+
+		load := func(stageFile Stage) (Stage, error) {
+			fromYamlFile = func(path string, output *Stage) error {
+				*output = stageFile
+				return nil
+			}
+			stg, err := FromFile("stage.yaml")
+			return stg, errors.Cause(err)
+		}
+
+		t.Run("are loaded", func(t *testing.T) {
+			stg, err := load(Stage{
+				Command:  "echo $SEED",
+				Shell:    " bash -eu ",
+				Env:      map[string]string{"SEED": "1"},
+				ClearEnv: true,
+				Outputs:  map[string]*artifact.Artifact{"foo.txt": {}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Stage{
+				Command:    "echo $SEED",
+				WorkingDir: ".",
+				Shell:      "bash -eu",
+				Env:        map[string]string{"SEED": "1"},
+				ClearEnv:   true,
+				Inputs:     map[string]*artifact.Artifact{},
+				Outputs:    map[string]*artifact.Artifact{"foo.txt": {Path: "foo.txt"}},
+			}
+			if diff := cmp.Diff(want, stg); diff != "" {
+				t.Fatalf("Stage -want +got:\n%s", diff)
+			}
+		})
+
+		t.Run("invalid variable names cause error", func(t *testing.T) {
+			_, err := load(Stage{
+				Command: "echo",
+				Env:     map[string]string{"A=B": "1"},
+				Outputs: map[string]*artifact.Artifact{"foo.txt": {}},
+			})
+			want := `invalid environment variable name "A=B"`
+			if err == nil || err.Error() != want {
+				t.Fatalf("error want: %v got: %v", want, err)
+			}
+		})
+	})
+}
+
+func TestCreateCommand(t *testing.T) {
+	t.Setenv("DUD_TEST_INHERITED", "yes")
+	t.Setenv("PATH", "/bin")
+	t.Setenv("SYSTEMROOT", `C:\Windows`)
+
+	t.Run("defaults to sh and the inherited environment", func(t *testing.T) {
+		cmd := Stage{Command: "echo hi", WorkingDir: "dir"}.CreateCommand()
+		if diff := cmp.Diff([]string{"sh", "-c", "echo hi"}, cmd.Args); diff != "" {
+			t.Fatalf("Args -want +got:\n%s", diff)
+		}
+		if cmd.Env != nil {
+			t.Fatalf("expected inherited environment, got %v", cmd.Env)
+		}
+	})
+
+	t.Run("uses shell", func(t *testing.T) {
+		cmd := Stage{Command: "echo hi", Shell: "bash -eu"}.CreateCommand()
+		if diff := cmp.Diff([]string{"bash", "-eu", "-c", "echo hi"}, cmd.Args); diff != "" {
+			t.Fatalf("Args -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("adds env to the inherited environment", func(t *testing.T) {
+		cmd := Stage{
+			Command: "echo hi",
+			Env:     map[string]string{"SEED": "1", "PATH": "/usr/bin"},
+		}.CreateCommand()
+		env := strings.Join(cmd.Env, "\n")
+		for _, want := range []string{"DUD_TEST_INHERITED=yes", "SEED=1"} {
+			if !strings.Contains(env, want) {
+				t.Fatalf("expected %s in environment, got %v", want, cmd.Env)
+			}
+		}
+		// Stage variables come last, so they override inherited ones.
+		if !strings.HasSuffix(env, "PATH=/usr/bin\nSEED=1") {
+			t.Fatalf("expected stage variables last in sorted order, got %v", cmd.Env)
+		}
+	})
+
+	t.Run("clear-env keeps only minimal variables", func(t *testing.T) {
+		cmd := Stage{
+			Command:  "echo hi",
+			Env:      map[string]string{"SEED": "1"},
+			ClearEnv: true,
+		}.CreateCommand()
+		for _, variable := range cmd.Env {
+			if strings.HasPrefix(variable, "DUD_TEST_INHERITED=") ||
+				(runtime.GOOS != "windows" && strings.HasPrefix(variable, "SYSTEMROOT=")) {
+				t.Fatalf("expected environment to be cleared, got %v", cmd.Env)
+			}
+		}
+		env := strings.Join(cmd.Env, "\n")
+		for _, want := range []string{"PATH=/bin", "SEED=1"} {
+			if !strings.Contains(env, want) {
+				t.Fatalf("expected %s in environment, got %v", want, cmd.Env)
+			}
+		}
+	})
 }
@@ -1,5 +1,7 @@
 package strategy
 
+import "fmt"
+
 // CheckoutStrategy enumerates the strategies for checking out files from the cache
 type CheckoutStrategy int
 
@@ -8,8 +10,48 @@ const (
 	LinkStrategy CheckoutStrategy = iota
 	// CopyStrategy creates copies of files in the cache
 	CopyStrategy
+	// HardlinkStrategy creates read-only hard links to files in the cache. The
+	// cache and the workspace must be on the same filesystem.
+	HardlinkStrategy
+	// ReflinkStrategy creates copy-on-write clones of files in the cache on
+	// filesystems that support them (e.g. Btrfs, XFS, and APFS), and falls
+	// back to copying on filesystems that don't.
+	ReflinkStrategy
 )
 
+// names maps the names used on the command line to each CheckoutStrategy.
+var names = map[string]CheckoutStrategy{
+	"symlink":  LinkStrategy,
+	"copy":     CopyStrategy,
+	"hardlink": HardlinkStrategy,
+	"reflink":  ReflinkStrategy,
+}
+
 func (strat CheckoutStrategy) String() string {
-	return [...]string{"LinkStrategy", "CopyStrategy"}[strat]
+	return [...]string{
+		"LinkStrategy",
+		"CopyStrategy",
+		"HardlinkStrategy",
+		"ReflinkStrategy",
+	}[strat]
+}
+
+// IsLink returns true if the strategy links workspace files to the cache
+// (i.e. LinkStrategy and HardlinkStrategy), as opposed to creating
+// independent files.
+func (strat CheckoutStrategy) IsLink() bool {
+	return strat == LinkStrategy || strat == HardlinkStrategy
+}
+
+// FromName returns the CheckoutStrategy with the given name. Valid names are
+// "symlink", "hardlink", "reflink", and "copy".
+func FromName(name string) (CheckoutStrategy, error) {
+	strat, ok := names[name]
+	if !ok {
+		return strat, fmt.Errorf(
+			"unknown checkout strategy %#v; expected symlink, hardlink, reflink, or copy",
+			name,
+		)
+	}
+	return strat, nil
 }